@@ -128,6 +128,22 @@ func (xb *XMLBackend) GetCatBreedByName(b string) (*models.CatBreed, error) {
 
 // -----------------------------
 
+// DatabaseBackend reads cat breeds straight from the cat_breeds table through the models repository,
+// so we don't need the remote service running. The models package must be set up first (configuration.New does this)
+type DatabaseBackend struct{}
+
+func (db *DatabaseBackend) GetAllCatBreeds() ([]*models.CatBreed, error) {
+	var c models.CatBreed
+	return c.All()
+}
+
+func (db *DatabaseBackend) GetCatBreedByName(b string) (*models.CatBreed, error) {
+	var c models.CatBreed
+	return c.GetBreedByName(b)
+}
+
+// -----------------------------
+
 type TestBackend struct{}
 
 func (tb *TestBackend) GetAllCatBreeds() ([]*models.CatBreed, error) {
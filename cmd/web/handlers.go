@@ -1,11 +1,16 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"go-breeders/models"
 	"go-breeders/pets"
+	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -65,6 +70,118 @@ func (app *application) GetAllDogBreedsJSON(w http.ResponseWriter, r *http.Reque
 
 	_ = t.WriteJSON(w, http.StatusOK, dogBreeds)
 }
+
+func (app *application) GetDogBreedByIDJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	breed, err := app.App.Models.DogBreed.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, breed)
+}
+
+func (app *application) CreateDogBreedJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	var breed models.DogBreed
+	if err := t.ReadJSON(w, r, &breed); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := validateDogBreed(&breed); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := breed.Insert(); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	breed.AverageWeight = (breed.WeightLowLbs + breed.WeightHighLbs) / 2
+
+	_ = t.WriteJSON(w, http.StatusCreated, breed)
+}
+
+func (app *application) UpdateDogBreedJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the breed exists before we try to update it
+	if _, err := app.App.Models.DogBreed.Get(id); err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	var breed models.DogBreed
+	if err := t.ReadJSON(w, r, &breed); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// The id in the url always wins over one sent in the body
+	breed.ID = id
+
+	if err := validateDogBreed(&breed); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := breed.Update(); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	breed.AverageWeight = (breed.WeightLowLbs + breed.WeightHighLbs) / 2
+
+	_ = t.WriteJSON(w, http.StatusOK, breed)
+}
+
+func (app *application) DeleteDogBreedJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	breed := models.DogBreed{ID: id}
+	if err := breed.Delete(); err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, toolbox.JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("dog breed %d deleted", id),
+	})
+}
+
+// statusFromDBError maps a repository error to the response code we send back
+func statusFromDBError(err error) int {
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
 func (app *application) CreateDogWithBuilder(w http.ResponseWriter, r *http.Request) {
 	var t toolbox.Tools
 
@@ -142,34 +259,732 @@ func (app *application) AnimalFromAbstractFactory(w http.ResponseWriter, r *http
 	_ = t.WriteJSON(w, http.StatusOK, pet)
 }
 
+// breederList is the response for a page of breeders
+type breederList struct {
+	Breeders []*models.Breeder `json:"breeders"`
+	pagination
+}
+
+// breederFilterFromRequest builds a breeder filter out of the query string (species, country, prov_state, q, active, page, page_size)
+func breederFilterFromRequest(r *http.Request) (*models.BreederFilter, error) {
+	q := r.URL.Query()
+	page, pageSize := pageFromRequest(r)
+
+	f := &models.BreederFilter{
+		Species:    q.Get("species"),
+		Country:    strings.TrimSpace(q.Get("country")),
+		ProvState:  strings.TrimSpace(q.Get("prov_state")),
+		Search:     strings.TrimSpace(q.Get("q")),
+		ActiveOnly: q.Get("active") == "1" || q.Get("active") == "true",
+		Page:       page,
+		PageSize:   pageSize,
+	}
+
+	if f.Species != "" && f.Species != "dog" && f.Species != "cat" {
+		return nil, errors.New("species must be dog or cat")
+	}
+
+	return f, nil
+}
+
+func (app *application) GetAllBreedersJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	f, err := breederFilterFromRequest(r)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	breeders, total, err := app.App.Models.Breeder.All(f)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if breeders == nil {
+		breeders = []*models.Breeder{}
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, breederList{
+		Breeders:   breeders,
+		pagination: newPagination(r, f.Page, f.Limit(), total),
+	})
+}
+
+func (app *application) GetBreederByIDJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	breeder, err := app.App.Models.Breeder.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, breeder)
+}
+
+func (app *application) DogBreeders(w http.ResponseWriter, r *http.Request) {
+	app.showBreeders(w, r, "dog", "dog-breeders.page.tmpl")
+}
+
+func (app *application) CatBreeders(w http.ResponseWriter, r *http.Request) {
+	app.showBreeders(w, r, "cat", "cat-breeders.page.tmpl")
+}
+
+// showBreeders renders a directory page of the active breeders for one species
+func (app *application) showBreeders(w http.ResponseWriter, r *http.Request, species, page string) {
+	f, err := breederFilterFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.Species = species
+	f.ActiveOnly = true
+
+	breeders, total, err := app.App.Models.Breeder.All(f)
+	if err != nil {
+		log.Println("Error getting breeders:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := make(map[string]any)
+	data["breeders"] = breeders
+	data["filter"] = f
+	data["pagination"] = newPagination(r, f.Page, f.Limit(), total)
+
+	app.render(w, page, &templateData{Data: data})
+}
+
+func (app *application) ShowBreeder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	breeder, err := app.App.Models.Breeder.Get(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Println("Error getting breeder:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data := make(map[string]any)
+	data["breeder"] = breeder
+
+	app.render(w, "breeder.page.tmpl", &templateData{Data: data})
+}
+
+// petFilterFromRequest builds a dog or cat filter out of the query string
+// (breed_id, breeder_id, color, min_age, max_age, spayed_neutered)
+func petFilterFromRequest(r *http.Request) (*models.PetFilter, error) {
+	q := r.URL.Query()
+	f := &models.PetFilter{
+		Color: strings.TrimSpace(q.Get("color")),
+	}
+
+	intParams := []struct {
+		name string
+		dest *int
+	}{
+		{"breed_id", &f.BreedID},
+		{"breeder_id", &f.BreederID},
+	}
+
+	for _, p := range intParams {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", p.name)
+			}
+			*p.dest = n
+		}
+	}
+
+	optionalParams := []struct {
+		name string
+		dest **int
+	}{
+		{"min_age", &f.MinAge},
+		{"max_age", &f.MaxAge},
+		{"spayed_neutered", &f.SpayedOrNeutered},
+	}
+
+	for _, p := range optionalParams {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a positive number", p.name)
+			}
+			*p.dest = &n
+		}
+	}
+
+	if f.MinAge != nil && f.MaxAge != nil && *f.MinAge > *f.MaxAge {
+		return nil, errors.New("min_age must be less than or equal to max_age")
+	}
+
+	return f, nil
+}
+
+// checkReference looks up a row a dog or cat points at, turning a missing row into a validation error
+func checkReference(name string, id int, get func(int) error) (int, error) {
+	if id == 0 {
+		return 0, nil
+	}
+
+	if err := get(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return http.StatusUnprocessableEntity, fmt.Errorf("%s %d does not exist", name, id)
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// checkDogReferences makes sure the breed and breeder a dog points at exist
+func (app *application) checkDogReferences(d *models.Dog) (int, error) {
+	status, err := checkReference("breed_id", d.BreedID, func(id int) error {
+		_, err := app.App.Models.DogBreed.Get(id)
+		return err
+	})
+	if err != nil {
+		return status, err
+	}
+
+	return checkReference("breeder_id", d.BreederID, func(id int) error {
+		_, err := app.App.Models.Breeder.Get(id)
+		return err
+	})
+}
+
+// checkCatReferences makes sure the breed and breeder a cat points at exist
+func (app *application) checkCatReferences(c *models.Cat) (int, error) {
+	status, err := checkReference("breed_id", c.BreedID, func(id int) error {
+		_, err := app.App.Models.CatBreed.Get(id)
+		return err
+	})
+	if err != nil {
+		return status, err
+	}
+
+	return checkReference("breeder_id", c.BreederID, func(id int) error {
+		_, err := app.App.Models.Breeder.Get(id)
+		return err
+	})
+}
+
+func (app *application) GetAllDogsJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	f, err := petFilterFromRequest(r)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	dogs, err := app.App.Models.Dog.All(f)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if dogs == nil {
+		dogs = []*models.Dog{}
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, dogs)
+}
+
+func (app *application) GetDogByIDJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	dog, err := app.App.Models.Dog.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, dog)
+}
+
+func (app *application) CreateDogJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	var dog models.Dog
+	if err := t.ReadJSON(w, r, &dog); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := validateDog(&dog); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if status, err := app.checkDogReferences(&dog); err != nil {
+		_ = t.ErrorJSON(w, err, status)
+		return
+	}
+
+	if err := dog.Insert(); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Send back the dog as it is stored, with its breed and breeder filled in
+	saved, err := app.App.Models.Dog.Get(dog.ID)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusCreated, saved)
+}
+
+func (app *application) UpdateDogJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.App.Models.Dog.Get(id); err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	var dog models.Dog
+	if err := t.ReadJSON(w, r, &dog); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	dog.ID = id
+
+	if err := validateDog(&dog); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if status, err := app.checkDogReferences(&dog); err != nil {
+		_ = t.ErrorJSON(w, err, status)
+		return
+	}
+
+	if err := dog.Update(); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.App.Models.Dog.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, saved)
+}
+
+func (app *application) DeleteDogJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	dog := models.Dog{ID: id}
+	if err := dog.Delete(); err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, toolbox.JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("dog %d deleted", id),
+	})
+}
+
+func (app *application) GetAllCatsJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	f, err := petFilterFromRequest(r)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	cats, err := app.App.Models.Cat.All(f)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if cats == nil {
+		cats = []*models.Cat{}
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, cats)
+}
+
+func (app *application) GetCatByIDJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	cat, err := app.App.Models.Cat.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, cat)
+}
+
+func (app *application) CreateCatJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	var cat models.Cat
+	if err := t.ReadJSON(w, r, &cat); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := validateCat(&cat); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if status, err := app.checkCatReferences(&cat); err != nil {
+		_ = t.ErrorJSON(w, err, status)
+		return
+	}
+
+	if err := cat.Insert(); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.App.Models.Cat.Get(cat.ID)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusCreated, saved)
+}
+
+func (app *application) UpdateCatJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.App.Models.Cat.Get(id); err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	var cat models.Cat
+	if err := t.ReadJSON(w, r, &cat); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	cat.ID = id
+
+	if err := validateCat(&cat); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if status, err := app.checkCatReferences(&cat); err != nil {
+		_ = t.ErrorJSON(w, err, status)
+		return
+	}
+
+	if err := cat.Update(); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.App.Models.Cat.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, saved)
+}
+
+func (app *application) DeleteCatJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	cat := models.Cat{ID: id}
+	if err := cat.Delete(); err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, toolbox.JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("cat %d deleted", id),
+	})
+}
+
 func (app *application) DogOfMonth(w http.ResponseWriter, r *http.Request) {
-	// Get the breed
-	breed, _ := app.App.Models.DogBreed.GetBreedByName("German Shepherd Dog")
-
-	// Get the dog of the month from database
-	dom, _ := app.App.Models.Dog.GetDogOfMonthByID(1)
-
-	dob, _ := time.Parse("2006-01-02", "2014-05-14")
-	// Create the dog and decorate it
-	dog := models.DogOfMonth{
-		Dog: &models.Dog{
-			ID:               1,
-			DogName:          "Leo",
-			BreedID:          breed.ID,
-			Color:            "White",
-			DateOfBirth:      dob,
-			SpayedOrNeutered: 0,
-			Description:      "Leo is a very good boy",
-			Weight:           20,
-			Breed:            *breed,
-		},
-		Video: dom.Video,
-		Image: dom.Image,
+	// Get this month's dog from the database, with its breed and breeder
+	dom, err := app.App.Models.DogOfMonth.Current()
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println("Error getting dog of the month:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// and the dogs from the months before it
+	history, err := app.App.Models.DogOfMonth.History(13)
+	if err != nil {
+		log.Println("Error getting dog of the month history:", err)
+	}
+
+	var previous []*models.DogOfMonth
+	for _, h := range history {
+		if dom == nil || h.ID != dom.ID {
+			previous = append(previous, h)
+		}
 	}
 
 	// Serve the webpage
 	data := make(map[string]any)
-	data["dog"] = dog
+	data["dog"] = dom
+	data["history"] = previous
+	data["video"] = app.domPlayback(dom)
 
 	app.render(w, "dog-of-month.page.tmpl", &templateData{Data: data})
 }
+
+func (app *application) CurrentDogOfMonthJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	dom, err := app.App.Models.DogOfMonth.Current()
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, dom)
+}
+
+func (app *application) DogOfMonthHistoryJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 120 {
+		limit = 12
+	}
+
+	history, err := app.App.Models.DogOfMonth.History(limit)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if history == nil {
+		history = []*models.DogOfMonth{}
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, history)
+}
+
+// AllDogsOfMonthJSON lists every dog of the month, including the ones scheduled for later (admin)
+func (app *application) AllDogsOfMonthJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	doms, err := app.App.Models.DogOfMonth.All()
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if doms == nil {
+		doms = []*models.DogOfMonth{}
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, doms)
+}
+
+// checkMonthIsFree makes sure no other entry already holds the month the dom is for
+func (app *application) checkMonthIsFree(dom *models.DogOfMonth) (int, error) {
+	existing, err := app.App.Models.DogOfMonth.GetByMonth(dom.Year, dom.Month)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, nil
+	case err != nil:
+		return http.StatusInternalServerError, err
+	case existing.ID != dom.ID:
+		return http.StatusConflict, fmt.Errorf("%s %d already has a dog of the month", existing.MonthName(), existing.Year)
+	}
+
+	return 0, nil
+}
+
+// ScheduleDogOfMonthJSON picks a dog for a month, this one or a future one (admin)
+func (app *application) ScheduleDogOfMonthJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	var dom models.DogOfMonth
+	if err := t.ReadJSON(w, r, &dom); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	dom.ID = 0
+	dom.Dog = nil
+
+	if err := validateDogOfMonth(&dom, time.Now(), true); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if status, err := checkReference("dog_id", dom.DogID, func(id int) error {
+		_, err := app.App.Models.Dog.Get(id)
+		return err
+	}); err != nil {
+		_ = t.ErrorJSON(w, err, status)
+		return
+	}
+
+	if status, err := app.checkMonthIsFree(&dom); err != nil {
+		_ = t.ErrorJSON(w, err, status)
+		return
+	}
+
+	if err := dom.Insert(); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.App.Models.DogOfMonth.Get(dom.ID)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusCreated, saved)
+}
+
+// UpdateDogOfMonthJSON changes an entry. Fields the request leaves out keep what is saved, and it can only be moved to
+// a month that hasn't passed yet (admin)
+func (app *application) UpdateDogOfMonthJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := app.App.Models.DogOfMonth.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	// the request is read over what is saved, so anything it leaves out, like a video attached by an encode, is kept
+	dom := *existing
+	if err := t.ReadJSON(w, r, &dom); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	dom.ID = id
+	dom.Dog = nil
+
+	moved := dom.Year != existing.Year || dom.Month != existing.Month
+	if err := validateDogOfMonth(&dom, time.Now(), moved); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if status, err := checkReference("dog_id", dom.DogID, func(id int) error {
+		_, err := app.App.Models.Dog.Get(id)
+		return err
+	}); err != nil {
+		_ = t.ErrorJSON(w, err, status)
+		return
+	}
+
+	if moved {
+		if status, err := app.checkMonthIsFree(&dom); err != nil {
+			_ = t.ErrorJSON(w, err, status)
+			return
+		}
+	}
+
+	if err := dom.Update(); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.App.Models.DogOfMonth.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, saved)
+}
+
+// DeleteDogOfMonthJSON removes an entry (admin)
+func (app *application) DeleteDogOfMonthJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	dom := models.DogOfMonth{ID: id}
+	if err := dom.Delete(); err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, toolbox.JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("dog of the month %d deleted", id),
+	})
+}
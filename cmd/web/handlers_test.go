@@ -1,9 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-breeders/models"
+	"go-breeders/streamer"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func TestApplication_GetAllDogBreedsJSON(t *testing.T) {
@@ -43,3 +60,1339 @@ func TestApplication_GetAllCatBreeds(t *testing.T) {
 		t.Errorf("wrong response code, got %d wanted 200", rr.Code)
 	}
 }
+
+func TestApplication_CreateDogBreedJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"valid breed", `{"breed": "Test Dog", "weight_low_lbs": 10, "weight_high_lbs": 20, "average_lifespan": 12}`, http.StatusCreated},
+		{"missing breed", `{"breed": "", "weight_low_lbs": 10, "weight_high_lbs": 20}`, http.StatusUnprocessableEntity},
+		{"weights reversed", `{"breed": "Test Dog", "weight_low_lbs": 30, "weight_high_lbs": 20}`, http.StatusUnprocessableEntity},
+		{"bad json", `{"breed": `, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/admin/dog-breeds", strings.NewReader(e.body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.CreateDogBreedJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestApplication_UpdateDogBreedJSON(t *testing.T) {
+	body := `{"breed": "Test Dog", "weight_low_lbs": 10, "weight_high_lbs": 20, "average_lifespan": 12}`
+	req, _ := http.NewRequest("PUT", "/api/admin/dog-breeds/1", strings.NewReader(body))
+
+	// add the id url param that chi would normally set for us
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(testApp.UpdateDogBreedJSON)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("wrong response code, got %d wanted 200", rr.Code)
+	}
+}
+
+func TestApplication_DeleteDogBreedJSON(t *testing.T) {
+	req, _ := http.NewRequest("DELETE", "/api/admin/dog-breeds/x", nil)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "x")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(testApp.DeleteDogBreedJSON)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong response code for a bad id, got %d wanted 400", rr.Code)
+	}
+}
+
+func TestApplication_GetAllBreedersJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+	}{
+		{"no filter", "/api/breeders", http.StatusOK},
+		{"filtered", "/api/breeders?species=dog&country=Canada&prov_state=ON&page=2", http.StatusOK},
+		{"bad species", "/api/breeders?species=fish", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.GetAllBreedersJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestNewPagination(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/dog-breeders?country=Canada&page=2", nil)
+
+	p := newPagination(req, 2, 20, 45)
+
+	if p.TotalPages != 3 {
+		t.Errorf("wrong number of pages, got %d wanted 3", p.TotalPages)
+	}
+
+	if p.PrevURL != "/dog-breeders?country=Canada&page=1" {
+		t.Errorf("wrong previous url, got %s", p.PrevURL)
+	}
+
+	if p.NextURL != "/dog-breeders?country=Canada&page=3" {
+		t.Errorf("wrong next url, got %s", p.NextURL)
+	}
+}
+
+func TestApplication_GetAllDogsJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+	}{
+		{"no filter", "/api/dogs", http.StatusOK},
+		{"all filters", "/api/dogs?breed_id=1&breeder_id=2&color=Black&min_age=1&max_age=5&spayed_neutered=1", http.StatusOK},
+		{"bad breed id", "/api/dogs?breed_id=abc", http.StatusBadRequest},
+		{"ages reversed", "/api/dogs?min_age=5&max_age=1", http.StatusBadRequest},
+		{"negative age", "/api/dogs?max_age=-1", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.GetAllDogsJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestApplication_CreateCatJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"valid cat", `{"cat_name": "Tom", "breed_id": 1, "breeder_id": 1, "color": "Grey", "date_of_birth": "2020-01-02T00:00:00Z", "weight": 10}`, http.StatusCreated},
+		{"missing name", `{"cat_name": "", "color": "Grey", "date_of_birth": "2020-01-02T00:00:00Z", "weight": 10}`, http.StatusUnprocessableEntity},
+		{"born in the future", `{"cat_name": "Tom", "color": "Grey", "date_of_birth": "2999-01-02T00:00:00Z", "weight": 10}`, http.StatusUnprocessableEntity},
+		{"bad spayed flag", `{"cat_name": "Tom", "color": "Grey", "date_of_birth": "2020-01-02T00:00:00Z", "weight": 10, "spayed_neutered": 3}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/admin/cats", strings.NewReader(e.body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.CreateCatJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestApplication_ScheduleDogOfMonthJSON(t *testing.T) {
+	nextYear := time.Now().Year() + 1
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"future month", fmt.Sprintf(`{"dog_id": 1, "month": 5, "year": %d, "image": "leo.jpg"}`, nextYear), http.StatusCreated},
+		{"past month", `{"dog_id": 1, "month": 5, "year": 2020}`, http.StatusUnprocessableEntity},
+		{"bad month", fmt.Sprintf(`{"dog_id": 1, "month": 13, "year": %d}`, nextYear), http.StatusUnprocessableEntity},
+		{"no dog", fmt.Sprintf(`{"month": 5, "year": %d}`, nextYear), http.StatusUnprocessableEntity},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/admin/dog-of-month", strings.NewReader(e.body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.ScheduleDogOfMonthJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestApplication_UpdateDogOfMonthJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		body          string
+		expectedVideo string
+		expectedImage string
+		expectedMonth int
+	}{
+		{"move the month only", "41", `{"month": 6}`, "/videos/7/leo.mp4", "leo.jpg", 6},
+		{"new image", "42", `{"image": "rex.jpg"}`, "/videos/7/leo.mp4", "rex.jpg", 1},
+		{"clear the video", "43", `{"video": ""}`, "", "leo.jpg", 1},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PUT", "/api/admin/dog-of-month/"+e.id, strings.NewReader(e.body))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.UpdateDogOfMonthJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, got %d: %s", e.name, rr.Code, rr.Body.String())
+			continue
+		}
+
+		var saved models.DogOfMonth
+		_ = json.Unmarshal(rr.Body.Bytes(), &saved)
+
+		if saved.Video != e.expectedVideo || saved.Image != e.expectedImage || saved.Month != e.expectedMonth || saved.DogID != 1 {
+			t.Errorf("%s: wrong entry saved %+v", e.name, saved)
+		}
+	}
+}
+
+func TestApplication_requireAdmin(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		configured     string
+		header         string
+		expectedStatus int
+	}{
+		{"admin api disabled", "", "Bearer secret", http.StatusForbidden},
+		{"no header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"right token", "secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.config.adminToken = e.configured
+
+		req, _ := http.NewRequest("GET", "/api/admin/dog-of-month", nil)
+		if e.header != "" {
+			req.Header.Set("Authorization", e.header)
+		}
+		rr := httptest.NewRecorder()
+
+		app.requireAdmin(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestApplication_routesAdminOnly(t *testing.T) {
+	app := testApp
+	app.config.adminToken = "secret"
+	mux := app.routes()
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		token          string
+		expectedStatus int
+	}{
+		{"create breed", "POST", "/api/admin/dog-breeds", "", http.StatusUnauthorized},
+		{"update breed", "PUT", "/api/admin/dog-breeds/1", "", http.StatusUnauthorized},
+		{"delete breed", "DELETE", "/api/admin/dog-breeds/1", "", http.StatusUnauthorized},
+		{"delete breed as admin", "DELETE", "/api/admin/dog-breeds/x", "secret", http.StatusBadRequest},
+		{"old public route", "DELETE", "/api/dog-breeds/1", "", http.StatusMethodNotAllowed},
+		{"create dog", "POST", "/api/admin/dogs", "", http.StatusUnauthorized},
+		{"update dog", "PUT", "/api/admin/dogs/1", "", http.StatusUnauthorized},
+		{"delete dog", "DELETE", "/api/admin/dogs/1", "", http.StatusUnauthorized},
+		{"delete dog as admin", "DELETE", "/api/admin/dogs/x", "secret", http.StatusBadRequest},
+		{"create cat", "POST", "/api/admin/cats", "", http.StatusUnauthorized},
+		{"update cat", "PUT", "/api/admin/cats/1", "", http.StatusUnauthorized},
+		{"delete cat", "DELETE", "/api/admin/cats/1", "", http.StatusUnauthorized},
+		{"delete cat as admin", "DELETE", "/api/admin/cats/x", "secret", http.StatusBadRequest},
+		{"video playback", "GET", "/api/admin/videos/2/playback", "", http.StatusUnauthorized},
+		{"video playback as admin", "GET", "/api/admin/videos/x/playback", "secret", http.StatusBadRequest},
+		{"old public video playback", "GET", "/api/videos/2/playback", "", http.StatusNotFound},
+		{"video key without a signed url", "GET", "/api/videos/2/key", "", http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, nil)
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+		rr := httptest.NewRecorder()
+
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+// newVideoUploadRequest builds a multipart upload of a (fake) video with the given form fields
+func newVideoUploadRequest(fileName string, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+
+	for k, v := range fields {
+		_ = mw.WriteField(k, v)
+	}
+
+	part, _ := mw.CreateFormFile("video", fileName)
+	_, _ = part.Write([]byte("not really a video, but good enough for the handler"))
+	_ = mw.Close()
+
+	req, _ := http.NewRequest("POST", "/api/videos", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return req
+}
+
+// startedVideo waits for the fake encoder to start on the video the handler queued, and returns the video as the
+// encoder was handed it
+func startedVideo(t *testing.T, started <-chan int, fake *streamer.FakeEncoder) streamer.Video {
+	t.Helper()
+
+	select {
+	case id := <-started:
+		for _, e := range fake.Encodes() {
+			if e.ID == id {
+				return e.Video
+			}
+		}
+	case <-time.After(5 * time.Second):
+	}
+
+	t.Fatal("the video was never encoded")
+	return streamer.Video{}
+}
+
+func TestApplication_UploadVideo(t *testing.T) {
+	tests := []struct {
+		name           string
+		fileName       string
+		fields         map[string]string
+		queueFull      bool
+		expectedStatus int
+	}{
+		{"mp4 upload", "dog.mp4", map[string]string{"encoding_type": "mp4"}, false, http.StatusAccepted},
+		{"hls upload", "dog.mov", map[string]string{"encoding_type": "hls", "segment_duration": "6", "maxrate_720p": "800k"}, false, http.StatusAccepted},
+		{"bad encoding type", "dog.mp4", map[string]string{"encoding_type": "gif"}, false, http.StatusBadRequest},
+		{"bad bitrate", "dog.mp4", map[string]string{"encoding_type": "hls", "maxrate_1080p": "fast"}, false, http.StatusBadRequest},
+		{"with timeout", "dog.mp4", map[string]string{"encoding_type": "mp4", "timeout": "600"}, false, http.StatusAccepted},
+		{"bad timeout", "dog.mp4", map[string]string{"encoding_type": "mp4", "timeout": "-1"}, false, http.StatusBadRequest},
+		{"with retries", "dog.mp4", map[string]string{"encoding_type": "mp4", "max_attempts": "5", "retry_backoff": "30"}, false, http.StatusAccepted},
+		{"bad max attempts", "dog.mp4", map[string]string{"encoding_type": "mp4", "max_attempts": "0"}, false, http.StatusBadRequest},
+		{"custom ladder", "dog.mp4", map[string]string{"encoding_type": "hls", "preset": "fast", "ladder": `[{"name":"360p","height":360,"video_bitrate":"300k","audio_bitrate":"64k","profile":"main","level":"3.0"}]`}, false, http.StatusAccepted},
+		{"bad ladder profile", "dog.mp4", map[string]string{"encoding_type": "hls", "ladder": `[{"name":"360p","height":360,"video_bitrate":"300k","audio_bitrate":"64k","profile":"extreme"}]`}, false, http.StatusBadRequest},
+		{"ladder not json", "dog.mp4", map[string]string{"encoding_type": "hls", "ladder": "360p"}, false, http.StatusBadRequest},
+		{"bad preset", "dog.mp4", map[string]string{"encoding_type": "hls", "preset": "glacial"}, false, http.StatusBadRequest},
+		{"dash upload", "dog.mp4", map[string]string{"encoding_type": "dash"}, false, http.StatusAccepted},
+		{"cmaf upload", "dog.mp4", map[string]string{"encoding_type": "cmaf"}, false, http.StatusAccepted},
+		{"poster only", "dog.mp4", map[string]string{"encoding_type": "mp4", "poster": "true"}, false, http.StatusAccepted},
+		{"with thumbnails", "dog.mp4", map[string]string{"encoding_type": "mp4", "poster_at": "2.5", "thumbnails": "5", "thumbnail_width": "160", "sprite_interval": "5"}, false, http.StatusAccepted},
+		{"bad thumbnail count", "dog.mp4", map[string]string{"encoding_type": "mp4", "thumbnails": "500"}, false, http.StatusBadRequest},
+		{"odd thumbnail width", "dog.mp4", map[string]string{"encoding_type": "mp4", "thumbnail_width": "161"}, false, http.StatusBadRequest},
+		{"bad sprite interval", "dog.mp4", map[string]string{"encoding_type": "mp4", "sprite_interval": "-5"}, false, http.StatusBadRequest},
+		{"encrypted hls", "dog.mp4", map[string]string{"encoding_type": "hls", "encrypt": "true"}, false, http.StatusAccepted},
+		{"encrypted mp4", "dog.mp4", map[string]string{"encoding_type": "mp4", "encrypt": "true"}, false, http.StatusBadRequest},
+		{"named by id", "dog.mp4", map[string]string{"encoding_type": "hls", "naming": "id", "on_existing": "skip"}, false, http.StatusAccepted},
+		{"naming template", "dog.mp4", map[string]string{"encoding_type": "mp4", "naming": "{id}-{basename}", "on_existing": "version"}, false, http.StatusAccepted},
+		{"unknown placeholder", "dog.mp4", map[string]string{"encoding_type": "mp4", "naming": "{id}-{breed}"}, false, http.StatusBadRequest},
+		{"naming with a path", "dog.mp4", map[string]string{"encoding_type": "mp4", "naming": "../{id}"}, false, http.StatusBadRequest},
+		{"bad existing policy", "dog.mp4", map[string]string{"encoding_type": "mp4", "on_existing": "keep"}, false, http.StatusBadRequest},
+		{"with webhook", "dog.mp4", map[string]string{"encoding_type": "mp4", "webhook_url": "https://cms.example.com/hooks/videos"}, false, http.StatusAccepted},
+		{"bad webhook", "dog.mp4", map[string]string{"encoding_type": "mp4", "webhook_url": "ftp://cms.example.com/hooks"}, false, http.StatusBadRequest},
+		{"not a video", "dog.txt", nil, false, http.StatusUnsupportedMediaType},
+		{"job not saved", "dog.mp4", map[string]string{"encoding_type": "mp4", "dog_of_month_id": "13"}, false, http.StatusInternalServerError},
+		{"queue full", "dog.mp4", map[string]string{"encoding_type": "mp4"}, true, http.StatusTooManyRequests},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.config.uploadDir = t.TempDir()
+		app.config.videoDir = t.TempDir()
+		app.config.maxUploadSize = 1 << 20
+		app.videoQueue = make(chan streamer.VideoProcessingJob, 1)
+		app.videoNotify = make(chan streamer.ProcessingMessage, 10)
+		app.webhooks = streamer.NewWebhooks("secret")
+		app.config.webhookHosts = []string{"cms.example.com"}
+
+		started := make(chan int, 1)
+		fake := &streamer.FakeEncoder{Started: started}
+		app.videoDispatcher = streamer.New(app.videoQueue, 1, streamer.WithQueueLimit(1), streamer.WithEncoder(fake))
+
+		if e.queueFull {
+			// the pool isn't running, so this one waits in the queue forever
+			v := app.videoDispatcher.NewVideo(99, "queued.mp4", app.config.videoDir, "mp4", app.videoNotify, nil)
+			_ = app.videoDispatcher.TrySubmit(streamer.VideoProcessingJob{Video: v})
+		} else {
+			app.videoDispatcher.Run()
+		}
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.UploadVideo)
+		handler.ServeHTTP(rr, newVideoUploadRequest(e.fileName, e.fields))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d: %s", e.name, rr.Code, e.expectedStatus, rr.Body.String())
+			app.videoDispatcher.Stop()
+			continue
+		}
+
+		if e.expectedStatus != http.StatusAccepted {
+			if e.queueFull && rr.Header().Get("Retry-After") == "" {
+				t.Errorf("%s: no Retry-After header", e.name)
+			}
+
+			app.videoDispatcher.Stop()
+			if len(fake.Encodes()) != 0 {
+				t.Errorf("%s: video was encoded for a rejected upload", e.name)
+			}
+
+			// nothing is left behind for a rejected upload
+			if uploads, _ := os.ReadDir(app.config.uploadDir); len(uploads) != 0 {
+				t.Errorf("%s: the upload was left in the upload dir", e.name)
+			}
+			if outputs, _ := os.ReadDir(app.config.videoDir); len(outputs) != 0 {
+				t.Errorf("%s: an output dir was left behind", e.name)
+			}
+			continue
+		}
+
+		var job models.VideoJob
+		_ = json.Unmarshal(rr.Body.Bytes(), &job)
+
+		queued := startedVideo(t, started, fake)
+		app.videoDispatcher.Stop()
+
+		if queued.ID != job.ID || job.ID == 0 {
+			t.Errorf("%s: encoded video id %d does not match job id %d", e.name, queued.ID, job.ID)
+		}
+
+		if queued.EncodingType != e.fields["encoding_type"] {
+			t.Errorf("%s: wrong encoding type queued, got %s", e.name, queued.EncodingType)
+		}
+
+		if timeout := e.fields["timeout"]; timeout != "" && fmt.Sprint(queued.Options.Timeout.Seconds()) != timeout {
+			t.Errorf("%s: wrong timeout queued, got %s", e.name, queued.Options.Timeout)
+		}
+
+		if attempts := e.fields["max_attempts"]; attempts != "" && fmt.Sprint(queued.Options.Retry.MaxAttempts) != attempts {
+			t.Errorf("%s: wrong max attempts queued, got %d", e.name, queued.Options.Retry.MaxAttempts)
+		}
+
+		if e.fields["ladder"] != "" && (len(queued.Options.Ladder) != 1 || queued.Options.Ladder[0].Name != "360p") {
+			t.Errorf("%s: wrong ladder queued, got %+v", e.name, queued.Options.Ladder)
+		}
+
+		if e.fields["encrypt"] != "" && (queued.Options.Encryption == nil || queued.Options.Encryption.KeyURI != fmt.Sprintf("/api/videos/%d/key", job.ID)) {
+			t.Errorf("%s: wrong encryption queued, got %+v", e.name, queued.Options.Encryption)
+		}
+
+		if policy := e.fields["on_existing"]; policy != "" && string(queued.Options.OnExisting) != policy {
+			t.Errorf("%s: wrong existing output policy queued, got %s", e.name, queued.Options.OnExisting)
+		}
+
+		if e.fields["naming"] != "" && queued.Options.Naming == "" {
+			t.Errorf("%s: naming was not queued", e.name)
+		}
+
+		if queued.Options.WebhookURL != e.fields["webhook_url"] {
+			t.Errorf("%s: wrong webhook queued, got %s", e.name, queued.Options.WebhookURL)
+		}
+
+		wantThumbnails := e.fields["poster"] != "" || e.fields["poster_at"] != "" || e.fields["thumbnails"] != "" || e.fields["thumbnail_width"] != "" || e.fields["sprite_interval"] != ""
+		if (queued.Options.Thumbnails != nil) != wantThumbnails {
+			t.Errorf("%s: expected thumbnails %t, got %+v", e.name, wantThumbnails, queued.Options.Thumbnails)
+		} else if wantThumbnails && e.fields["thumbnails"] != "" && fmt.Sprint(queued.Options.Thumbnails.Count) != e.fields["thumbnails"] {
+			t.Errorf("%s: wrong thumbnail count queued, got %d", e.name, queued.Options.Thumbnails.Count)
+		}
+	}
+}
+
+func TestApplication_UploadVideoSlowly(t *testing.T) {
+	app := testApp
+	app.config.uploadDir = t.TempDir()
+	app.config.videoDir = t.TempDir()
+	app.config.maxUploadSize = 1 << 20
+	app.config.uploadTimeout = time.Minute
+	app.videoNotify = make(chan streamer.ProcessingMessage, 10)
+	app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 1, streamer.WithEncoder(&streamer.FakeEncoder{}))
+	app.videoDispatcher.Run()
+	defer app.videoDispatcher.Stop()
+
+	// the server's timeouts stand in for the 30 seconds of the real one, the upload takes longer than them
+	server := httptest.NewUnstartedServer(app.routes())
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	upload := newVideoUploadRequest("dog.mp4", map[string]string{"encoding_type": "mp4"})
+	body, _ := io.ReadAll(upload.Body)
+
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < len(body); i += len(body) / 4 {
+			_, _ = pw.Write(body[i:min(i+len(body)/4, len(body))])
+			time.Sleep(100 * time.Millisecond)
+		}
+		_ = pw.Close()
+	}()
+
+	req, _ := http.NewRequest("POST", server.URL+"/api/videos", pr)
+	req.Header.Set("Content-Type", upload.Header.Get("Content-Type"))
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("the upload was cut off: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("wrong response code, got %d wanted %d", resp.StatusCode, http.StatusAccepted)
+	}
+}
+
+func TestApplication_UploadVideoPriority(t *testing.T) {
+	tests := []struct {
+		name             string
+		priority         string
+		token            string
+		expectedStatus   int
+		expectedPriority streamer.Priority
+	}{
+		{"default", "", "", http.StatusAccepted, streamer.PriorityNormal},
+		{"low", "low", "", http.StatusAccepted, streamer.PriorityLow},
+		{"high as admin", "high", "secret", http.StatusAccepted, streamer.PriorityHigh},
+		{"high without token", "high", "", http.StatusForbidden, ""},
+		{"high with wrong token", "high", "guess", http.StatusForbidden, ""},
+		{"unknown", "urgent", "", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.config.adminToken = "secret"
+		app.config.uploadDir = t.TempDir()
+		app.config.videoDir = t.TempDir()
+		app.config.maxUploadSize = 1 << 20
+		app.videoNotify = make(chan streamer.ProcessingMessage, 10)
+		started := make(chan int, 1)
+		fake := &streamer.FakeEncoder{Started: started}
+		app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 1, streamer.WithEncoder(fake))
+		app.videoDispatcher.Run()
+
+		req := newVideoUploadRequest("dog.mp4", map[string]string{"priority": e.priority})
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.UploadVideo)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d: %s", e.name, rr.Code, e.expectedStatus, rr.Body.String())
+		}
+
+		if rr.Code == http.StatusAccepted {
+			queued := startedVideo(t, started, fake)
+			if queued.Priority != e.expectedPriority {
+				t.Errorf("%s: wrong priority queued, got %q wanted %q", e.name, queued.Priority, e.expectedPriority)
+			}
+		}
+
+		app.videoDispatcher.Stop()
+	}
+}
+
+func TestApplication_UploadVideoToS3(t *testing.T) {
+	tests := []struct {
+		name           string
+		fields         map[string]string
+		expectedStatus int
+	}{
+		{"hls upload", map[string]string{"encoding_type": "hls"}, http.StatusAccepted},
+		{"encrypted upload", map[string]string{"encoding_type": "hls", "encrypt": "true"}, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.config.uploadDir = t.TempDir()
+		app.config.videoDir = t.TempDir()
+		app.config.maxUploadSize = 1 << 20
+		app.config.s3 = streamer.S3Storage{Endpoint: "http://localhost:9000", Bucket: "videos"}
+		app.videoNotify = make(chan streamer.ProcessingMessage, 10)
+		started := make(chan int, 1)
+		fake := &streamer.FakeEncoder{Started: started}
+		app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 1, streamer.WithEncoder(fake))
+		app.videoDispatcher.Run()
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.UploadVideo)
+		handler.ServeHTTP(rr, newVideoUploadRequest("dog.mp4", e.fields))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d: %s", e.name, rr.Code, e.expectedStatus, rr.Body.String())
+			app.videoDispatcher.Stop()
+			continue
+		}
+
+		if rr.Code == http.StatusAccepted {
+			// the prefix in the bucket, not a directory on this machine
+			if queued := startedVideo(t, started, fake); queued.OutputDir != "videos/1" {
+				t.Errorf("%s: wrong output dir queued, got %s", e.name, queued.OutputDir)
+			}
+		}
+
+		app.videoDispatcher.Stop()
+	}
+}
+
+func TestApplication_removeOutputDir(t *testing.T) {
+	// in s3 the output dir is a prefix in the bucket, which looks like a path relative to the working directory
+	wd, _ := os.Getwd()
+	_ = os.Chdir(t.TempDir())
+	defer func() { _ = os.Chdir(wd) }()
+
+	local := t.TempDir()
+
+	tests := []struct {
+		name      string
+		bucket    string
+		outputDir string
+		removed   bool
+	}{
+		{"local storage", "", filepath.Join(local, "7"), true},
+		{"s3", "videos", filepath.Join("videos", "7"), false},
+	}
+
+	for _, e := range tests {
+		_ = os.MkdirAll(e.outputDir, 0755)
+
+		app := testApp
+		app.config.s3 = streamer.S3Storage{Bucket: e.bucket}
+		app.removeOutputDir(&models.VideoJob{ID: 7, OutputDir: e.outputDir})
+
+		if _, err := os.Stat(e.outputDir); os.IsNotExist(err) != e.removed {
+			t.Errorf("%s: expected removed %t, got %v", e.name, e.removed, err)
+		}
+	}
+}
+
+func TestApplication_AllVideoJobsJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{"all jobs", "", http.StatusOK},
+		{"running jobs", "?status=running", http.StatusOK},
+		{"bad status", "?status=done", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/videos"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.AllVideoJobsJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+			continue
+		}
+
+		if e.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var list struct {
+			Jobs  []models.VideoJob `json:"jobs"`
+			Total int               `json:"total"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &list)
+
+		if len(list.Jobs) == 0 || list.Total != len(list.Jobs) {
+			t.Errorf("%s: expected a page of jobs, got %s", e.name, rr.Body.String())
+		}
+	}
+}
+
+func TestApplication_GetVideoJobJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+	}{
+		{"valid id", "7", http.StatusOK},
+		{"bad id", "seven", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/videos/"+e.id, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.GetVideoJobJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestApplication_VideoProgressStream(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+		expectedEvent  string
+	}{
+		{"finished encode", "7", http.StatusOK, "event: done"},
+		{"bad id", "seven", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 1)
+
+		// the encode finished before anyone started watching
+		app.videoDispatcher.Progress.Publish(streamer.Progress{ID: 7, OutTime: 12.5, Percent: 100, Done: true})
+
+		req, _ := http.NewRequest("GET", "/api/videos/"+e.id+"/progress", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.VideoProgressStream)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+			continue
+		}
+
+		if e.expectedEvent == "" {
+			continue
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("%s: wrong content type %s", e.name, ct)
+		}
+
+		if !strings.Contains(rr.Body.String(), e.expectedEvent) || !strings.Contains(rr.Body.String(), `"percent":100`) {
+			t.Errorf("%s: expected %q with the final progress, got %s", e.name, e.expectedEvent, rr.Body.String())
+		}
+	}
+}
+
+func TestApplication_CancelVideoJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+	}{
+		{"not dispatched", "7", http.StatusConflict},
+		{"bad id", "seven", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 1)
+
+		req, _ := http.NewRequest("POST", "/api/admin/videos/"+e.id+"/cancel", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.CancelVideoJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestApplication_RequeueVideoJSON(t *testing.T) {
+	app := testApp
+	app.videoNotify = make(chan streamer.ProcessingMessage, 20)
+	fake := &streamer.FakeEncoder{Errors: map[int]error{7: errors.New("no space left on device")}}
+	app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 1, streamer.WithEncoder(fake))
+	app.videoDispatcher.Run()
+	defer app.videoDispatcher.Stop()
+
+	options := &streamer.VideoOptions{Retry: streamer.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}}
+	v := app.videoDispatcher.NewVideo(7, "dog.mp4", t.TempDir(), "mp4", app.videoNotify, options)
+	_ = app.videoDispatcher.TrySubmit(streamer.VideoProcessingJob{Video: v})
+
+	var statuses []string
+	for msg := range app.videoNotify {
+		statuses = append(statuses, msg.Status)
+		if msg.Done() {
+			break
+		}
+	}
+
+	expected := []string{streamer.StatusRunning, streamer.StatusRetrying, streamer.StatusRunning, streamer.StatusFailed}
+	if fmt.Sprint(statuses) != fmt.Sprint(expected) {
+		t.Errorf("wrong messages, got %v wanted %v", statuses, expected)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.VideoDeadLettersJSON).ServeHTTP(rr, httptest.NewRequest("GET", "/api/admin/videos/dead-letters", nil))
+
+	var deadLetters []videoDeadLetter
+	_ = json.Unmarshal(rr.Body.Bytes(), &deadLetters)
+	if len(deadLetters) != 1 || deadLetters[0].ID != 7 || deadLetters[0].Attempts != 2 {
+		t.Fatalf("wrong dead letters: %s", rr.Body.String())
+	}
+
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+	}{
+		{"dead letter", "7", http.StatusAccepted},
+		{"not dead-lettered", "8", http.StatusNotFound},
+		{"bad id", "seven", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/admin/videos/dead-letters/"+e.id+"/requeue", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.RequeueVideoJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+	}
+}
+
+func TestApplication_UploadVideoWebhook(t *testing.T) {
+	tests := []struct {
+		name           string
+		webhook        string
+		token          string
+		setUp          bool
+		expectedStatus int
+	}{
+		{"allowed host", "https://cms.example.com/hooks/videos", "", true, http.StatusAccepted},
+		{"allowed host, any case", "https://CMS.example.com./hooks/videos", "", true, http.StatusAccepted},
+		{"host not allowed", "https://requestbin.example.net/hooks", "", true, http.StatusForbidden},
+		{"wrong token", "https://requestbin.example.net/hooks", "guess", true, http.StatusForbidden},
+		{"any host as admin", "https://requestbin.example.net/hooks", "secret", true, http.StatusAccepted},
+		{"metadata endpoint", "http://169.254.169.254/latest/meta-data/", "", true, http.StatusBadRequest},
+		{"metadata endpoint as admin", "http://169.254.169.254/latest/meta-data/", "secret", true, http.StatusBadRequest},
+		{"loopback as admin", "http://localhost:4001/api/admin/videos/workers", "secret", true, http.StatusBadRequest},
+		{"private address as admin", "http://10.0.0.5/hooks", "secret", true, http.StatusBadRequest},
+		{"webhooks not set up", "https://cms.example.com/hooks/videos", "secret", false, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.config.adminToken = "secret"
+		app.config.uploadDir = t.TempDir()
+		app.config.videoDir = t.TempDir()
+		app.config.maxUploadSize = 1 << 20
+		app.config.webhookHosts = []string{"cms.example.com"}
+		app.videoNotify = make(chan streamer.ProcessingMessage, 10)
+		app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 1, streamer.WithEncoder(&streamer.FakeEncoder{}))
+		app.videoDispatcher.Run()
+
+		app.webhooks = nil
+		if e.setUp {
+			app.webhooks = streamer.NewWebhooks("secret")
+		}
+
+		req := newVideoUploadRequest("dog.mp4", map[string]string{"encoding_type": "mp4", "webhook_url": e.webhook})
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.UploadVideo)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d: %s", e.name, rr.Code, e.expectedStatus, rr.Body.String())
+		}
+
+		app.videoDispatcher.Stop()
+	}
+}
+
+func TestApplication_VideoWebhooksJSON(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(streamer.WebhookEventHeader)
+	}))
+	defer server.Close()
+
+	app := testApp
+	app.webhooks = streamer.NewWebhooks("secret")
+	app.webhooks.Client = server.Client() // the test server is on loopback, which the default client won't connect to
+	app.videoNotify = make(chan streamer.ProcessingMessage, 20)
+	fake := &streamer.FakeEncoder{Errors: map[int]error{8: errors.New("no space left on device")}}
+	app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 2, streamer.WithEncoder(fake), streamer.WithWebhooks(app.webhooks))
+	app.videoDispatcher.Run()
+
+	for _, id := range []int{7, 8} {
+		v := app.videoDispatcher.NewVideo(id, "dog.mp4", t.TempDir(), "mp4", app.videoNotify, &streamer.VideoOptions{WebhookURL: server.URL})
+		_ = app.videoDispatcher.TrySubmit(streamer.VideoProcessingJob{Video: v})
+	}
+
+	for done := 0; done < 2; {
+		if msg := <-app.videoNotify; msg.Done() {
+			done++
+		}
+	}
+	app.videoDispatcher.Stop()
+	_ = app.webhooks.Shutdown(context.Background())
+
+	if len(received) != 2 {
+		t.Fatalf("expected two webhooks, got %d", len(received))
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedEvents string
+	}{
+		{"all deliveries", "", http.StatusOK, "[video.failed video.succeeded]"},
+		{"one video", "?video_id=8", http.StatusOK, "[video.failed]"},
+		{"no deliveries", "?video_id=9", http.StatusOK, "[]"},
+		{"bad video id", "?video_id=eight", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.VideoWebhooksJSON)
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/admin/videos/webhooks"+e.query, nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+			continue
+		}
+
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var deliveries []videoWebhookDelivery
+		_ = json.Unmarshal(rr.Body.Bytes(), &deliveries)
+
+		// the two videos are encoded at the same time, so they can finish in either order
+		events := []string{}
+		for _, d := range deliveries {
+			if d.Status != streamer.WebhookDelivered || d.DeliveredAt == nil || d.Attempts != 1 {
+				t.Errorf("%s: wrong delivery %+v", e.name, d)
+			}
+			events = append(events, d.Event)
+		}
+		sort.Strings(events)
+
+		if fmt.Sprint(events) != e.expectedEvents {
+			t.Errorf("%s: wrong deliveries, got %v wanted %s", e.name, events, e.expectedEvents)
+		}
+	}
+}
+
+func TestApplication_ResizeVideoWorkersJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		stopped        bool
+		expectedStatus int
+	}{
+		{"grow", `{"workers": 3}`, false, http.StatusOK},
+		{"too few", `{"workers": 0}`, false, http.StatusUnprocessableEntity},
+		{"too many", `{"workers": 1000}`, false, http.StatusUnprocessableEntity},
+		{"bad json", `{"workers": "lots"}`, false, http.StatusBadRequest},
+		{"shut down", `{"workers": 2}`, true, http.StatusServiceUnavailable},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.videoDispatcher = streamer.New(make(chan streamer.VideoProcessingJob), 1)
+		app.videoDispatcher.Run()
+		if e.stopped {
+			app.videoDispatcher.Stop()
+		}
+
+		req, _ := http.NewRequest("PUT", "/api/admin/videos/workers", strings.NewReader(e.body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.ResizeVideoWorkersJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d: %s", e.name, rr.Code, e.expectedStatus, rr.Body.String())
+		}
+
+		if e.expectedStatus == http.StatusOK && app.videoDispatcher.Workers() != 3 {
+			t.Errorf("%s: pool has %d workers, wanted 3", e.name, app.videoDispatcher.Workers())
+		}
+
+		app.videoDispatcher.Stop()
+	}
+}
+
+func TestApplication_VideoPlaybackJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+	}{
+		{"finished encode", "2", http.StatusOK},
+		{"still queued", "7", http.StatusConflict},
+		{"bad id", "seven", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		app := testApp
+		app.config.signingKey = []byte("secret")
+		app.config.playbackTTL = time.Hour
+
+		req, _ := http.NewRequest("GET", "/api/admin/videos/"+e.id+"/playback", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.VideoPlaybackJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d: %s", e.name, rr.Code, e.expectedStatus, rr.Body.String())
+			continue
+		}
+
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var playback videoPlayback
+		_ = json.Unmarshal(rr.Body.Bytes(), &playback)
+
+		u, err := url.Parse(playback.URL)
+		if err != nil || u.Path != "/videos/2/dog.m3u8" {
+			t.Errorf("%s: wrong playback url %s", e.name, playback.URL)
+			continue
+		}
+
+		if u.Query().Get("sig") != app.signVideo(2, playback.ExpiresAt.Unix()) {
+			t.Errorf("%s: playback url is not signed for its expiry: %s", e.name, playback.URL)
+		}
+
+		if !strings.HasPrefix(playback.Poster, "/videos/2/dog-poster.jpg?") {
+			t.Errorf("%s: wrong poster url %s", e.name, playback.Poster)
+		}
+	}
+}
+
+func TestApplication_VideoMedia(t *testing.T) {
+	app := testApp
+	app.config.signingKey = []byte("secret")
+	app.config.videoDir = t.TempDir()
+
+	playlist := "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"/api/videos/5/key\",IV=0x01\n#EXTINF:10.0,\ndog-720p0.ts\n#EXT-X-ENDLIST\n"
+	manifest := `<SegmentTemplate initialization="dog-init-$RepresentationID$.m4s" media="dog-chunk-$RepresentationID$-$Number%05d$.m4s"/>`
+
+	_ = os.MkdirAll(filepath.Join(app.config.videoDir, "5"), 0755)
+	_ = os.WriteFile(filepath.Join(app.config.videoDir, "5", "dog-720p.m3u8"), []byte(playlist), 0644)
+	_ = os.WriteFile(filepath.Join(app.config.videoDir, "5", "dog.mpd"), []byte(manifest), 0644)
+	_ = os.WriteFile(filepath.Join(app.config.videoDir, "5", "dog-720p0.ts"), []byte("segment"), 0644)
+	_ = os.WriteFile(filepath.Join(app.config.videoDir, "5", "dog.mp4"), []byte("0123456789"), 0644)
+	_ = os.WriteFile(filepath.Join(app.config.videoDir, "5", ".hidden"), []byte("hidden"), 0644)
+	_ = os.WriteFile(filepath.Join(app.config.videoDir, "secret.txt"), []byte("secret"), 0644)
+	_ = os.Symlink(filepath.Join(app.config.videoDir, "secret.txt"), filepath.Join(app.config.videoDir, "5", "escape.ts"))
+
+	info, _ := os.Stat(filepath.Join(app.config.videoDir, "5", "dog.mp4"))
+	etag := mediaETag(info)
+
+	signed, _ := app.signedVideoQuery(5, time.Hour)
+	expired := url.Values{"expires": {"1000"}, "sig": {app.signVideo(5, 1000)}}
+	forged := url.Values{"expires": signed["expires"], "sig": {app.signVideo(6, 0)}}
+
+	tests := []struct {
+		name           string
+		file           string
+		query          url.Values
+		header         map[string]string
+		expectedStatus int
+		expectedType   string
+		expectedBody   string
+	}{
+		{"signed playlist", "dog-720p.m3u8", signed, nil, http.StatusOK, "application/vnd.apple.mpegurl", "dog-720p0.ts?" + signed.Encode()},
+		{"key uri is signed", "dog-720p.m3u8", signed, nil, http.StatusOK, "application/vnd.apple.mpegurl", `URI="/api/videos/5/key?` + signed.Encode() + `"`},
+		{"signed manifest", "dog.mpd", signed, nil, http.StatusOK, "application/dash+xml", `media="dog-chunk-$RepresentationID$-$Number%05d$.m4s?` + strings.ReplaceAll(signed.Encode(), "&", "&amp;") + `"`},
+		{"signed segment", "dog-720p0.ts", signed, nil, http.StatusOK, "video/mp2t", "segment"},
+		{"whole mp4", "dog.mp4", signed, nil, http.StatusOK, "video/mp4", "0123456789"},
+		{"mp4 range", "dog.mp4", signed, map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "video/mp4", "2345"},
+		{"unsatisfiable range", "dog.mp4", signed, map[string]string{"Range": "bytes=20-30"}, http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"not modified", "dog.mp4", signed, map[string]string{"If-None-Match": etag}, http.StatusNotModified, "", ""},
+		{"modified", "dog.mp4", signed, map[string]string{"If-None-Match": `"other"`}, http.StatusOK, "video/mp4", "0123456789"},
+		{"unsigned", "dog-720p0.ts", url.Values{}, nil, http.StatusForbidden, "", ""},
+		{"expired", "dog-720p0.ts", expired, nil, http.StatusForbidden, "", ""},
+		{"forged", "dog-720p0.ts", forged, nil, http.StatusForbidden, "", ""},
+		{"missing file", "dog-1080p0.ts", signed, nil, http.StatusNotFound, "", ""},
+		{"missing playlist", "cat.m3u8", signed, nil, http.StatusNotFound, "", ""},
+		{"path traversal", "../secret.txt", signed, nil, http.StatusNotFound, "", ""},
+		{"hidden file", ".hidden", signed, nil, http.StatusNotFound, "", ""},
+		{"symlink out", "escape.ts", signed, nil, http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/videos/5/file?"+e.query.Encode(), nil)
+		for k, v := range e.header {
+			req.Header.Set(k, v)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "5")
+		rctx.URLParams.Add("file", e.file)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.VideoMedia)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d: %s", e.name, rr.Code, e.expectedStatus, rr.Body.String())
+			continue
+		}
+
+		if e.expectedType != "" && rr.Header().Get("Content-Type") != e.expectedType {
+			t.Errorf("%s: wrong content type %s", e.name, rr.Header().Get("Content-Type"))
+		}
+
+		if !strings.Contains(rr.Body.String(), e.expectedBody) {
+			t.Errorf("%s: expected %q in body, got %s", e.name, e.expectedBody, rr.Body.String())
+		}
+	}
+}
+
+func TestApplication_VideoMediaSlowly(t *testing.T) {
+	app := testApp
+	app.config.signingKey = []byte("secret")
+	app.config.videoDir = t.TempDir()
+	app.config.downloadTimeout = time.Minute
+
+	// far more than the socket buffers hold, so the server is still writing when its write timeout has passed
+	mp4 := bytes.Repeat([]byte("0123456789abcdef"), 2<<20)
+	_ = os.MkdirAll(filepath.Join(app.config.videoDir, "5"), 0755)
+	_ = os.WriteFile(filepath.Join(app.config.videoDir, "5", "dog.mp4"), mp4, 0644)
+
+	// the server's write timeout stands in for the 30 seconds of the real one, the download takes longer than it
+	server := httptest.NewUnstartedServer(app.routes())
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	signed, _ := app.signedVideoQuery(5, time.Hour)
+	resp, err := server.Client().Get(server.URL + "/videos/5/dog.mp4?" + signed.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	first := make([]byte, 1<<20)
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("the download was cut off: %s", err)
+	}
+
+	if len(first)+len(rest) != len(mp4) {
+		t.Errorf("expected %d bytes, got %d", len(mp4), len(first)+len(rest))
+	}
+}
+
+func TestApplication_StaticFile(t *testing.T) {
+	app := testApp
+	app.config.staticDir = t.TempDir()
+	app.config.videoDir = filepath.Join(app.config.staticDir, "videos")
+
+	_ = os.MkdirAll(filepath.Join(app.config.staticDir, "dom"), 0755)
+	_ = os.MkdirAll(filepath.Join(app.config.videoDir, "5"), 0755)
+	_ = os.WriteFile(filepath.Join(app.config.staticDir, "dom", "rex.jpg"), []byte("jpeg"), 0644)
+	_ = os.WriteFile(filepath.Join(app.config.videoDir, "5", "dog.mp4"), []byte("mp4"), 0644)
+
+	info, _ := os.Stat(filepath.Join(app.config.staticDir, "dom", "rex.jpg"))
+
+	tests := []struct {
+		name           string
+		file           string
+		header         map[string]string
+		expectedStatus int
+	}{
+		{"image", "dom/rex.jpg", nil, http.StatusOK},
+		{"cached image", "dom/rex.jpg", map[string]string{"If-None-Match": mediaETag(info)}, http.StatusNotModified},
+		{"cached by date", "dom/rex.jpg", map[string]string{"If-Modified-Since": info.ModTime().Add(time.Minute).UTC().Format(http.TimeFormat)}, http.StatusNotModified},
+		{"missing image", "dom/fido.jpg", nil, http.StatusNotFound},
+		{"directory", "dom", nil, http.StatusNotFound},
+		{"encoded video", "videos/5/dog.mp4", nil, http.StatusNotFound},
+		{"encoded video the long way", "dom/../videos/5/dog.mp4", nil, http.StatusNotFound},
+		{"path traversal", "../../etc/passwd", nil, http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/static/"+e.file, nil)
+		for k, v := range e.header {
+			req.Header.Set(k, v)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("*", e.file)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.StaticFile)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d", e.name, rr.Code, e.expectedStatus)
+			continue
+		}
+
+		if rr.Code == http.StatusOK && (rr.Header().Get("Content-Type") != "image/jpeg" || rr.Header().Get("ETag") == "") {
+			t.Errorf("%s: wrong headers %v", e.name, rr.Header())
+		}
+	}
+}
+
+func TestApplication_domPlayback(t *testing.T) {
+	app := testApp
+	app.config.signingKey = []byte("secret")
+	app.config.playbackTTL = time.Hour
+
+	tests := []struct {
+		name          string
+		video         string
+		expectedVideo string
+	}{
+		{"finished encode", "2/dog.m3u8", "/videos/2/dog.m3u8?"},
+		{"unfinished encode", "7/dog.mp4", ""},
+		{"uploaded to s3", "https://cdn.example.com/videos/3/dog.m3u8", "https://cdn.example.com/videos/3/dog.m3u8"},
+		{"not our encode", "intro.mp4", ""},
+		{"no video", "", ""},
+	}
+
+	for _, e := range tests {
+		playback := app.domPlayback(&models.DogOfMonth{ID: 1, Video: e.video})
+
+		switch {
+		case e.expectedVideo == "" && playback != nil:
+			t.Errorf("%s: expected no player, got %+v", e.name, playback)
+		case e.expectedVideo != "" && (playback == nil || !strings.HasPrefix(playback.URL, e.expectedVideo)):
+			t.Errorf("%s: expected a player for %s, got %+v", e.name, e.expectedVideo, playback)
+		}
+	}
+}
+
+func TestApplication_VideoKey(t *testing.T) {
+	app := testApp
+	app.config.signingKey = []byte("secret")
+	app.config.keyDir = t.TempDir()
+
+	_ = os.WriteFile(app.videoKeyFile(5), []byte("0123456789abcdef"), 0600)
+
+	signed, _ := app.signedVideoQuery(5, time.Hour)
+	signedOther, _ := app.signedVideoQuery(6, time.Hour)
+
+	tests := []struct {
+		name           string
+		id             string
+		query          url.Values
+		expectedStatus int
+	}{
+		{"signed", "5", signed, http.StatusOK},
+		{"unsigned", "5", url.Values{}, http.StatusForbidden},
+		{"signed for another video", "5", signedOther, http.StatusForbidden},
+		{"not encrypted", "6", signedOther, http.StatusNotFound},
+		{"bad id", "five", signed, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/videos/"+e.id+"/key?"+e.query.Encode(), nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.VideoKey)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong response code, got %d wanted %d: %s", e.name, rr.Code, e.expectedStatus, rr.Body.String())
+			continue
+		}
+
+		if rr.Code == http.StatusOK && (rr.Body.String() != "0123456789abcdef" || rr.Header().Get("Cache-Control") != "no-store") {
+			t.Errorf("%s: wrong key response %q", e.name, rr.Body.String())
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"flag"
 	"fmt"
 	"go-breeders/adapters"
@@ -9,6 +11,10 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -16,48 +22,129 @@ const port = ":4001"
 
 // Will contain application settings (dbhost, dbpool etc.)
 type application struct {
-	templateMap map[string]*template.Template
-	config      appConfig
-	App         *configuration.Application // this is our singleton
-	videoQueue  chan streamer.VideoProcessingJob
+	templateMap     map[string]*template.Template
+	config          appConfig
+	App             *configuration.Application // this is our singleton
+	videoQueue      chan streamer.VideoProcessingJob
+	videoDispatcher *streamer.VideoDispatcher
+	videoNotify     chan streamer.ProcessingMessage // the worker pool sends the result of every encode here
+	webhooks        *streamer.Webhooks              // posts results to the webhooks of jobs, nil without -webhook-secret
+	shuttingDown    chan struct{}                   // closed when the server starts shutting down, ends long lived streams
 }
 
 type appConfig struct {
-	useCache bool
-	dsn      string
+	useCache        bool
+	dsn             string
+	catBreedsFrom   string
+	adminToken      string
+	staticDir       string
+	uploadDir       string
+	videoDir        string
+	maxUploadSize   int
+	uploadTimeout   time.Duration
+	downloadTimeout time.Duration
+	queueLimit      int
+	keyDir          string
+	scratchDir      string
+	s3              streamer.S3Storage
+	signingKey      []byte
+	playbackTTL     time.Duration
+	webhookSecret   string
+	webhookHosts    []string // hosts anyone uploading can have webhooks posted to, admins can use any public host
+	workers         int
+	shutdownTimeout time.Duration
 }
 
 func main() {
-	const numWorkers = 4
-	videoQueue := make(chan streamer.VideoProcessingJob, numWorkers)
-	defer close(videoQueue)
-
 	app := application{
 		templateMap: make(map[string]*template.Template),
-		videoQueue:  videoQueue,
 	}
 	flag.BoolVar(&app.config.useCache, "cache", false, "Use template cache")
 	flag.StringVar(&app.config.dsn, "dsn", "mariadb:myverysecretpassword@tcp(localhost:3306)/breeders_design_systems?parseTime=true&tls=false&collation=utf8_unicode_ci&timeout=5s", "DSN")
+	flag.StringVar(&app.config.catBreedsFrom, "cat-breeds", "xml", "Where to get cat breeds from: xml, json (remote service) or db (local database)")
+	flag.StringVar(&app.config.adminToken, "admin-token", os.Getenv("ADMIN_TOKEN"), "Bearer token for the /api/admin routes (they are disabled when empty)")
+	flag.StringVar(&app.config.staticDir, "static-dir", "./static", "Where the static files served under /static/ are, like the dog of the month images")
+	flag.StringVar(&app.config.uploadDir, "upload-dir", "./uploads", "Where uploaded videos are stored before encoding")
+	flag.StringVar(&app.config.videoDir, "video-dir", "./static/videos", "Where encoded videos are written, one directory per job")
+	flag.IntVar(&app.config.maxUploadSize, "max-upload", 1024<<20, "Largest video upload we accept, in bytes")
+	flag.DurationVar(&app.config.uploadTimeout, "upload-timeout", time.Hour, "How long a video upload may take, the server's 30 second timeouts don't apply to it")
+	flag.DurationVar(&app.config.downloadTimeout, "download-timeout", time.Hour, "How long sending a file of an encoded video may take, the server's 30 second write timeout doesn't apply to it")
+	flag.IntVar(&app.config.workers, "workers", 4, "How many videos are encoded at once to start with, it can be changed at runtime through the admin api")
+	flag.IntVar(&app.config.queueLimit, "queue-limit", streamer.DefaultQueueLimit, "How many videos can wait for an encoder before uploads are turned away with a 429 (0 for no limit)")
+	flag.StringVar(&app.config.scratchDir, "scratch-dir", "", "Where videos are encoded before they are uploaded to s3 (the system's temporary directory when empty)")
+	flag.StringVar(&app.config.s3.Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "S3 compatible endpoint encoded videos are uploaded to")
+	flag.StringVar(&app.config.s3.Bucket, "s3-bucket", "", "Bucket encoded videos are uploaded to (they stay in -video-dir when empty)")
+	flag.StringVar(&app.config.s3.Region, "s3-region", "us-east-1", "Region of the s3 bucket")
+	flag.StringVar(&app.config.s3.AccessKey, "s3-access-key", os.Getenv("S3_ACCESS_KEY"), "Access key id for the s3 bucket")
+	flag.StringVar(&app.config.s3.SecretKey, "s3-secret-key", os.Getenv("S3_SECRET_KEY"), "Secret access key for the s3 bucket")
+	flag.StringVar(&app.config.s3.PublicURL, "s3-public-url", "", "Where videos in the s3 bucket are fetched from, like a CDN (the endpoint and bucket when empty)")
+	flag.StringVar(&app.config.keyDir, "key-dir", "./keys", "Where the keys of encrypted videos are kept, it must not be served")
+	signingKey := flag.String("signing-key", os.Getenv("VIDEO_SIGNING_KEY"), "Secret that signs video playback urls (a random one, good until restart, when empty)")
+	flag.DurationVar(&app.config.playbackTTL, "playback-ttl", 4*time.Hour, "How long a signed video playback url works for")
+	flag.StringVar(&app.config.webhookSecret, "webhook-secret", os.Getenv("WEBHOOK_SECRET"), "Secret that signs the webhooks posted when video jobs finish (uploads can't ask for a webhook when empty)")
+	webhookHosts := flag.String("webhook-hosts", os.Getenv("WEBHOOK_HOSTS"), "Comma separated hosts any upload can ask for a webhook to (only admins can ask for webhooks when empty)")
+	flag.DurationVar(&app.config.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for requests and encodes to finish on shutdown before cutting them off")
 	flag.Parse()
 
+	if app.config.workers < 1 {
+		log.Fatal("-workers must be at least 1")
+	}
+
+	for _, host := range strings.Split(*webhookHosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			app.config.webhookHosts = append(app.config.webhookHosts, host)
+		}
+	}
+
+	app.config.signingKey = []byte(*signingKey)
+	if len(app.config.signingKey) == 0 {
+		log.Println("No -signing-key given, video playback urls will stop working on restart")
+		app.config.signingKey = make([]byte, 32)
+		if _, err := rand.Read(app.config.signingKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	videoQueue := make(chan streamer.VideoProcessingJob, app.config.workers)
+	app.videoQueue = videoQueue
+
 	// Get DB
 	db, err := initMySQLDB(app.config.dsn)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	// Have the choice of using either xml or json
-	// jsonBackend := &adapters.SONBackend{}
-	// jsonAdapter := &adapters.RemoteService{Remote: jsonBackend}
-	xmlBackend := &adapters.XMLBackend{}
-	xmlAdapter := &adapters.RemoteService{Remote: xmlBackend}
+	// Have the choice of using xml or json from the remote service, or reading the local database
+	catAdapter, err := newCatService(app.config.catBreedsFrom)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	// app.Models = *models.New(db) // hooking up the models with the database connection (old way - now we have singleton)
-	app.App = configuration.New(db, xmlAdapter)
+	app.App = configuration.New(db, catAdapter)
+
+	poolOptions := []streamer.Option{streamer.WithQueueLimit(app.config.queueLimit)}
+	if app.config.s3.Bucket != "" {
+		poolOptions = append(poolOptions, streamer.WithStorage(&app.config.s3, app.config.scratchDir))
+	}
+	if app.config.webhookSecret != "" {
+		app.webhooks = streamer.NewWebhooks(app.config.webhookSecret)
+		poolOptions = append(poolOptions, streamer.WithWebhooks(app.webhooks))
+	}
 
-	wp := streamer.New(videoQueue, numWorkers)
+	wp := streamer.New(videoQueue, app.config.workers, poolOptions...)
 	wp.Run()
 
+	app.videoDispatcher = wp
+	app.videoNotify = make(chan streamer.ProcessingMessage, app.config.workers)
+	app.failInterruptedVideoJobs()
+
+	resultsDone := make(chan struct{})
+	go func() {
+		app.listenForVideoResults()
+		close(resultsDone)
+	}()
+
 	server := &http.Server{
 		Addr:              port,
 		Handler:           app.routes(),
@@ -67,15 +154,77 @@ func main() {
 		WriteTimeout:      30 * time.Second,
 	}
 
+	// Shutdown doesn't wait for streams like the progress events to end by themselves, so tell them to
+	app.shuttingDown = make(chan struct{})
+	server.RegisterOnShutdown(func() {
+		close(app.shuttingDown)
+	})
+
 	// This uses go's default mux router
 	// http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 	// 	fmt.Fprint(w, "Hi")
 	// })
 
-	fmt.Println("Start web application on port", port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	err = server.ListenAndServe()
-	if err != nil {
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Println("Start web application on port", port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		// the server never got going (port in use, say)
 		log.Fatal(err)
+	case <-ctx.Done():
+		stop()
+	}
+
+	fmt.Println("Shutting down, waiting up to", app.config.shutdownTimeout, "for requests and encodes to finish")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+	defer cancel()
+
+	// stop taking requests and let the ones in flight finish
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error shutting down web server:", err)
+	}
+
+	// then let the encodes finish, killing whatever is still running when we are out of time
+	if err := wp.Shutdown(shutdownCtx); err != nil {
+		log.Println("Encodes still running at the shutdown deadline were killed:", err)
+	}
+
+	// the results of the encodes that were cut off are on their way to webhooks too
+	if app.webhooks != nil {
+		if err := app.webhooks.Shutdown(shutdownCtx); err != nil {
+			log.Println("Webhooks still being delivered at the shutdown deadline were given up on:", err)
+		}
+	}
+
+	// the pool won't send anything else, so record the last results and close the database
+	close(app.videoNotify)
+	<-resultsDone
+
+	if err := db.Close(); err != nil {
+		log.Println("Error closing database:", err)
+	}
+
+	fmt.Println("Shut down cleanly")
+}
+
+// newCatService returns the cat breeds adapter for the backend chosen at startup
+func newCatService(from string) (*adapters.RemoteService, error) {
+	switch from {
+	case "xml":
+		return &adapters.RemoteService{Remote: &adapters.XMLBackend{}}, nil
+	case "json":
+		return &adapters.RemoteService{Remote: &adapters.JSONBackend{}}, nil
+	case "db":
+		return &adapters.RemoteService{Remote: &adapters.DatabaseBackend{}}, nil
+	default:
+		return nil, fmt.Errorf("invalid cat breeds backend %q, must be one of xml, json or db", from)
 	}
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-breeders/streamer"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tsawler/toolbox"
+)
+
+// hlsURIAttr matches the URI attribute of playlist tags like EXT-X-KEY and EXT-X-MAP
+var hlsURIAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// dashURIAttr matches the attributes of a DASH manifest that name segments
+var dashURIAttr = regexp.MustCompile(`\b(initialization|media|sourceURL)="([^"]*)"`)
+
+// errMediaNotFound is what opening a media file fails with when there is nothing we are willing to serve at the path
+var errMediaNotFound = errors.New("not found")
+
+// mediaETag identifies a version of a file. Encoded files are written once, so the size and time they were written
+// tell versions apart without reading them
+func mediaETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// openMediaFile opens name, a slash separated path under root, refusing anything that would get out of root:
+// .. segments, hidden files, and symlinks pointing elsewhere. Directories aren't served either
+func openMediaFile(root, name string) (*os.File, fs.FileInfo, error) {
+	if strings.ContainsAny(name, "\\\x00") {
+		return nil, nil, errMediaNotFound
+	}
+
+	rel := strings.TrimPrefix(path.Clean("/"+name), "/")
+	for _, segment := range strings.Split(rel, "/") {
+		if segment == "" || strings.HasPrefix(segment, ".") {
+			return nil, nil, errMediaNotFound
+		}
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, nil, errMediaNotFound
+	}
+
+	file, err := filepath.EvalSymlinks(filepath.Join(realRoot, filepath.FromSlash(rel)))
+	if err != nil || !within(file, realRoot) {
+		return nil, nil, errMediaNotFound
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, errMediaNotFound
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		_ = f.Close()
+		return nil, nil, errMediaNotFound
+	}
+
+	return f, info, nil
+}
+
+// within reports whether file is dir or somewhere under it
+func within(file, dir string) bool {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// serveMedia serves the file at name under root with its content type, an ETag and Last-Modified for caching, and
+// support for Range requests so players can seek in an MP4 without downloading all of it
+func serveMedia(w http.ResponseWriter, r *http.Request, root, name, cacheControl string) {
+	f, info, err := openMediaFile(root, name)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", streamer.ContentType(name))
+	w.Header().Set("ETag", mediaETag(info))
+	w.Header().Set("Cache-Control", cacheControl)
+
+	// this answers If-None-Match, If-Modified-Since and Range for us
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// StaticFile serves the site's static files, like the dog of the month images. Encoded videos and their keys never
+// come out of here, even when they are kept under the static directory, they need a signed url
+func (app *application) StaticFile(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "*")
+
+	file := filepath.Join(app.config.staticDir, filepath.FromSlash(path.Clean("/"+name)))
+	if (app.config.videoDir != "" && within(file, app.config.videoDir)) || (app.config.keyDir != "" && within(file, app.config.keyDir)) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	serveMedia(w, r, app.config.staticDir, name, "public, max-age=3600")
+}
+
+// VideoMedia serves a file of an encoded video to anyone holding a signed url for it. Playlists and DASH manifests
+// are rewritten so the variant playlists, segments and key they point at carry the same grant
+func (app *application) VideoMedia(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	if err := app.verifyVideoSignature(r, id); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	// a whole mp4 takes a lot longer to send than the server's write timeout, it gets -download-timeout instead
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(app.config.downloadTimeout))
+
+	root := filepath.Join(app.config.videoDir, strconv.Itoa(id))
+	name := chi.URLParam(r, "file")
+
+	ext := strings.ToLower(path.Ext(name))
+	if ext != ".m3u8" && ext != ".mpd" {
+		// the grant runs out, but what it gets you doesn't change
+		serveMedia(w, r, root, name, "private, max-age=86400")
+		return
+	}
+
+	f, _, err := openMediaFile(root, name)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	body, err := io.ReadAll(f)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	grant := url.Values{}
+	grant.Set("expires", r.URL.Query().Get("expires"))
+	grant.Set("sig", r.URL.Query().Get("sig"))
+
+	if ext == ".mpd" {
+		body = signManifest(body, grant.Encode())
+	} else {
+		body = signPlaylist(body, grant.Encode())
+	}
+
+	// the grant is in the body, so nothing in between may keep it around for longer than it lasts
+	w.Header().Set("Content-Type", streamer.ContentType(name))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	_, _ = w.Write(body)
+}
+
+// VideoKey hands the AES-128 key of an encrypted video to a player holding a signed url for it
+func (app *application) VideoKey(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	if err := app.verifyVideoSignature(r, id); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	key, err := os.ReadFile(app.videoKeyFile(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		_ = t.ErrorJSON(w, errors.New("this video is not encrypted"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(key)
+}
+
+// signPlaylist adds query to every URI in an HLS playlist: the lines naming variant playlists and segments, and the
+// URI attributes of tags
+func signPlaylist(playlist []byte, query string) []byte {
+	lines := bytes.Split(playlist, []byte("\n"))
+
+	for i, line := range lines {
+		trimmed := bytes.TrimSpace(line)
+		switch {
+		case len(trimmed) == 0:
+		case trimmed[0] == '#':
+			lines[i] = hlsURIAttr.ReplaceAllFunc(line, func(attr []byte) []byte {
+				uri := hlsURIAttr.FindSubmatch(attr)[1]
+				return []byte(`URI="` + addQuery(string(uri), query) + `"`)
+			})
+		default:
+			lines[i] = []byte(addQuery(string(trimmed), query))
+		}
+	}
+
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// signManifest adds query to the segment templates and urls of a DASH manifest. It is XML, so the & has to be escaped
+func signManifest(manifest []byte, query string) []byte {
+	query = strings.ReplaceAll(query, "&", "&amp;")
+
+	return dashURIAttr.ReplaceAllFunc(manifest, func(attr []byte) []byte {
+		m := dashURIAttr.FindSubmatch(attr)
+		return []byte(string(m[1]) + `="` + addQuery(string(m[2]), query) + `"`)
+	})
+}
+
+// addQuery appends query to a URI that may already have one
+func addQuery(uri, query string) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + query
+	}
+
+	return uri + "?" + query
+}
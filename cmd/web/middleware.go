@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/tsawler/toolbox"
+)
+
+// requireAdmin only lets through requests that send the admin token (set with -admin-token) as a bearer token.
+// When no token is configured the admin api is switched off entirely
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var t toolbox.Tools
+
+		if app.config.adminToken == "" {
+			_ = t.ErrorJSON(w, errors.New("the admin api is disabled"), http.StatusForbidden)
+			return
+		}
+
+		if !app.isAdmin(r) {
+			_ = t.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether the request carries the admin token, for handlers anyone can call that do more for admins
+func (app *application) isAdmin(r *http.Request) bool {
+	if app.config.adminToken == "" {
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(app.config.adminToken)) == 1
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// pagination holds everything a template needs to draw previous/next links for a list
+type pagination struct {
+	Page       int    `json:"page"`
+	PageSize   int    `json:"page_size"`
+	Total      int    `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	PrevURL    string `json:"-"`
+	NextURL    string `json:"-"`
+}
+
+// pageFromRequest reads the page and page_size query parameters, falling back to the first page
+func pageFromRequest(r *http.Request) (page, pageSize int) {
+	page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	pageSize, _ = strconv.Atoi(r.URL.Query().Get("page_size"))
+
+	return page, pageSize
+}
+
+// newPagination works out the page count and builds the previous/next links, keeping any other query parameters (filters) intact
+func newPagination(r *http.Request, page, pageSize, total int) pagination {
+	p := pagination{
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+	}
+
+	if pageSize > 0 {
+		p.TotalPages = (total + pageSize - 1) / pageSize
+	}
+
+	pageURL := func(n int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(n))
+		return r.URL.Path + "?" + q.Encode()
+	}
+
+	if page > 1 {
+		p.PrevURL = pageURL(page - 1)
+	}
+
+	if page < p.TotalPages {
+		p.NextURL = pageURL(page + 1)
+	}
+
+	return p
+}
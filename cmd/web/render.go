@@ -47,6 +47,7 @@ func (app *application) buildTemplateFromDisk(t string) (*template.Template, err
 		"./templates/base.layout.tmpl",
 		"./templates/partials/header.partial.tmpl",
 		"./templates/partials/footer.partial.tmpl",
+		"./templates/partials/pagination.partial.tmpl",
 		fmt.Sprintf("./templates/%s", t),
 	}
 
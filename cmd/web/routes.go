@@ -12,34 +12,101 @@ func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
-	mux.Use(middleware.Timeout(60 * time.Second)) // after 60 seconds the request will timeout
 
-	// this if for serving static images
-	// fileServer := http.FileServer(http.Dir("./static/"))
-	// mux.Handle("/static/*", http.StripPrefix("/static/", fileserver))
+	// long lived streams, these can't be cut off by the request timeout below
+	mux.Get("/api/videos/{id}/progress", app.VideoProgressStream)
 
-	mux.Get("/dog-of-month", app.DogOfMonth)
+	// uploads can take a lot longer than 60 seconds, UploadVideo sets its own deadline
+	mux.Post("/api/videos", app.UploadVideo)
 
-	// display our test page
-	mux.Get("/test-patterns", app.TestPatterns)
+	// encoded videos can take a while to download, and need a signed url
+	mux.Get("/videos/{id}/{file}", app.VideoMedia)
+	mux.Head("/videos/{id}/{file}", app.VideoMedia)
 
-	// builder routes
-	mux.Get("/api/dog-from-builder", app.CreateDogWithBuilder)
-	mux.Get("/api/cat-from-builder", app.CreateCatWithBuilder)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(middleware.Timeout(60 * time.Second)) // after 60 seconds the request will timeout
 
-	// Factory Routers
-	mux.Get("/api/dog-from-factory", app.CreateDogFromFactory)
-	mux.Get("/api/cat-from-factory", app.CreateCatFromFactory)
-	mux.Get("/api/dog-from-abstract-factory", app.CreateDogFromAbstractFactory)
-	mux.Get("/api/cat-from-abstract-factory", app.CreateCatFromAbstractFactory)
+		// this if for serving static images
+		mux.Get("/static/*", app.StaticFile)
+		mux.Head("/static/*", app.StaticFile)
 
-	mux.Get("/", app.ShowHome)
-	mux.Get("/{page}", app.ShowPage)
+		mux.Get("/dog-of-month", app.DogOfMonth)
 
-	mux.Get("/api/dog-breeds", app.GetAllDogBreedsJSON)
-	mux.Get("/api/cat-breeds", app.GetAllCatBreeds)
+		// breeder directory pages
+		mux.Get("/dog-breeders", app.DogBreeders)
+		mux.Get("/cat-breeders", app.CatBreeders)
+		mux.Get("/breeders/{id}", app.ShowBreeder)
 
-	mux.Get("/api/animal-from-abstract-factory/{species}/{breed}", app.AnimalFromAbstractFactory)
+		// display our test page
+		mux.Get("/test-patterns", app.TestPatterns)
+
+		// builder routes
+		mux.Get("/api/dog-from-builder", app.CreateDogWithBuilder)
+		mux.Get("/api/cat-from-builder", app.CreateCatWithBuilder)
+
+		// Factory Routers
+		mux.Get("/api/dog-from-factory", app.CreateDogFromFactory)
+		mux.Get("/api/cat-from-factory", app.CreateCatFromFactory)
+		mux.Get("/api/dog-from-abstract-factory", app.CreateDogFromAbstractFactory)
+		mux.Get("/api/cat-from-abstract-factory", app.CreateCatFromAbstractFactory)
+
+		mux.Get("/", app.ShowHome)
+		mux.Get("/{page}", app.ShowPage)
+
+		mux.Get("/api/dog-breeds", app.GetAllDogBreedsJSON)
+		mux.Get("/api/dog-breeds/{id}", app.GetDogBreedByIDJSON)
+		mux.Get("/api/cat-breeds", app.GetAllCatBreeds)
+
+		mux.Get("/api/breeders", app.GetAllBreedersJSON)
+		mux.Get("/api/breeders/{id}", app.GetBreederByIDJSON)
+
+		mux.Get("/api/dogs", app.GetAllDogsJSON)
+		mux.Get("/api/dogs/{id}", app.GetDogByIDJSON)
+
+		mux.Get("/api/cats", app.GetAllCatsJSON)
+		mux.Get("/api/cats/{id}", app.GetCatByIDJSON)
+
+		mux.Get("/api/animal-from-abstract-factory/{species}/{breed}", app.AnimalFromAbstractFactory)
+
+		mux.Get("/api/dog-of-month", app.CurrentDogOfMonthJSON)
+		mux.Get("/api/dog-of-month/history", app.DogOfMonthHistoryJSON)
+
+		mux.Get("/api/videos", app.AllVideoJobsJSON)
+		mux.Get("/api/videos/{id}", app.GetVideoJobJSON)
+		// players need the key, it is only handed out with a signed url for the video
+		mux.Get("/api/videos/{id}/key", app.VideoKey)
+
+		// admin routes, these need the admin token
+		mux.Route("/api/admin", func(mux chi.Router) {
+			mux.Use(app.requireAdmin)
+
+			mux.Post("/dog-breeds", app.CreateDogBreedJSON)
+			mux.Put("/dog-breeds/{id}", app.UpdateDogBreedJSON)
+			mux.Delete("/dog-breeds/{id}", app.DeleteDogBreedJSON)
+
+			mux.Post("/dogs", app.CreateDogJSON)
+			mux.Put("/dogs/{id}", app.UpdateDogJSON)
+			mux.Delete("/dogs/{id}", app.DeleteDogJSON)
+
+			mux.Post("/cats", app.CreateCatJSON)
+			mux.Put("/cats/{id}", app.UpdateCatJSON)
+			mux.Delete("/cats/{id}", app.DeleteCatJSON)
+
+			mux.Get("/dog-of-month", app.AllDogsOfMonthJSON)
+			mux.Post("/dog-of-month", app.ScheduleDogOfMonthJSON)
+			mux.Put("/dog-of-month/{id}", app.UpdateDogOfMonthJSON)
+			mux.Delete("/dog-of-month/{id}", app.DeleteDogOfMonthJSON)
+			mux.Put("/dog-of-month/{id}/video", app.AttachVideoToDogOfMonthJSON)
+
+			mux.Get("/videos/{id}/playback", app.VideoPlaybackJSON)
+			mux.Get("/videos/queue", app.VideoQueueStatsJSON)
+			mux.Put("/videos/workers", app.ResizeVideoWorkersJSON)
+			mux.Post("/videos/{id}/cancel", app.CancelVideoJSON)
+			mux.Get("/videos/dead-letters", app.VideoDeadLettersJSON)
+			mux.Post("/videos/dead-letters/{id}/requeue", app.RequeueVideoJSON)
+			mux.Get("/videos/webhooks", app.VideoWebhooksJSON)
+		})
+	})
 
 	return mux
 }
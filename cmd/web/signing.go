@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	errMissingSignature = errors.New("this video needs a signed url")
+	errBadSignature     = errors.New("the signature of this url is not valid")
+	errExpiredSignature = errors.New("this url has expired")
+)
+
+// signVideo signs a grant to play the video with the given id until expires, a unix time. One grant covers every
+// file of the video, so the playlists can hand it on to their segments and key
+func (app *application) signVideo(id int, expires int64) string {
+	mac := hmac.New(sha256.New, app.config.signingKey)
+	fmt.Fprintf(mac, "%d:%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedVideoQuery is the query string that lets the holder play the video for the next ttl
+func (app *application) signedVideoQuery(id int, ttl time.Duration) (url.Values, time.Time) {
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", app.signVideo(id, expires.Unix()))
+
+	return q, expires
+}
+
+// verifyVideoSignature checks the request carries an unexpired grant for the video
+func (app *application) verifyVideoSignature(r *http.Request, id int) error {
+	q := r.URL.Query()
+	if q.Get("expires") == "" || q.Get("sig") == "" {
+		return errMissingSignature
+	}
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("sig"))
+	if err != nil {
+		return errBadSignature
+	}
+
+	want, _ := base64.RawURLEncoding.DecodeString(app.signVideo(id, expires))
+	if !hmac.Equal(sig, want) {
+		return errBadSignature
+	}
+
+	if time.Now().Unix() > expires {
+		return errExpiredSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"go-breeders/models"
+	"strings"
+	"time"
+)
+
+const (
+	maxNameLength = 255
+	maxPathLength = 512
+)
+
+// validateDogBreed checks a dog breed sent to the api before it is written to the database
+func validateDogBreed(b *models.DogBreed) error {
+	b.Breed = strings.TrimSpace(b.Breed)
+
+	switch {
+	case b.Breed == "":
+		return errors.New("breed is required")
+	case len(b.Breed) > maxNameLength:
+		return errors.New("breed must be 255 characters or less")
+	case b.WeightLowLbs < 0 || b.WeightHighLbs < 0:
+		return errors.New("weights cannot be negative")
+	case b.WeightLowLbs > b.WeightHighLbs:
+		return errors.New("weight_low_lbs must be less than or equal to weight_high_lbs")
+	case b.Lifespan < 0:
+		return errors.New("average_lifespan cannot be negative")
+	case len(b.AlternateNames) > maxNameLength:
+		return errors.New("alternate_names must be 255 characters or less")
+	case len(b.GeographicOrigin) > maxNameLength:
+		return errors.New("geographic_origin must be 255 characters or less")
+	}
+
+	return nil
+}
+
+// validatePet checks the fields that dogs and cats have in common
+func validatePet(name, color string, dob time.Time, spayedOrNeutered, weight int) error {
+	switch {
+	case name == "":
+		return errors.New("name is required")
+	case len(name) > maxNameLength:
+		return errors.New("name must be 255 characters or less")
+	case color == "":
+		return errors.New("color is required")
+	case len(color) > maxNameLength:
+		return errors.New("color must be 255 characters or less")
+	case dob.IsZero():
+		return errors.New("date_of_birth is required")
+	case dob.After(time.Now()):
+		return errors.New("date_of_birth cannot be in the future")
+	case spayedOrNeutered != 0 && spayedOrNeutered != 1:
+		return errors.New("spayed_neutered must be 0 or 1")
+	case weight <= 0:
+		return errors.New("weight must be greater than zero")
+	}
+
+	return nil
+}
+
+func validateDog(d *models.Dog) error {
+	d.DogName = strings.TrimSpace(d.DogName)
+	d.Color = strings.TrimSpace(d.Color)
+
+	return validatePet(d.DogName, d.Color, d.DateOfBirth, d.SpayedOrNeutered, d.Weight)
+}
+
+func validateCat(c *models.Cat) error {
+	c.CatName = strings.TrimSpace(c.CatName)
+	c.Color = strings.TrimSpace(c.Color)
+
+	return validatePet(c.CatName, c.Color, c.DateOfBirth, c.SpayedOrNeutered, c.Weight)
+}
+
+// validateDogOfMonth checks a dog of the month entry. When scheduling is true the month must not be in the past
+func validateDogOfMonth(dom *models.DogOfMonth, now time.Time, scheduling bool) error {
+	dom.Image = strings.TrimSpace(dom.Image)
+	dom.Video = strings.TrimSpace(dom.Video)
+
+	switch {
+	case dom.DogID <= 0:
+		return errors.New("dog_id is required")
+	case dom.Month < 1 || dom.Month > 12:
+		return errors.New("month must be between 1 and 12")
+	case dom.Year < 2000 || dom.Year > 9999:
+		return errors.New("year is not valid")
+	case len(dom.Image) > maxPathLength:
+		return errors.New("image must be 512 characters or less")
+	case len(dom.Video) > maxPathLength:
+		return errors.New("video must be 512 characters or less")
+	}
+
+	if scheduling && (dom.Year < now.Year() || (dom.Year == now.Year() && dom.Month < int(now.Month()))) {
+		return errors.New("cannot schedule a dog of the month in the past")
+	}
+
+	return nil
+}
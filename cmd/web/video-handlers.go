@@ -0,0 +1,829 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-breeders/models"
+	"go-breeders/streamer"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tsawler/toolbox"
+)
+
+// videoExtensions are the kinds of files we accept for encoding
+var videoExtensions = map[string]bool{
+	".mp4":  true,
+	".m4v":  true,
+	".mov":  true,
+	".mkv":  true,
+	".webm": true,
+	".avi":  true,
+}
+
+// maxEncodeTimeout is the longest per-job timeout, in seconds, an upload can ask for
+const maxEncodeTimeout = 24 * 60 * 60
+
+// maxEncodeAttempts is the most times an upload can ask for its video to be encoded before we give up on it
+const maxEncodeAttempts = 10
+
+// maxRetryBackoff is the longest wait, in seconds, an upload can ask for before the first retry
+const maxRetryBackoff = 60 * 60
+
+// maxThumbnails is the most evenly spaced thumbnails an upload can ask for
+const maxThumbnails = 50
+
+// maxSpriteInterval is the longest time, in seconds, an upload can ask for between the tiles of its sprite sheet
+const maxSpriteInterval = 10 * 60
+
+// videoOptionsFromForm reads the encoding settings sent along with an upload
+func videoOptionsFromForm(r *http.Request) (string, *streamer.VideoOptions, error) {
+	encType := r.FormValue("encoding_type")
+	if encType == "" {
+		encType = "mp4"
+	}
+
+	if _, ok := streamer.LookupFormat(encType); !ok {
+		return "", nil, fmt.Errorf("encoding_type must be one of %s", strings.Join(streamer.FormatNames(), ", "))
+	}
+
+	options := &streamer.VideoOptions{
+		RenameOutput:    r.FormValue("rename_output") == "true" || r.FormValue("rename_output") == "1",
+		SegmentDuration: 10,
+		MaxRate1080p:    "1200k",
+		MaxRate720p:     "600k",
+		MaxRate480p:     "400k",
+		Retry:           streamer.DefaultRetryPolicy,
+	}
+
+	if v := r.FormValue("segment_duration"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 60 {
+			return "", nil, errors.New("segment_duration must be between 1 and 60 seconds")
+		}
+		options.SegmentDuration = n
+	}
+
+	if v := r.FormValue("timeout"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxEncodeTimeout {
+			return "", nil, fmt.Errorf("timeout must be between 0 (no limit) and %d seconds", maxEncodeTimeout)
+		}
+		options.Timeout = time.Duration(n) * time.Second
+	}
+
+	if v := r.FormValue("max_attempts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxEncodeAttempts {
+			return "", nil, fmt.Errorf("max_attempts must be between 1 and %d", maxEncodeAttempts)
+		}
+		options.Retry.MaxAttempts = n
+	}
+
+	if v := r.FormValue("retry_backoff"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxRetryBackoff {
+			return "", nil, fmt.Errorf("retry_backoff must be between 0 and %d seconds", maxRetryBackoff)
+		}
+		options.Retry.InitialBackoff = time.Duration(n) * time.Second
+		options.Retry.MaxBackoff = max(options.Retry.MaxBackoff, options.Retry.InitialBackoff)
+	}
+
+	rates := []struct {
+		field string
+		dest  *string
+	}{
+		{"maxrate_1080p", &options.MaxRate1080p},
+		{"maxrate_720p", &options.MaxRate720p},
+		{"maxrate_480p", &options.MaxRate480p},
+	}
+
+	for _, rate := range rates {
+		if v := r.FormValue(rate.field); v != "" {
+			if !streamer.ValidBitrate(v) {
+				return "", nil, fmt.Errorf("%s must be a bitrate like 1200k", rate.field)
+			}
+			*rate.dest = v
+		}
+	}
+
+	// a ladder replaces the default 1080p/720p/480p renditions, and with them the maxrate fields
+	if v := r.FormValue("ladder"); v != "" {
+		if err := json.Unmarshal([]byte(v), &options.Ladder); err != nil {
+			return "", nil, errors.New("ladder must be a JSON array of renditions")
+		}
+
+		if err := streamer.ValidateLadder(options.Ladder); err != nil {
+			return "", nil, err
+		}
+	}
+
+	options.Preset = r.FormValue("preset")
+	if err := streamer.ValidatePreset(options.Preset); err != nil {
+		return "", nil, err
+	}
+
+	// naming is a strategy (basename, id, hash or random) or a template like {id}-{basename}
+	if v := r.FormValue("naming"); v != "" {
+		naming, err := streamer.ParseNaming(v)
+		if err != nil {
+			return "", nil, err
+		}
+		options.Naming = naming
+	}
+
+	onExisting, err := streamer.ParseOnExisting(r.FormValue("on_existing"))
+	if err != nil {
+		return "", nil, err
+	}
+	options.OnExisting = onExisting
+
+	options.WebhookURL = r.FormValue("webhook_url")
+	if options.WebhookURL != "" {
+		if err := streamer.ValidateWebhookURL(options.WebhookURL); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// the key file and URI depend on the job id, UploadVideo fills them in
+	if v := r.FormValue("encrypt"); v == "true" || v == "1" {
+		if encType != "hls" {
+			return "", nil, errors.New("encrypt only works with the hls encoding type")
+		}
+		options.Encryption = &streamer.EncryptionOptions{}
+	}
+
+	// images are only made when asked for, with poster=true or any of the fields that say what to make. Asking for
+	// any of them gets a poster frame too
+	thumbnails := &streamer.ThumbnailOptions{}
+	wantThumbnails := r.FormValue("poster") == "true" || r.FormValue("poster") == "1"
+
+	if v := r.FormValue("poster_at"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 || n > maxEncodeTimeout {
+			return "", nil, errors.New("poster_at must be a number of seconds into the video")
+		}
+		thumbnails.PosterAt = time.Duration(n * float64(time.Second))
+		wantThumbnails = true
+	}
+
+	if v := r.FormValue("thumbnails"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxThumbnails {
+			return "", nil, fmt.Errorf("thumbnails must be between 0 and %d", maxThumbnails)
+		}
+		thumbnails.Count = n
+		wantThumbnails = true
+	}
+
+	if v := r.FormValue("thumbnail_width"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 16 || n > 1920 || n%2 != 0 {
+			return "", nil, errors.New("thumbnail_width must be an even number of pixels between 16 and 1920")
+		}
+		thumbnails.Width = n
+		wantThumbnails = true
+	}
+
+	if v := r.FormValue("sprite_interval"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxSpriteInterval {
+			return "", nil, fmt.Errorf("sprite_interval must be between 0 (no sprite sheet) and %d seconds", maxSpriteInterval)
+		}
+		thumbnails.SpriteInterval = time.Duration(n) * time.Second
+		wantThumbnails = true
+	}
+
+	if wantThumbnails {
+		options.Thumbnails = thumbnails
+	}
+
+	return encType, options, nil
+}
+
+// UploadVideo stores a multipart upload (in the "video" field) and queues it for encoding. The response has the job
+// id, which can be used to attach the encoded video to a dog of the month entry. An optional dog_of_month_id field
+// does that as soon as the encode finishes. The priority field puts the video in the high, normal or low lane; only
+// admins can ask for high
+func (app *application) UploadVideo(w http.ResponseWriter, r *http.Request) {
+	t := toolbox.Tools{MaxFileSize: app.config.maxUploadSize}
+
+	// turn the upload away before reading it if there is no room to encode it
+	if err := app.videoDispatcher.CanSubmit(); err != nil {
+		app.videoQueueError(w, err)
+		return
+	}
+
+	// a big upload takes a lot longer than the server's read and write timeouts, it gets -upload-timeout instead
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(app.config.uploadTimeout)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(app.config.maxUploadSize)+(1<<20))
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		_ = t.ErrorJSON(w, fmt.Errorf("error parsing form data: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	encType, options, err := videoOptionsFromForm(r)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	priority, err := streamer.ParsePriority(r.FormValue("priority"))
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// the key URI in the playlists points back at us, which only works for playlists we serve
+	if options.Encryption != nil && app.config.s3.Bucket != "" {
+		_ = t.ErrorJSON(w, errors.New("encrypt only works for videos kept on this server, not in s3"), http.StatusBadRequest)
+		return
+	}
+
+	if options.WebhookURL != "" && app.webhooks == nil {
+		_ = t.ErrorJSON(w, errors.New("webhooks are not set up on this server"), http.StatusBadRequest)
+		return
+	}
+
+	if options.WebhookURL != "" && !app.webhookAllowed(r, options.WebhookURL) {
+		_ = t.ErrorJSON(w, errors.New("only admins can ask for a webhook to a host that isn't on the allowlist"), http.StatusForbidden)
+		return
+	}
+
+	if priority == streamer.PriorityHigh && !app.isAdmin(r) {
+		_ = t.ErrorJSON(w, errors.New("only admins can queue a video with high priority"), http.StatusForbidden)
+		return
+	}
+
+	var domID int
+	if v := r.FormValue("dog_of_month_id"); v != "" {
+		domID, err = strconv.Atoi(v)
+		if err != nil {
+			_ = t.ErrorJSON(w, errors.New("dog_of_month_id must be a number"), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := app.App.Models.DogOfMonth.Get(domID); err != nil {
+			_ = t.ErrorJSON(w, err, statusFromDBError(err))
+			return
+		}
+	}
+
+	uploaded, err := t.UploadOneFile(r, app.config.uploadDir, true)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	inputFile := filepath.Join(app.config.uploadDir, uploaded.NewFileName)
+	if !videoExtensions[strings.ToLower(filepath.Ext(uploaded.OriginalFileName))] {
+		_ = os.Remove(inputFile)
+		_ = t.ErrorJSON(w, errors.New("the uploaded file is not a supported video type"), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	job := &models.VideoJob{
+		EncodingType: encType,
+		InputFile:    inputFile,
+		Priority:     string(priority),
+		DogOfMonthID: domID,
+	}
+	if err := job.Insert(); err != nil {
+		_ = os.Remove(inputFile)
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// every job gets its own output directory, so two uploads with the same name can't overwrite each other. In s3
+	// that is a prefix in the bucket, which needs no creating
+	job.OutputDir = filepath.Join(app.config.videoDir, strconv.Itoa(job.ID))
+	if app.config.s3.Bucket != "" {
+		job.OutputDir = path.Join("videos", strconv.Itoa(job.ID))
+	} else if err := os.MkdirAll(job.OutputDir, 0755); err != nil {
+		_ = job.SetStatus(models.VideoJobFailed, "", err.Error())
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := job.Update(); err != nil {
+		_ = job.SetStatus(models.VideoJobFailed, "", err.Error())
+		_ = os.Remove(inputFile)
+		app.removeOutputDir(job)
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if options.Encryption != nil {
+		options.Encryption.KeyFile = app.videoKeyFile(job.ID)
+		options.Encryption.KeyURI = fmt.Sprintf("/api/videos/%d/key", job.ID)
+	}
+
+	if err := app.queueVideo(job, options); err != nil {
+		// the queue filled up while we were saving the upload
+		_ = job.SetStatus(models.VideoJobFailed, "", err.Error())
+		_ = os.Remove(inputFile)
+		app.removeOutputDir(job)
+		app.videoQueueError(w, err)
+		return
+	}
+
+	// read it back for the timestamps the database filled in
+	if saved, err := app.App.Models.VideoJob.Get(job.ID); err == nil {
+		job = saved
+	}
+
+	_ = t.WriteJSON(w, http.StatusAccepted, job)
+}
+
+// webhookAllowed reports whether the request may have the result of its job posted to webhook. Anyone can use the
+// hosts in -webhook-hosts, admins can use any host ValidateWebhookURL accepts
+func (app *application) webhookAllowed(r *http.Request, webhook string) bool {
+	if app.isAdmin(r) {
+		return true
+	}
+
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(app.config.webhookHosts, strings.ToLower(strings.TrimSuffix(u.Hostname(), ".")))
+}
+
+// removeOutputDir removes the output directory made for a job that won't be encoded after all. In s3 the output dir
+// is a prefix in the bucket, with nothing on this machine to remove
+func (app *application) removeOutputDir(job *models.VideoJob) {
+	if app.config.s3.Bucket != "" {
+		return
+	}
+
+	_ = os.Remove(job.OutputDir)
+}
+
+// videoJobList is the response for a page of video jobs
+type videoJobList struct {
+	Jobs []*models.VideoJob `json:"jobs"`
+	pagination
+}
+
+// AllVideoJobsJSON lists video jobs, newest first. The status query parameter limits it to one status
+func (app *application) AllVideoJobsJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	page, pageSize := pageFromRequest(r)
+	f := &models.VideoJobFilter{
+		Status:   r.URL.Query().Get("status"),
+		Page:     page,
+		PageSize: pageSize,
+	}
+
+	switch f.Status {
+	case "", models.VideoJobQueued, models.VideoJobRunning, models.VideoJobRetrying, models.VideoJobSucceeded, models.VideoJobFailed, models.VideoJobCancelled:
+	default:
+		_ = t.ErrorJSON(w, errors.New("status must be queued, running, succeeded, failed or cancelled"), http.StatusBadRequest)
+		return
+	}
+
+	jobs, total, err := app.App.Models.VideoJob.All(f)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if jobs == nil {
+		jobs = []*models.VideoJob{}
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, videoJobList{
+		Jobs:       jobs,
+		pagination: newPagination(r, f.Page, f.Limit(), total),
+	})
+}
+
+func (app *application) GetVideoJobJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	job, err := app.App.Models.VideoJob.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, job)
+}
+
+// videoPlayback is a signed url to play an encoded video with
+type videoPlayback struct {
+	URL       string    `json:"url"`
+	Poster    string    `json:"poster,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// VideoPlaybackJSON hands out a signed url for an encoded video, good for the -playback-ttl (admin). The same grant
+// covers the poster and, for HLS, the segments and key the playlist points at. Public pages get theirs signed on the
+// server, like the dog of the month does
+func (app *application) VideoPlaybackJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	job, err := app.App.Models.VideoJob.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	if job.Status != models.VideoJobSucceeded {
+		_ = t.ErrorJSON(w, fmt.Errorf("video %d is not ready to play, it is %s", id, job.Status), http.StatusConflict)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, app.newVideoPlayback(job))
+}
+
+// videoQueueStats is how busy the worker pool is
+type videoQueueStats struct {
+	Workers     int                       `json:"workers"`
+	Depth       int                       `json:"depth"`
+	ByPriority  map[streamer.Priority]int `json:"depth_by_priority"`
+	Limit       int                       `json:"limit"`
+	OldestWait  float64                   `json:"oldest_wait_seconds"`
+	AverageWait float64                   `json:"average_wait_seconds"`
+}
+
+// VideoQueueStatsJSON shows how many encoders there are, and how many videos are waiting for one and for how long (admin)
+func (app *application) VideoQueueStatsJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	_ = t.WriteJSON(w, http.StatusOK, videoQueueStats{
+		Workers:     app.videoDispatcher.Workers(),
+		Depth:       app.videoDispatcher.QueueDepth(),
+		ByPriority:  app.videoDispatcher.QueueDepthByPriority(),
+		Limit:       app.videoDispatcher.QueueLimit(),
+		OldestWait:  app.videoDispatcher.OldestWait().Seconds(),
+		AverageWait: app.videoDispatcher.AverageWait().Seconds(),
+	})
+}
+
+// maxVideoWorkers is the most encoders the pool can be resized to. Every one of them runs its own ffmpeg
+const maxVideoWorkers = 64
+
+// videoWorkers is the body of a request to resize the worker pool
+type videoWorkers struct {
+	Workers int `json:"workers"`
+}
+
+// ResizeVideoWorkersJSON grows or shrinks the encoder pool (admin). Workers that are stopped finish the video they are
+// encoding first
+func (app *application) ResizeVideoWorkersJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	var req videoWorkers
+	if err := t.ReadJSON(w, r, &req); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.Workers < 1 || req.Workers > maxVideoWorkers {
+		_ = t.ErrorJSON(w, fmt.Errorf("workers must be between 1 and %d", maxVideoWorkers), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := app.videoDispatcher.Resize(req.Workers); err != nil {
+		app.videoQueueError(w, err)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, videoWorkers{Workers: app.videoDispatcher.Workers()})
+}
+
+// CancelVideoJSON stops a video that is waiting for a worker or being encoded (admin). The job is marked cancelled once
+// the worker pool confirms it
+func (app *application) CancelVideoJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	job, err := app.App.Models.VideoJob.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	if job.Finished() || !app.videoDispatcher.Cancel(id) {
+		_ = t.ErrorJSON(w, fmt.Errorf("video %d is not queued or encoding", id), http.StatusConflict)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusAccepted, toolbox.JSONResponse{
+		Message: fmt.Sprintf("video %d is being cancelled", id),
+		Data:    job,
+	})
+}
+
+// videoDeadLetter is a video that ran out of encode attempts, as shown by the admin API
+type videoDeadLetter struct {
+	ID           int       `json:"id"`
+	EncodingType string    `json:"encoding_type"`
+	Attempts     int       `json:"attempts"`
+	Error        string    `json:"error"`
+	FailedAt     time.Time `json:"failed_at"`
+}
+
+// VideoDeadLettersJSON lists the videos that failed on their last encode attempt, oldest first (admin)
+func (app *application) VideoDeadLettersJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	deadLetters := []videoDeadLetter{}
+	for _, dl := range app.videoDispatcher.DeadLetters() {
+		deadLetters = append(deadLetters, videoDeadLetter{
+			ID:           dl.Video.ID,
+			EncodingType: dl.Video.EncodingType,
+			Attempts:     dl.Attempts,
+			Error:        dl.Error,
+			FailedAt:     dl.FailedAt,
+		})
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, deadLetters)
+}
+
+// RequeueVideoJSON puts a dead-lettered video back on the encode queue with a fresh set of attempts (admin)
+func (app *application) RequeueVideoJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	job, err := app.App.Models.VideoJob.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	// mark it queued first, so it can't overwrite a worker that picks the video up straight away
+	previous := *job
+	job.Attempts = 0
+	if err := job.SetStatus(models.VideoJobQueued, "", ""); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.videoDispatcher.Requeue(id); err != nil {
+		_ = previous.SetStatus(previous.Status, previous.OutputFile, previous.ErrorMessage)
+
+		if errors.Is(err, streamer.ErrNotDeadLettered) {
+			_ = t.ErrorJSON(w, err, http.StatusNotFound)
+			return
+		}
+
+		app.videoQueueError(w, err)
+		return
+	}
+
+	job.Status, job.OutputFile, job.ErrorMessage = models.VideoJobQueued, "", ""
+
+	_ = t.WriteJSON(w, http.StatusAccepted, toolbox.JSONResponse{
+		Message: fmt.Sprintf("video %d is back in the queue", id),
+		Data:    job,
+	})
+}
+
+// videoWebhookDelivery is an attempt at posting the result of a job to its webhook, as shown by the admin API
+type videoWebhookDelivery struct {
+	ID          string     `json:"id"`
+	VideoID     int        `json:"video_id"`
+	URL         string     `json:"url"`
+	Event       string     `json:"event"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	StatusCode  int        `json:"status_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+}
+
+// VideoWebhooksJSON lists the recent webhook deliveries, oldest first. The video_id query parameter limits it to the
+// deliveries for one job (admin)
+func (app *application) VideoWebhooksJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	var videoID int
+	if v := r.URL.Query().Get("video_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			_ = t.ErrorJSON(w, errors.New("video_id must be a number"), http.StatusBadRequest)
+			return
+		}
+		videoID = id
+	}
+
+	deliveries := []videoWebhookDelivery{}
+	if app.webhooks != nil {
+		for _, d := range app.webhooks.Deliveries() {
+			if videoID != 0 && d.VideoID != videoID {
+				continue
+			}
+
+			delivery := videoWebhookDelivery{
+				ID:         d.ID,
+				VideoID:    d.VideoID,
+				URL:        d.URL,
+				Event:      d.Event,
+				Status:     d.Status,
+				Attempts:   d.Attempts,
+				StatusCode: d.StatusCode,
+				Error:      d.Error,
+				CreatedAt:  d.CreatedAt,
+			}
+			if !d.DeliveredAt.IsZero() {
+				delivery.DeliveredAt = &d.DeliveredAt
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, deliveries)
+}
+
+// AttachVideoToDogOfMonthJSON puts the output of an encode job on a dog of the month entry. If the job hasn't
+// finished yet the video is attached as soon as it does (admin)
+func (app *application) AttachVideoToDogOfMonthJSON(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	domID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		JobID int `json:"job_id"`
+	}
+
+	if err := t.ReadJSON(w, r, &payload); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.App.Models.DogOfMonth.Get(domID); err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	job, err := app.App.Models.VideoJob.Get(payload.JobID)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	if !job.Finished() {
+		pending, err := job.AttachToDogOfMonth(domID)
+		if err != nil {
+			_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if pending {
+			job.DogOfMonthID = domID
+			_ = t.WriteJSON(w, http.StatusAccepted, toolbox.JSONResponse{
+				Message: fmt.Sprintf("video %d will be attached to dog of the month %d when it finishes encoding", job.ID, domID),
+				Data:    job,
+			})
+			return
+		}
+
+		// it finished in the meantime, so see how it went
+		job, err = app.App.Models.VideoJob.Get(payload.JobID)
+		if err != nil {
+			_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if job.Status != models.VideoJobSucceeded {
+		_ = t.ErrorJSON(w, fmt.Errorf("video %d was not encoded (%s): %s", job.ID, job.Status, job.ErrorMessage), http.StatusConflict)
+		return
+	}
+
+	if err := app.attachVideoToDogOfMonth(domID, job); err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	dom, err := app.App.Models.DogOfMonth.Get(domID)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = t.WriteJSON(w, http.StatusOK, dom)
+}
+
+// VideoProgressStream sends the encode progress of a video as server-sent events, for a progress bar. Every update is
+// a "progress" event and the stream ends with a "done" event, after which the job has its final status
+func (app *application) VideoProgressStream(w http.ResponseWriter, r *http.Request) {
+	var t toolbox.Tools
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		_ = t.ErrorJSON(w, errors.New("invalid id"), http.StatusBadRequest)
+		return
+	}
+
+	// subscribe before looking at the job, so it can't finish in between without us hearing about it
+	updates, unsubscribe := app.videoDispatcher.Progress.Subscribe(id)
+	defer unsubscribe()
+
+	job, err := app.App.Models.VideoJob.Get(id)
+	if err != nil {
+		_ = t.ErrorJSON(w, err, statusFromDBError(err))
+		return
+	}
+
+	// an encode can take a lot longer than the server's write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, p streamer.Progress) bool {
+		out, _ := json.Marshal(p)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, out); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if job.Finished() {
+		p := streamer.Progress{ID: id, Done: true}
+		if job.Status == models.VideoJobSucceeded {
+			p.Percent = 100
+		}
+		send("done", p)
+		return
+	}
+
+	// a comment every now and then stops proxies from closing an idle connection
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case p, ok := <-updates:
+			if !ok {
+				return
+			}
+
+			if p.Done {
+				send("done", p)
+				return
+			}
+
+			if !send("progress", p) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-app.shuttingDown:
+			return
+		}
+	}
+}
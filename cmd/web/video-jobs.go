@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-breeders/models"
+	"go-breeders/streamer"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/tsawler/toolbox"
+)
+
+// defaultRetryAfter is the Retry-After, in seconds, we send when the queue is full and we have no idea how fast it moves
+const defaultRetryAfter = 30
+
+// videoPath is where the encoded output of a job lives, relative to the video output directory, or its URL when it
+// was uploaded to s3
+func videoPath(job *models.VideoJob) string {
+	if storedRemotely(job.OutputFile) {
+		return job.OutputFile
+	}
+
+	return path.Join(strconv.Itoa(job.ID), job.OutputFile)
+}
+
+// storedRemotely reports whether a file of an encoded video is the URL it was uploaded to, rather than the name of a
+// file we serve
+func storedRemotely(file string) bool {
+	u, err := url.Parse(file)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// videoKeyFile is where the AES-128 key of an encrypted video is kept, away from the files anyone can fetch
+func (app *application) videoKeyFile(id int) string {
+	return filepath.Join(app.config.keyDir, fmt.Sprintf("%d.key", id))
+}
+
+// mediaURL is the signed url of a file of an encoded video
+func mediaURL(id int, file string, grant url.Values) string {
+	return fmt.Sprintf("/videos/%d/%s?%s", id, url.PathEscape(file), grant.Encode())
+}
+
+// newVideoPlayback signs a url for the encoded video of a finished job, and for its poster if it has one. Files
+// uploaded to s3 are handed out as they are, the bucket decides who gets to fetch them
+func (app *application) newVideoPlayback(job *models.VideoJob) *videoPlayback {
+	grant, expires := app.signedVideoQuery(job.ID, app.config.playbackTTL)
+
+	playbackURL := func(file string) string {
+		if storedRemotely(file) {
+			return file
+		}
+		return mediaURL(job.ID, file, grant)
+	}
+
+	playback := &videoPlayback{
+		URL:       playbackURL(job.OutputFile),
+		ExpiresAt: expires,
+	}
+
+	if job.Thumbnails != nil && job.Thumbnails.Poster != "" {
+		playback.Poster = playbackURL(job.Thumbnails.Poster)
+	}
+
+	return playback
+}
+
+// domPlayback signs a url for the video of a dog of the month entry. It is nil when the entry has no video, or its
+// video isn't one of our encodes
+func (app *application) domPlayback(dom *models.DogOfMonth) *videoPlayback {
+	if dom == nil || dom.Video == "" {
+		return nil
+	}
+
+	if storedRemotely(dom.Video) {
+		return &videoPlayback{URL: dom.Video}
+	}
+
+	// the video of an entry is where videoPath put it: the job id, then the output file
+	id, err := strconv.Atoi(path.Dir(dom.Video))
+	if err != nil {
+		return nil
+	}
+
+	job, err := app.App.Models.VideoJob.Get(id)
+	if err != nil {
+		log.Println("Error getting the video of the dog of the month:", err)
+		return nil
+	}
+
+	if job.Status != models.VideoJobSucceeded || videoPath(job) != dom.Video {
+		return nil
+	}
+
+	return app.newVideoPlayback(job)
+}
+
+// listenForVideoResults reads every message the worker pool sends back, records it in the job store and, when a job
+// was attached to a dog of the month, puts the encoded video on that entry
+func (app *application) listenForVideoResults() {
+	for msg := range app.videoNotify {
+		log.Println("listenForVideoResults:", msg.Message)
+
+		status, errorMessage := models.VideoJobRunning, ""
+		switch {
+		case msg.Status == streamer.StatusCancelled:
+			status, errorMessage = models.VideoJobCancelled, msg.Message
+		case msg.Status == streamer.StatusRetrying:
+			status, errorMessage = models.VideoJobRetrying, msg.Message
+		case msg.Done() && msg.Successful:
+			status = models.VideoJobSucceeded
+		case msg.Done():
+			status, errorMessage = models.VideoJobFailed, msg.Message
+		}
+
+		job := &models.VideoJob{ID: msg.ID, Attempts: msg.Attempt}
+		if err := job.SetStatus(status, msg.OutputFile, errorMessage); err != nil {
+			log.Println("listenForVideoResults: could not record status of video", msg.ID, err)
+			continue
+		}
+
+		if msg.Thumbnails != nil {
+			thumbnails := models.VideoThumbnails(*msg.Thumbnails)
+			if err := job.SetThumbnails(&thumbnails); err != nil {
+				log.Println("listenForVideoResults: could not record thumbnails of video", msg.ID, err)
+			}
+		}
+
+		// the job store has the result now, so the progress broker doesn't need to remember it
+		if msg.Done() {
+			if err := job.SetResult(videoResult(msg)); err != nil {
+				log.Println("listenForVideoResults: could not record result of video", msg.ID, err)
+			}
+			app.videoDispatcher.Progress.Forget(msg.ID)
+		}
+
+		if status != models.VideoJobSucceeded {
+			continue
+		}
+
+		// read the job back, since a dog of the month may have been attached while it was encoding
+		job, err := app.App.Models.VideoJob.Get(msg.ID)
+		if err != nil {
+			log.Println("listenForVideoResults: could not read video job", msg.ID, err)
+			continue
+		}
+
+		if job.DogOfMonthID != 0 {
+			if err := app.attachVideoToDogOfMonth(job.DogOfMonthID, job); err != nil {
+				log.Println("listenForVideoResults: could not attach video", job.ID, "to dog of the month", job.DogOfMonthID, err)
+			}
+		}
+	}
+}
+
+// videoResult is the final message for a video, as it is kept with its job
+func videoResult(msg streamer.ProcessingMessage) *models.VideoResult {
+	result := &models.VideoResult{
+		ErrorKind:  string(msg.ErrorKind),
+		OutputSize: msg.OutputSize,
+		StderrTail: msg.StderrTail,
+		Timings: models.VideoTimings{
+			Queued:     msg.Timings.Queued.Milliseconds(),
+			Probe:      msg.Timings.Probe.Milliseconds(),
+			Encode:     msg.Timings.Encode.Milliseconds(),
+			Thumbnails: msg.Timings.Thumbnails.Milliseconds(),
+			Publish:    msg.Timings.Publish.Milliseconds(),
+			Total:      msg.Timings.Total.Milliseconds(),
+		},
+	}
+
+	for _, a := range msg.Artifacts {
+		result.Artifacts = append(result.Artifacts, models.VideoArtifact{Name: a.Name, Kind: string(a.Kind), Size: a.Size, URL: a.URL})
+	}
+
+	return result
+}
+
+// attachVideoToDogOfMonth sets the video of a dog of the month entry to the output of a finished job
+func (app *application) attachVideoToDogOfMonth(domID int, job *models.VideoJob) error {
+	dom, err := app.App.Models.DogOfMonth.Get(domID)
+	if err != nil {
+		return err
+	}
+
+	if job.Status != models.VideoJobSucceeded {
+		return fmt.Errorf("video %d has not finished encoding", job.ID)
+	}
+
+	dom.Video = videoPath(job)
+	return dom.Update()
+}
+
+// queueVideo creates the video for a job and hands it to the worker pool, without waiting for room in the queue
+func (app *application) queueVideo(job *models.VideoJob, options *streamer.VideoOptions) error {
+	v := app.videoDispatcher.NewVideo(job.ID, job.InputFile, job.OutputDir, job.EncodingType, app.videoNotify, options)
+	v.Priority = streamer.Priority(job.Priority)
+	return app.videoDispatcher.TrySubmit(streamer.VideoProcessingJob{Video: v})
+}
+
+// videoQueueError answers a request the worker pool can't take: 429 with a Retry-After when the queue is full, 503
+// when the pool is shutting down
+func (app *application) videoQueueError(w http.ResponseWriter, err error) {
+	var t toolbox.Tools
+
+	var full *streamer.QueueFullError
+	if errors.As(err, &full) {
+		// the average wait is a fair guess of when a slot frees up
+		retry := int(math.Ceil(app.videoDispatcher.AverageWait().Seconds()))
+		if retry < 1 {
+			retry = defaultRetryAfter
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		_ = t.ErrorJSON(w, err, http.StatusTooManyRequests)
+		return
+	}
+
+	if errors.Is(err, streamer.ErrStopped) {
+		_ = t.ErrorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	_ = t.ErrorJSON(w, err, http.StatusInternalServerError)
+}
+
+// failInterruptedVideoJobs marks the jobs a previous run never finished as failed. The worker pool only keeps its
+// queue in memory, so nothing is going to pick them up again
+func (app *application) failInterruptedVideoJobs() {
+	n, err := app.App.Models.VideoJob.FailUnfinished("interrupted by a server restart, please upload the video again")
+	if err != nil {
+		log.Println("Error failing interrupted video jobs:", err)
+		return
+	}
+
+	if n > 0 {
+		log.Println("Marked", n, "interrupted video jobs as failed")
+	}
+}
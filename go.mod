@@ -6,10 +6,6 @@ require (
 	github.com/go-chi/chi/v5 v5.0.12
 	github.com/go-sql-driver/mysql v1.8.1
 	github.com/tsawler/toolbox v1.3.1
-	github.com/xfrr/goffmpeg v1.0.0
 )
 
-require (
-	filippo.io/edwards25519 v1.1.0 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
-)
+require filippo.io/edwards25519 v1.1.0 // indirect
@@ -0,0 +1,289 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// likeEscaper stops % and _ typed by a user from acting as wildcards in a like clause
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+const breederColumns = `b.id, b.breeder_name, b.address, b.city, b.prov_state, b.country,
+				b.zip, b.phone, b.email, b.active`
+
+// AllBreeders returns one page of breeders matching the filter, along with the total number of matches
+func (m *mysqlRepository) AllBreeders(f *BreederFilter) ([]*Breeder, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if f == nil {
+		f = &BreederFilter{}
+	}
+
+	where, args := f.where()
+
+	var total int
+	countQuery := fmt.Sprintf(`select count(b.id) from breeders b %s`, where)
+	if err := m.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		log.Println("Error counting breeders:", err)
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`select %s from breeders b %s order by b.breeder_name limit ? offset ?`, breederColumns, where)
+	args = append(args, f.Limit(), f.Offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var breeders []*Breeder
+	byID := make(map[int]*Breeder)
+	var ids []any
+
+	for rows.Next() {
+		var b Breeder
+		err := rows.Scan(
+			&b.ID,
+			&b.BreederName,
+			&b.Address,
+			&b.City,
+			&b.ProvState,
+			&b.Country,
+			&b.Zip,
+			&b.Phone,
+			&b.Email,
+			&b.Active,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		breeders = append(breeders, &b)
+		byID[b.ID] = &b
+		ids = append(ids, b.ID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if len(ids) == 0 {
+		return breeders, total, nil
+	}
+
+	// Load the breeds for the whole page with one query per species instead of two per breeder
+	if err := m.loadBreederBreeds(ctx, byID, ids); err != nil {
+		return nil, 0, err
+	}
+
+	return breeders, total, nil
+}
+
+// SearchBreeders is AllBreeders with a search term matched against the breeder's name and city
+func (m *mysqlRepository) SearchBreeders(term string, f *BreederFilter) ([]*Breeder, int, error) {
+	if f == nil {
+		f = &BreederFilter{}
+	}
+	f.Search = term
+
+	return m.AllBreeders(f)
+}
+
+func (m *mysqlRepository) GetBreederByID(id int) (*Breeder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`select %s from breeders b where b.id = ?`, breederColumns)
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	var b Breeder
+	err := row.Scan(
+		&b.ID,
+		&b.BreederName,
+		&b.Address,
+		&b.City,
+		&b.ProvState,
+		&b.Country,
+		&b.Zip,
+		&b.Phone,
+		&b.Email,
+		&b.Active,
+	)
+
+	if err != nil {
+		log.Println("Error getting breeder by id:", err)
+		return nil, err
+	}
+
+	if err := m.loadBreederBreeds(ctx, map[int]*Breeder{b.ID: &b}, []any{b.ID}); err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// DogBreedsForBreeder returns the distinct breeds of the dogs a breeder has
+func (m *mysqlRepository) DogBreedsForBreeder(id int) ([]*DogBreed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	b := &Breeder{ID: id}
+	if err := m.loadBreederDogBreeds(ctx, map[int]*Breeder{id: b}, []any{id}); err != nil {
+		return nil, err
+	}
+
+	return b.DogBreeds, nil
+}
+
+// CatBreedsForBreeder returns the distinct breeds of the cats a breeder has
+func (m *mysqlRepository) CatBreedsForBreeder(id int) ([]*CatBreed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	b := &Breeder{ID: id}
+	if err := m.loadBreederCatBreeds(ctx, map[int]*Breeder{id: b}, []any{id}); err != nil {
+		return nil, err
+	}
+
+	return b.CatBreeds, nil
+}
+
+// loadBreederBreeds fills in DogBreeds and CatBreeds for every breeder in byID, using the dogs and cats each one has
+func (m *mysqlRepository) loadBreederBreeds(ctx context.Context, byID map[int]*Breeder, ids []any) error {
+	if err := m.loadBreederDogBreeds(ctx, byID, ids); err != nil {
+		return err
+	}
+
+	return m.loadBreederCatBreeds(ctx, byID, ids)
+}
+
+func (m *mysqlRepository) loadBreederDogBreeds(ctx context.Context, byID map[int]*Breeder, ids []any) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	dogQuery := fmt.Sprintf(`select distinct d.breeder_id, db.id, db.breed, db.weight_low_lbs, db.weight_high_lbs,
+				cast(((db.weight_low_lbs + db.weight_high_lbs) / 2) as unsigned) as average_weight,
+				db.lifespan, coalesce(db.details, ''),
+				coalesce(db.alternate_names, ''), coalesce(db.geographic_origin, '')
+				from dogs d
+				join dog_breeds db on db.id = d.breed_id
+				where d.breeder_id in (%s)
+				order by db.breed`, placeholders)
+
+	rows, err := m.DB.QueryContext(ctx, dogQuery, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var breederID int
+		var b DogBreed
+		err := rows.Scan(
+			&breederID,
+			&b.ID,
+			&b.Breed,
+			&b.WeightLowLbs,
+			&b.WeightHighLbs,
+			&b.AverageWeight,
+			&b.Lifespan,
+			&b.Details,
+			&b.AlternateNames,
+			&b.GeographicOrigin,
+		)
+		if err != nil {
+			return err
+		}
+		if breeder, ok := byID[breederID]; ok {
+			breeder.DogBreeds = append(breeder.DogBreeds, &b)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (m *mysqlRepository) loadBreederCatBreeds(ctx context.Context, byID map[int]*Breeder, ids []any) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	catQuery := fmt.Sprintf(`select distinct c.breeder_id, cb.id, cb.breed, cb.weight_low_lbs, cb.weight_high_lbs,
+				cast(((cb.weight_low_lbs + cb.weight_high_lbs) / 2) as unsigned) as average_weight,
+				cb.lifespan, coalesce(cb.details, ''),
+				coalesce(cb.alternate_names, ''), coalesce(cb.geographic_origin, '')
+				from cats c
+				join cat_breeds cb on cb.id = c.breed_id
+				where c.breeder_id in (%s)
+				order by cb.breed`, placeholders)
+
+	rows, err := m.DB.QueryContext(ctx, catQuery, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var breederID int
+		var c CatBreed
+		err := rows.Scan(
+			&breederID,
+			&c.ID,
+			&c.Breed,
+			&c.WeightLowLbs,
+			&c.WeightHighLbs,
+			&c.AverageWeight,
+			&c.Lifespan,
+			&c.Details,
+			&c.AlternateNames,
+			&c.GeographicOrigin,
+		)
+		if err != nil {
+			return err
+		}
+		if breeder, ok := byID[breederID]; ok {
+			breeder.CatBreeds = append(breeder.CatBreeds, &c)
+		}
+	}
+
+	return rows.Err()
+}
+
+// where builds the where clause (and its arguments) for a breeder filter
+func (f *BreederFilter) where() (string, []any) {
+	var clauses []string
+	var args []any
+
+	if f.ActiveOnly {
+		clauses = append(clauses, "b.active = 1")
+	}
+
+	if f.Country != "" {
+		clauses = append(clauses, "b.country = ?")
+		args = append(args, f.Country)
+	}
+
+	if f.ProvState != "" {
+		clauses = append(clauses, "b.prov_state = ?")
+		args = append(args, f.ProvState)
+	}
+
+	if f.Search != "" {
+		clauses = append(clauses, "(b.breeder_name like ? or b.city like ?)")
+		term := "%" + likeEscaper.Replace(f.Search) + "%"
+		args = append(args, term, term)
+	}
+
+	switch f.Species {
+	case "dog":
+		clauses = append(clauses, "exists (select 1 from dogs d where d.breeder_id = b.id)")
+	case "cat":
+		clauses = append(clauses, "exists (select 1 from cats c where c.breeder_id = b.id)")
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+
+	return "where " + strings.Join(clauses, " and "), args
+}
@@ -0,0 +1,25 @@
+package models
+
+func (m *testRepository) AllBreeders(f *BreederFilter) ([]*Breeder, int, error) {
+	breeders := []*Breeder{
+		{ID: 1, BreederName: "Test Breeder", City: "Test City", ProvState: "ON", Country: "Canada", Active: 1},
+	}
+
+	return breeders, len(breeders), nil
+}
+
+func (m *testRepository) SearchBreeders(term string, f *BreederFilter) ([]*Breeder, int, error) {
+	return m.AllBreeders(f)
+}
+
+func (m *testRepository) GetBreederByID(id int) (*Breeder, error) {
+	return &Breeder{ID: id, BreederName: "Test Breeder", Active: 1}, nil
+}
+
+func (m *testRepository) DogBreedsForBreeder(id int) ([]*DogBreed, error) {
+	return nil, nil
+}
+
+func (m *testRepository) CatBreedsForBreeder(id int) ([]*CatBreed, error) {
+	return nil, nil
+}
@@ -0,0 +1,291 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+func (m *mysqlRepository) AllCatBreeds() ([]*CatBreed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, breed, weight_low_lbs, weight_high_lbs,
+				cast(((weight_low_lbs + weight_high_lbs) / 2) as unsigned) as average_weight,
+				lifespan, coalesce(details, ''),
+				coalesce(alternate_names, ''), coalesce(geographic_origin, '')
+				from cat_breeds order by breed`
+
+	var breeds []*CatBreed
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c CatBreed
+		err := rows.Scan(
+			&c.ID,
+			&c.Breed,
+			&c.WeightLowLbs,
+			&c.WeightHighLbs,
+			&c.AverageWeight,
+			&c.Lifespan,
+			&c.Details,
+			&c.AlternateNames,
+			&c.GeographicOrigin,
+		)
+		if err != nil {
+			return nil, err
+		}
+		breeds = append(breeds, &c)
+	}
+
+	return breeds, nil
+}
+
+func (m *mysqlRepository) GetCatBreedByName(b string) (*CatBreed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, breed, weight_low_lbs, weight_high_lbs,
+				cast(((weight_low_lbs + weight_high_lbs) / 2) as unsigned) as average_weight,
+				lifespan, coalesce(details, ''),
+				coalesce(alternate_names, ''), coalesce(geographic_origin, '')
+				from cat_breeds where breed = ?`
+
+	row := m.DB.QueryRowContext(ctx, query, b)
+	var catBreed CatBreed
+	err := row.Scan(
+		&catBreed.ID,
+		&catBreed.Breed,
+		&catBreed.WeightLowLbs,
+		&catBreed.WeightHighLbs,
+		&catBreed.AverageWeight,
+		&catBreed.Lifespan,
+		&catBreed.Details,
+		&catBreed.AlternateNames,
+		&catBreed.GeographicOrigin,
+	)
+
+	if err != nil {
+		log.Println("Error getting cat breed by name:", err)
+		return nil, err
+	}
+
+	return &catBreed, nil
+}
+
+func (m *mysqlRepository) GetCatBreedByID(id int) (*CatBreed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, breed, weight_low_lbs, weight_high_lbs,
+				cast(((weight_low_lbs + weight_high_lbs) / 2) as unsigned) as average_weight,
+				lifespan, coalesce(details, ''),
+				coalesce(alternate_names, ''), coalesce(geographic_origin, '')
+				from cat_breeds where id = ?`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	var catBreed CatBreed
+	err := row.Scan(
+		&catBreed.ID,
+		&catBreed.Breed,
+		&catBreed.WeightLowLbs,
+		&catBreed.WeightHighLbs,
+		&catBreed.AverageWeight,
+		&catBreed.Lifespan,
+		&catBreed.Details,
+		&catBreed.AlternateNames,
+		&catBreed.GeographicOrigin,
+	)
+
+	if err != nil {
+		log.Println("Error getting cat breed by id:", err)
+		return nil, err
+	}
+
+	return &catBreed, nil
+}
+
+// catColumns selects a cat along with its breed and breeder, see dogColumns
+const catColumns = `c.id, c.cat_name, coalesce(c.breed_id, 0), coalesce(c.breeder_id, 0), c.color,
+				c.date_of_birth, c.spayed_neutered, c.description, c.weight,
+				coalesce(cb.id, 0), coalesce(cb.breed, ''), coalesce(cb.weight_low_lbs, 0), coalesce(cb.weight_high_lbs, 0),
+				coalesce(cast(((cb.weight_low_lbs + cb.weight_high_lbs) / 2) as unsigned), 0),
+				coalesce(cb.lifespan, 0), coalesce(cb.details, ''),
+				coalesce(cb.alternate_names, ''), coalesce(cb.geographic_origin, ''),
+				coalesce(b.id, 0), coalesce(b.breeder_name, ''), coalesce(b.address, ''), coalesce(b.city, ''),
+				coalesce(b.prov_state, ''), coalesce(b.country, ''), coalesce(b.zip, ''), coalesce(b.phone, ''),
+				coalesce(b.email, ''), coalesce(b.active, 0)`
+
+const catJoins = `from cats c
+				left join cat_breeds cb on cb.id = c.breed_id
+				left join breeders b on b.id = c.breeder_id`
+
+func scanCat(row scanner) (*Cat, error) {
+	var c Cat
+	err := row.Scan(
+		&c.ID,
+		&c.CatName,
+		&c.BreedID,
+		&c.BreederID,
+		&c.Color,
+		&c.DateOfBirth,
+		&c.SpayedOrNeutered,
+		&c.Description,
+		&c.Weight,
+		&c.Breed.ID,
+		&c.Breed.Breed,
+		&c.Breed.WeightLowLbs,
+		&c.Breed.WeightHighLbs,
+		&c.Breed.AverageWeight,
+		&c.Breed.Lifespan,
+		&c.Breed.Details,
+		&c.Breed.AlternateNames,
+		&c.Breed.GeographicOrigin,
+		&c.Breeder.ID,
+		&c.Breeder.BreederName,
+		&c.Breeder.Address,
+		&c.Breeder.City,
+		&c.Breeder.ProvState,
+		&c.Breeder.Country,
+		&c.Breeder.Zip,
+		&c.Breeder.Phone,
+		&c.Breeder.Email,
+		&c.Breeder.Active,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (m *mysqlRepository) AllCats(f *PetFilter) ([]*Cat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if f == nil {
+		f = &PetFilter{}
+	}
+
+	where, args := f.where("c")
+	query := fmt.Sprintf(`select %s %s %s order by c.cat_name`, catColumns, catJoins, where)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cats []*Cat
+	for rows.Next() {
+		c, err := scanCat(rows)
+		if err != nil {
+			return nil, err
+		}
+		cats = append(cats, c)
+	}
+
+	return cats, rows.Err()
+}
+
+func (m *mysqlRepository) GetCatByID(id int) (*Cat, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`select %s %s where c.id = ?`, catColumns, catJoins)
+
+	c, err := scanCat(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		log.Println("Error getting cat by id:", err)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (m *mysqlRepository) InsertCat(c *Cat) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into cats (cat_name, breed_id, breeder_id, color, date_of_birth,
+				spayed_neutered, description, weight)
+				values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		c.CatName,
+		nullableID(c.BreedID),
+		nullableID(c.BreederID),
+		c.Color,
+		c.DateOfBirth,
+		c.SpayedOrNeutered,
+		c.Description,
+		c.Weight,
+	)
+	if err != nil {
+		log.Println("Error inserting cat:", err)
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *mysqlRepository) UpdateCat(c *Cat) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update cats set cat_name = ?, breed_id = ?, breeder_id = ?, color = ?,
+				date_of_birth = ?, spayed_neutered = ?, description = ?, weight = ?
+				where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.CatName,
+		nullableID(c.BreedID),
+		nullableID(c.BreederID),
+		c.Color,
+		c.DateOfBirth,
+		c.SpayedOrNeutered,
+		c.Description,
+		c.Weight,
+		c.ID,
+	)
+	if err != nil {
+		log.Println("Error updating cat:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *mysqlRepository) DeleteCat(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from cats where id = ?`, id)
+	if err != nil {
+		log.Println("Error deleting cat:", err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package models
+
+func (m *testRepository) AllCatBreeds() ([]*CatBreed, error) {
+	var breeds []*CatBreed
+
+	return breeds, nil
+}
+
+func (m *testRepository) GetCatBreedByName(b string) (*CatBreed, error) {
+	return nil, nil
+}
+
+func (m *testRepository) GetCatBreedByID(id int) (*CatBreed, error) {
+	return &CatBreed{ID: id, Breed: "Test Breed"}, nil
+}
+
+func (m *testRepository) AllCats(f *PetFilter) ([]*Cat, error) {
+	cats := []*Cat{
+		{ID: 1, CatName: "Test Cat", BreedID: 1, Color: "Grey", Weight: 10, Breed: CatBreed{ID: 1, Breed: "Test Breed"}},
+	}
+
+	return cats, nil
+}
+
+func (m *testRepository) GetCatByID(id int) (*Cat, error) {
+	return &Cat{ID: id, CatName: "Test Cat", Color: "Grey", Weight: 10}, nil
+}
+
+func (m *testRepository) InsertCat(c *Cat) (int, error) {
+	return 1, nil
+}
+
+func (m *testRepository) UpdateCat(c *Cat) error {
+	return nil
+}
+
+func (m *testRepository) DeleteCat(id int) error {
+	return nil
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"log"
 	"time"
 )
@@ -74,24 +76,460 @@ func (m *mysqlRepository) GetBreedByName(b string) (*DogBreed, error) {
 	return &dogBreed, nil
 }
 
-func (m *mysqlRepository) GetDogOfMonthByID(id int) (*DogOfMonth, error) {
+func (m *mysqlRepository) GetDogBreedByID(id int) (*DogBreed, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, video, image from dog_of_month where id = ?`
+	query := `select id, breed, weight_low_lbs, weight_high_lbs,
+				cast(((weight_low_lbs + weight_high_lbs) / 2) as unsigned) as average_weight,
+				lifespan, coalesce(details, ''),
+				coalesce(alternate_names, ''), coalesce(geographic_origin, '')
+				from dog_breeds where id = ?`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
-	var dog DogOfMonth
+	var dogBreed DogBreed
 	err := row.Scan(
-		&dog.ID,
-		&dog.Video,
-		&dog.Image,
+		&dogBreed.ID,
+		&dogBreed.Breed,
+		&dogBreed.WeightLowLbs,
+		&dogBreed.WeightHighLbs,
+		&dogBreed.AverageWeight,
+		&dogBreed.Lifespan,
+		&dogBreed.Details,
+		&dogBreed.AlternateNames,
+		&dogBreed.GeographicOrigin,
+	)
+
+	if err != nil {
+		log.Println("Error getting breed by id:", err)
+		return nil, err
+	}
+
+	return &dogBreed, nil
+}
+
+func (m *mysqlRepository) InsertDogBreed(b *DogBreed) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into dog_breeds (breed, weight_low_lbs, weight_high_lbs, lifespan,
+				details, alternate_names, geographic_origin)
+				values (?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		b.Breed,
+		b.WeightLowLbs,
+		b.WeightHighLbs,
+		b.Lifespan,
+		b.Details,
+		b.AlternateNames,
+		b.GeographicOrigin,
+	)
+	if err != nil {
+		log.Println("Error inserting breed:", err)
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *mysqlRepository) UpdateDogBreed(b *DogBreed) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update dog_breeds set breed = ?, weight_low_lbs = ?, weight_high_lbs = ?,
+				lifespan = ?, details = ?, alternate_names = ?, geographic_origin = ?
+				where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		b.Breed,
+		b.WeightLowLbs,
+		b.WeightHighLbs,
+		b.Lifespan,
+		b.Details,
+		b.AlternateNames,
+		b.GeographicOrigin,
+		b.ID,
+	)
+	if err != nil {
+		log.Println("Error updating breed:", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteDogBreed removes a breed. Dogs of that breed are kept, the foreign key sets their breed_id to null
+func (m *mysqlRepository) DeleteDogBreed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from dog_breeds where id = ?`, id)
+	if err != nil {
+		log.Println("Error deleting breed:", err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// dogColumns selects a dog along with its breed and breeder. Both joins are left joins since
+// breed_id and breeder_id are set to null when the breed or breeder is deleted
+const dogColumns = `d.id, d.dog_name, coalesce(d.breed_id, 0), coalesce(d.breeder_id, 0), d.color,
+				d.date_of_birth, d.spayed_neutered, d.description, d.weight,
+				coalesce(db.id, 0), coalesce(db.breed, ''), coalesce(db.weight_low_lbs, 0), coalesce(db.weight_high_lbs, 0),
+				coalesce(cast(((db.weight_low_lbs + db.weight_high_lbs) / 2) as unsigned), 0),
+				coalesce(db.lifespan, 0), coalesce(db.details, ''),
+				coalesce(db.alternate_names, ''), coalesce(db.geographic_origin, ''),
+				coalesce(b.id, 0), coalesce(b.breeder_name, ''), coalesce(b.address, ''), coalesce(b.city, ''),
+				coalesce(b.prov_state, ''), coalesce(b.country, ''), coalesce(b.zip, ''), coalesce(b.phone, ''),
+				coalesce(b.email, ''), coalesce(b.active, 0)`
+
+const dogJoins = `from dogs d
+				left join dog_breeds db on db.id = d.breed_id
+				left join breeders b on b.id = d.breeder_id`
+
+// dogScanDest returns the scan destinations matching dogColumns
+func dogScanDest(d *Dog) []any {
+	return []any{
+		&d.ID,
+		&d.DogName,
+		&d.BreedID,
+		&d.BreederID,
+		&d.Color,
+		&d.DateOfBirth,
+		&d.SpayedOrNeutered,
+		&d.Description,
+		&d.Weight,
+		&d.Breed.ID,
+		&d.Breed.Breed,
+		&d.Breed.WeightLowLbs,
+		&d.Breed.WeightHighLbs,
+		&d.Breed.AverageWeight,
+		&d.Breed.Lifespan,
+		&d.Breed.Details,
+		&d.Breed.AlternateNames,
+		&d.Breed.GeographicOrigin,
+		&d.Breeder.ID,
+		&d.Breeder.BreederName,
+		&d.Breeder.Address,
+		&d.Breeder.City,
+		&d.Breeder.ProvState,
+		&d.Breeder.Country,
+		&d.Breeder.Zip,
+		&d.Breeder.Phone,
+		&d.Breeder.Email,
+		&d.Breeder.Active,
+	}
+}
+
+func scanDog(row scanner) (*Dog, error) {
+	var d Dog
+	if err := row.Scan(dogScanDest(&d)...); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func (m *mysqlRepository) AllDogs(f *PetFilter) ([]*Dog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if f == nil {
+		f = &PetFilter{}
+	}
+
+	where, args := f.where("d")
+	query := fmt.Sprintf(`select %s %s %s order by d.dog_name`, dogColumns, dogJoins, where)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dogs []*Dog
+	for rows.Next() {
+		d, err := scanDog(rows)
+		if err != nil {
+			return nil, err
+		}
+		dogs = append(dogs, d)
+	}
+
+	return dogs, rows.Err()
+}
+
+func (m *mysqlRepository) GetDogByID(id int) (*Dog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`select %s %s where d.id = ?`, dogColumns, dogJoins)
+
+	d, err := scanDog(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		log.Println("Error getting dog by id:", err)
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (m *mysqlRepository) InsertDog(d *Dog) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into dogs (dog_name, breed_id, breeder_id, color, date_of_birth,
+				spayed_neutered, description, weight)
+				values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		d.DogName,
+		nullableID(d.BreedID),
+		nullableID(d.BreederID),
+		d.Color,
+		d.DateOfBirth,
+		d.SpayedOrNeutered,
+		d.Description,
+		d.Weight,
+	)
+	if err != nil {
+		log.Println("Error inserting dog:", err)
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *mysqlRepository) UpdateDog(d *Dog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update dogs set dog_name = ?, breed_id = ?, breeder_id = ?, color = ?,
+				date_of_birth = ?, spayed_neutered = ?, description = ?, weight = ?
+				where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		d.DogName,
+		nullableID(d.BreedID),
+		nullableID(d.BreederID),
+		d.Color,
+		d.DateOfBirth,
+		d.SpayedOrNeutered,
+		d.Description,
+		d.Weight,
+		d.ID,
 	)
+	if err != nil {
+		log.Println("Error updating dog:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *mysqlRepository) DeleteDog(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from dogs where id = ?`, id)
+	if err != nil {
+		log.Println("Error deleting dog:", err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// domColumns selects a dog of the month entry followed by the full dog (see dogColumns)
+const domColumns = `dom.id, dom.dog_id, dom.month, dom.year, dom.image, dom.video, ` + dogColumns
+
+const domJoins = `from dog_of_month dom
+				join dogs d on d.id = dom.dog_id
+				left join dog_breeds db on db.id = d.breed_id
+				left join breeders b on b.id = d.breeder_id`
+
+func scanDogOfMonth(row scanner) (*DogOfMonth, error) {
+	var dom DogOfMonth
+	var dog Dog
+
+	dest := append([]any{&dom.ID, &dom.DogID, &dom.Month, &dom.Year, &dom.Image, &dom.Video}, dogScanDest(&dog)...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
 
+	dom.Dog = &dog
+	return &dom, nil
+}
+
+func (m *mysqlRepository) queryDogsOfMonth(query string, args ...any) ([]*DogOfMonth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var doms []*DogOfMonth
+	for rows.Next() {
+		dom, err := scanDogOfMonth(rows)
+		if err != nil {
+			return nil, err
+		}
+		doms = append(doms, dom)
+	}
+
+	return doms, rows.Err()
+}
+
+func (m *mysqlRepository) GetDogOfMonthByID(id int) (*DogOfMonth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`select %s %s where dom.id = ?`, domColumns, domJoins)
+
+	dom, err := scanDogOfMonth(m.DB.QueryRowContext(ctx, query, id))
 	if err != nil {
 		log.Println("Error getting dom by id:", err)
 		return nil, err
 	}
 
-	return &dog, nil
+	return dom, nil
+}
+
+// GetDogOfMonthByMonth returns the entry scheduled for a month, if there is one
+func (m *mysqlRepository) GetDogOfMonthByMonth(year, month int) (*DogOfMonth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`select %s %s where dom.year = ? and dom.month = ?`, domColumns, domJoins)
+
+	dom, err := scanDogOfMonth(m.DB.QueryRowContext(ctx, query, year, month))
+	if err != nil {
+		return nil, err
+	}
+
+	return dom, nil
+}
+
+// CurrentDogOfMonth returns the entry for the given month. If nothing was scheduled for it we fall back to the most
+// recent earlier entry, so the page isn't empty just because nobody picked a dog this month
+func (m *mysqlRepository) CurrentDogOfMonth(year, month int) (*DogOfMonth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`select %s %s
+				where (dom.year < ?) or (dom.year = ? and dom.month <= ?)
+				order by dom.year desc, dom.month desc limit 1`, domColumns, domJoins)
+
+	dom, err := scanDogOfMonth(m.DB.QueryRowContext(ctx, query, year, year, month))
+	if err != nil {
+		return nil, err
+	}
+
+	return dom, nil
+}
+
+// DogOfMonthHistory returns up to limit entries on or before the given month, newest first
+func (m *mysqlRepository) DogOfMonthHistory(year, month, limit int) ([]*DogOfMonth, error) {
+	query := fmt.Sprintf(`select %s %s
+				where (dom.year < ?) or (dom.year = ? and dom.month <= ?)
+				order by dom.year desc, dom.month desc limit ?`, domColumns, domJoins)
+
+	return m.queryDogsOfMonth(query, year, year, month, limit)
+}
+
+// AllDogsOfMonth returns every entry, including the ones scheduled for future months, newest first
+func (m *mysqlRepository) AllDogsOfMonth() ([]*DogOfMonth, error) {
+	query := fmt.Sprintf(`select %s %s order by dom.year desc, dom.month desc`, domColumns, domJoins)
+
+	return m.queryDogsOfMonth(query)
+}
+
+func (m *mysqlRepository) InsertDogOfMonth(dom *DogOfMonth) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into dog_of_month (dog_id, month, year, image, video) values (?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, dom.DogID, dom.Month, dom.Year, dom.Image, dom.Video)
+	if err != nil {
+		log.Println("Error inserting dom:", err)
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *mysqlRepository) UpdateDogOfMonth(dom *DogOfMonth) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update dog_of_month set dog_id = ?, month = ?, year = ?, image = ?, video = ? where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, dom.DogID, dom.Month, dom.Year, dom.Image, dom.Video, dom.ID)
+	if err != nil {
+		log.Println("Error updating dom:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *mysqlRepository) DeleteDogOfMonth(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from dog_of_month where id = ?`, id)
+	if err != nil {
+		log.Println("Error deleting dom:", err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
 }
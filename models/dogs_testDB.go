@@ -1,5 +1,7 @@
 package models
 
+import "database/sql"
+
 func (models *testRepository) AllDogBreeds() ([]*DogBreed, error) {
 	var breeds []*DogBreed
 
@@ -11,5 +13,86 @@ func (m *testRepository) GetBreedByName(b string) (*DogBreed, error) {
 }
 
 func (m *testRepository) GetDogOfMonthByID(id int) (*DogOfMonth, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if dom, ok := m.dogsOfMonth[id]; ok {
+		saved := *dom
+		return &saved, nil
+	}
+
+	return &DogOfMonth{ID: id, DogID: 1, Month: 1, Year: 2030, Dog: &Dog{ID: 1, DogName: "Test Dog"}, Video: "/videos/7/leo.mp4", Image: "leo.jpg"}, nil
+}
+
+func (m *testRepository) GetDogOfMonthByMonth(year, month int) (*DogOfMonth, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *testRepository) CurrentDogOfMonth(year, month int) (*DogOfMonth, error) {
+	return &DogOfMonth{ID: 1, DogID: 1, Month: month, Year: year, Dog: &Dog{ID: 1, DogName: "Test Dog"}}, nil
+}
+
+func (m *testRepository) DogOfMonthHistory(year, month, limit int) ([]*DogOfMonth, error) {
 	return nil, nil
 }
+
+func (m *testRepository) AllDogsOfMonth() ([]*DogOfMonth, error) {
+	return nil, nil
+}
+
+func (m *testRepository) InsertDogOfMonth(dom *DogOfMonth) (int, error) {
+	return 1, nil
+}
+
+func (m *testRepository) UpdateDogOfMonth(dom *DogOfMonth) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := *dom
+	m.dogsOfMonth[dom.ID] = &saved
+	return nil
+}
+
+func (m *testRepository) DeleteDogOfMonth(id int) error {
+	return nil
+}
+
+func (m *testRepository) GetDogBreedByID(id int) (*DogBreed, error) {
+	return &DogBreed{ID: id, Breed: "Test Breed"}, nil
+}
+
+func (m *testRepository) InsertDogBreed(b *DogBreed) (int, error) {
+	return 1, nil
+}
+
+func (m *testRepository) UpdateDogBreed(b *DogBreed) error {
+	return nil
+}
+
+func (m *testRepository) DeleteDogBreed(id int) error {
+	return nil
+}
+
+func (m *testRepository) AllDogs(f *PetFilter) ([]*Dog, error) {
+	dogs := []*Dog{
+		{ID: 1, DogName: "Test Dog", BreedID: 1, Color: "Black", Weight: 20, Breed: DogBreed{ID: 1, Breed: "Test Breed"}},
+	}
+
+	return dogs, nil
+}
+
+func (m *testRepository) GetDogByID(id int) (*Dog, error) {
+	return &Dog{ID: id, DogName: "Test Dog", Color: "Black", Weight: 20}, nil
+}
+
+func (m *testRepository) InsertDog(d *Dog) (int, error) {
+	return 1, nil
+}
+
+func (m *testRepository) UpdateDog(d *Dog) error {
+	return nil
+}
+
+func (m *testRepository) DeleteDog(id int) error {
+	return nil
+}
@@ -8,8 +8,13 @@ import (
 var repo Repository
 
 type Models struct {
-	DogBreed DogBreed
-	Dog      Dog
+	DogBreed   DogBreed
+	CatBreed   CatBreed
+	Dog        Dog
+	Cat        Cat
+	Breeder    Breeder
+	DogOfMonth DogOfMonth
+	VideoJob   VideoJob
 }
 
 func New(conn *sql.DB) *Models {
@@ -21,6 +26,11 @@ func New(conn *sql.DB) *Models {
 
 	return &Models{
 		DogBreed: DogBreed{},
+		CatBreed: CatBreed{},
+		Dog:      Dog{},
+		Cat:      Cat{},
+		Breeder:  Breeder{},
+		VideoJob: VideoJob{},
 	}
 }
 
@@ -32,15 +42,186 @@ func (d *DogBreed) GetBreedByName(b string) (*DogBreed, error) {
 	return repo.GetBreedByName(b)
 }
 
+func (d *DogBreed) Get(id int) (*DogBreed, error) {
+	return repo.GetDogBreedByID(id)
+}
+
+// Insert saves the breed as a new row and sets its ID
+func (d *DogBreed) Insert() error {
+	id, err := repo.InsertDogBreed(d)
+	if err != nil {
+		return err
+	}
+
+	d.ID = id
+	return nil
+}
+
+func (d *DogBreed) Update() error {
+	return repo.UpdateDogBreed(d)
+}
+
+func (d *DogBreed) Delete() error {
+	return repo.DeleteDogBreed(d.ID)
+}
+
+func (c *CatBreed) All() ([]*CatBreed, error) {
+	return repo.AllCatBreeds()
+}
+
+func (c *CatBreed) GetBreedByName(b string) (*CatBreed, error) {
+	return repo.GetCatBreedByName(b)
+}
+
+func (c *CatBreed) Get(id int) (*CatBreed, error) {
+	return repo.GetCatBreedByID(id)
+}
+
+func (b *Breeder) All(f *BreederFilter) ([]*Breeder, int, error) {
+	return repo.AllBreeders(f)
+}
+
+func (b *Breeder) Get(id int) (*Breeder, error) {
+	return repo.GetBreederByID(id)
+}
+
+func (b *Breeder) Search(term string, f *BreederFilter) ([]*Breeder, int, error) {
+	return repo.SearchBreeders(term, f)
+}
+
+// LoadBreeds fills in the dog and cat breeds this breeder has
+func (b *Breeder) LoadBreeds() error {
+	dogBreeds, err := repo.DogBreedsForBreeder(b.ID)
+	if err != nil {
+		return err
+	}
+
+	catBreeds, err := repo.CatBreedsForBreeder(b.ID)
+	if err != nil {
+		return err
+	}
+
+	b.DogBreeds = dogBreeds
+	b.CatBreeds = catBreeds
+	return nil
+}
+
+func (d *Dog) All(f *PetFilter) ([]*Dog, error) {
+	return repo.AllDogs(f)
+}
+
+func (d *Dog) Get(id int) (*Dog, error) {
+	return repo.GetDogByID(id)
+}
+
+// Insert saves the dog as a new row and sets its ID
+func (d *Dog) Insert() error {
+	id, err := repo.InsertDog(d)
+	if err != nil {
+		return err
+	}
+
+	d.ID = id
+	return nil
+}
+
+func (d *Dog) Update() error {
+	return repo.UpdateDog(d)
+}
+
+func (d *Dog) Delete() error {
+	return repo.DeleteDog(d.ID)
+}
+
+func (c *Cat) All(f *PetFilter) ([]*Cat, error) {
+	return repo.AllCats(f)
+}
+
+func (c *Cat) Get(id int) (*Cat, error) {
+	return repo.GetCatByID(id)
+}
+
+// Insert saves the cat as a new row and sets its ID
+func (c *Cat) Insert() error {
+	id, err := repo.InsertCat(c)
+	if err != nil {
+		return err
+	}
+
+	c.ID = id
+	return nil
+}
+
+func (c *Cat) Update() error {
+	return repo.UpdateCat(c)
+}
+
+func (c *Cat) Delete() error {
+	return repo.DeleteCat(c.ID)
+}
+
 func (d *Dog) GetDogOfMonthByID(id int) (*DogOfMonth, error) {
 	return repo.GetDogOfMonthByID(id)
 }
 
+// DogOfMonth is the dog featured for one month of one year. Only one dog can hold a month
 type DogOfMonth struct {
-	ID    int
-	Dog   *Dog
-	Video string
-	Image string
+	ID    int    `json:"id"`
+	DogID int    `json:"dog_id"`
+	Month int    `json:"month"`
+	Year  int    `json:"year"`
+	Dog   *Dog   `json:"dog,omitempty"`
+	Video string `json:"video"`
+	Image string `json:"image"`
+}
+
+// MonthName returns the name of the month this dog was (or will be) featured, e.g. "May"
+func (dom *DogOfMonth) MonthName() string {
+	return time.Month(dom.Month).String()
+}
+
+// Current returns the dog of the month for today, or the latest one before it if this month hasn't been scheduled
+func (dom *DogOfMonth) Current() (*DogOfMonth, error) {
+	now := time.Now()
+	return repo.CurrentDogOfMonth(now.Year(), int(now.Month()))
+}
+
+// History returns up to limit past dogs of the month (including this month's), newest first
+func (dom *DogOfMonth) History(limit int) ([]*DogOfMonth, error) {
+	now := time.Now()
+	return repo.DogOfMonthHistory(now.Year(), int(now.Month()), limit)
+}
+
+// All returns every dog of the month, including the ones scheduled for the future
+func (dom *DogOfMonth) All() ([]*DogOfMonth, error) {
+	return repo.AllDogsOfMonth()
+}
+
+func (dom *DogOfMonth) Get(id int) (*DogOfMonth, error) {
+	return repo.GetDogOfMonthByID(id)
+}
+
+func (dom *DogOfMonth) GetByMonth(year, month int) (*DogOfMonth, error) {
+	return repo.GetDogOfMonthByMonth(year, month)
+}
+
+// Insert schedules the dog for its month and sets the ID
+func (dom *DogOfMonth) Insert() error {
+	id, err := repo.InsertDogOfMonth(dom)
+	if err != nil {
+		return err
+	}
+
+	dom.ID = id
+	return nil
+}
+
+func (dom *DogOfMonth) Update() error {
+	return repo.UpdateDogOfMonth(dom)
+}
+
+func (dom *DogOfMonth) Delete() error {
+	return repo.DeleteDogOfMonth(dom.ID)
 }
 
 type DogBreed struct {
@@ -110,6 +291,61 @@ type Breeder struct {
 	CatBreeds   []*CatBreed `json:"cat_breeds"`
 }
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// BreederFilter narrows down a list of breeders. Page starts at 1, and a zero Page or PageSize uses the defaults
+type BreederFilter struct {
+	Species    string // "dog" or "cat" to only list breeders that have one of those, empty for everyone
+	Country    string
+	ProvState  string
+	Search     string
+	ActiveOnly bool
+	Page       int
+	PageSize   int
+}
+
+// Limit returns the page size to use, clamped to something sensible
+func (f *BreederFilter) Limit() int {
+	return pageLimit(f.PageSize)
+}
+
+// Offset returns the number of rows to skip to get to the current page
+func (f *BreederFilter) Offset() int {
+	return pageOffset(f.Page, f.PageSize)
+}
+
+func pageLimit(pageSize int) int {
+	switch {
+	case pageSize <= 0:
+		return defaultPageSize
+	case pageSize > maxPageSize:
+		return maxPageSize
+	default:
+		return pageSize
+	}
+}
+
+func pageOffset(page, pageSize int) int {
+	if page <= 1 {
+		return 0
+	}
+
+	return (page - 1) * pageLimit(pageSize)
+}
+
+// PetFilter narrows down a list of dogs or cats. Zero values and nil pointers mean "don't filter on this"
+type PetFilter struct {
+	BreedID          int
+	BreederID        int
+	Color            string
+	MinAge           *int // in years
+	MaxAge           *int // in years
+	SpayedOrNeutered *int
+}
+
 type Pet struct {
 	Species     string `json:"species"`
 	Breed       string `json:"breed"`
@@ -118,3 +354,151 @@ type Pet struct {
 	Description string `json:"description"`
 	LifeSpan    int    `json:"lifespan"`
 }
+
+// Video job statuses. A job is queued until a worker picks it up, and ends up succeeded, failed or cancelled. A job
+// whose encode failed but will be tried again is retrying until a worker picks it up again
+const (
+	VideoJobQueued    = "queued"
+	VideoJobRunning   = "running"
+	VideoJobRetrying  = "retrying"
+	VideoJobSucceeded = "succeeded"
+	VideoJobFailed    = "failed"
+	VideoJobCancelled = "cancelled"
+)
+
+// VideoJob is one video handed to the encoding worker pool. Its ID is also the id of the streamer.Video
+type VideoJob struct {
+	ID           int              `json:"id"`
+	InputFile    string           `json:"-"`
+	OutputDir    string           `json:"-"`
+	EncodingType string           `json:"encoding_type"`
+	Status       string           `json:"status"`
+	Priority     string           `json:"priority"`
+	OutputFile   string           `json:"output_file"`
+	ErrorMessage string           `json:"error_message"`
+	Attempts     int              `json:"attempts"`
+	DogOfMonthID int              `json:"dog_of_month_id,omitempty"`
+	Thumbnails   *VideoThumbnails `json:"thumbnails,omitempty"`
+	Result       *VideoResult     `json:"result,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	StartedAt    *time.Time       `json:"started_at"`
+	FinishedAt   *time.Time       `json:"finished_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
+
+// VideoThumbnails are the images made from the video of a job. File names are relative to the job's output directory
+type VideoThumbnails struct {
+	Poster    string   `json:"poster"`
+	Images    []string `json:"images"`
+	Sprite    string   `json:"sprite,omitempty"`
+	SpriteVTT string   `json:"sprite_vtt,omitempty"`
+}
+
+// VideoResult is how the encode of a job went, once it has finished
+type VideoResult struct {
+	ErrorKind  string          `json:"error_kind,omitempty"` // invalid_input, encoder_failed, cancelled or timeout
+	Artifacts  []VideoArtifact `json:"artifacts,omitempty"`
+	OutputSize int64           `json:"output_size"`
+	Timings    VideoTimings    `json:"timings"`
+	StderrTail string          `json:"stderr_tail,omitempty"`
+}
+
+// VideoArtifact is a file the encode of a job wrote
+type VideoArtifact struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Size int64  `json:"size"`
+	URL  string `json:"url,omitempty"`
+}
+
+// VideoTimings says where the time went on the encode of a job, in milliseconds
+type VideoTimings struct {
+	Queued     int64 `json:"queued_ms"`
+	Probe      int64 `json:"probe_ms"`
+	Encode     int64 `json:"encode_ms"`
+	Thumbnails int64 `json:"thumbnails_ms"`
+	Publish    int64 `json:"publish_ms"`
+	Total      int64 `json:"total_ms"`
+}
+
+// VideoJobFilter narrows down a list of video jobs. An empty Status lists every job
+type VideoJobFilter struct {
+	Status   string
+	Page     int
+	PageSize int
+}
+
+// Limit returns the page size to use, clamped to something sensible
+func (f *VideoJobFilter) Limit() int {
+	return pageLimit(f.PageSize)
+}
+
+// Offset returns the number of rows to skip to get to the current page
+func (f *VideoJobFilter) Offset() int {
+	return pageOffset(f.Page, f.PageSize)
+}
+
+// Finished reports whether the job has stopped, one way or the other
+func (j *VideoJob) Finished() bool {
+	return j.Status == VideoJobSucceeded || j.Status == VideoJobFailed || j.Status == VideoJobCancelled
+}
+
+func (j *VideoJob) All(f *VideoJobFilter) ([]*VideoJob, int, error) {
+	return repo.AllVideoJobs(f)
+}
+
+func (j *VideoJob) Get(id int) (*VideoJob, error) {
+	return repo.GetVideoJobByID(id)
+}
+
+// Insert saves the job as queued and sets its ID. A job without a priority gets normal priority
+func (j *VideoJob) Insert() error {
+	j.Status = VideoJobQueued
+	if j.Priority == "" {
+		j.Priority = "normal"
+	}
+
+	id, err := repo.InsertVideoJob(j)
+	if err != nil {
+		return err
+	}
+
+	j.ID = id
+	return nil
+}
+
+// Update saves what the job encodes (input file, output directory and encoding type). The status only changes
+// through SetStatus
+func (j *VideoJob) Update() error {
+	return repo.UpdateVideoJob(j)
+}
+
+// SetStatus records a status change reported by the worker pool, along with the output file and any error message.
+// j.Attempts is saved with it
+func (j *VideoJob) SetStatus(status, outputFile, errorMessage string) error {
+	return repo.UpdateVideoJobStatus(j.ID, status, j.Attempts, outputFile, errorMessage)
+}
+
+// SetThumbnails records the images made from the job's video
+func (j *VideoJob) SetThumbnails(thumbnails *VideoThumbnails) error {
+	j.Thumbnails = thumbnails
+	return repo.UpdateVideoJobThumbnails(j.ID, thumbnails)
+}
+
+// SetResult records how the encode of the job went
+func (j *VideoJob) SetResult(result *VideoResult) error {
+	j.Result = result
+	return repo.UpdateVideoJobResult(j.ID, result)
+}
+
+// AttachToDogOfMonth marks the job so its video goes on a dog of the month entry once the encode succeeds. It returns
+// false if the job had already finished, in which case the caller has to attach the video itself
+func (j *VideoJob) AttachToDogOfMonth(domID int) (bool, error) {
+	return repo.AttachVideoJobToDogOfMonth(j.ID, domID)
+}
+
+// FailUnfinished marks every job that is still queued, running or retrying as failed. Jobs only live in the worker pool's
+// memory, so after a restart those will never finish
+func (j *VideoJob) FailUnfinished(errorMessage string) (int, error) {
+	return repo.FailUnfinishedVideoJobs(errorMessage)
+}
@@ -1,12 +1,54 @@
 package models
 
-import "database/sql"
+import (
+	"database/sql"
+	"strings"
+	"sync"
+)
 
 // Repository is the database repository. Anything that implements this interface must implement all the methods included here
 type Repository interface {
 	AllDogBreeds() ([]*DogBreed, error)
 	GetBreedByName(b string) (*DogBreed, error)
+	GetDogBreedByID(id int) (*DogBreed, error)
+	InsertDogBreed(b *DogBreed) (int, error)
+	UpdateDogBreed(b *DogBreed) error
+	DeleteDogBreed(id int) error
 	GetDogOfMonthByID(id int) (*DogOfMonth, error)
+	GetDogOfMonthByMonth(year, month int) (*DogOfMonth, error)
+	CurrentDogOfMonth(year, month int) (*DogOfMonth, error)
+	DogOfMonthHistory(year, month, limit int) ([]*DogOfMonth, error)
+	AllDogsOfMonth() ([]*DogOfMonth, error)
+	InsertDogOfMonth(dom *DogOfMonth) (int, error)
+	UpdateDogOfMonth(dom *DogOfMonth) error
+	DeleteDogOfMonth(id int) error
+	AllCatBreeds() ([]*CatBreed, error)
+	GetCatBreedByName(b string) (*CatBreed, error)
+	GetCatBreedByID(id int) (*CatBreed, error)
+	AllBreeders(f *BreederFilter) ([]*Breeder, int, error)
+	SearchBreeders(term string, f *BreederFilter) ([]*Breeder, int, error)
+	GetBreederByID(id int) (*Breeder, error)
+	DogBreedsForBreeder(id int) ([]*DogBreed, error)
+	CatBreedsForBreeder(id int) ([]*CatBreed, error)
+	AllDogs(f *PetFilter) ([]*Dog, error)
+	GetDogByID(id int) (*Dog, error)
+	InsertDog(d *Dog) (int, error)
+	UpdateDog(d *Dog) error
+	DeleteDog(id int) error
+	AllCats(f *PetFilter) ([]*Cat, error)
+	GetCatByID(id int) (*Cat, error)
+	InsertCat(c *Cat) (int, error)
+	UpdateCat(c *Cat) error
+	DeleteCat(id int) error
+	AllVideoJobs(f *VideoJobFilter) ([]*VideoJob, int, error)
+	GetVideoJobByID(id int) (*VideoJob, error)
+	InsertVideoJob(j *VideoJob) (int, error)
+	UpdateVideoJob(j *VideoJob) error
+	UpdateVideoJobStatus(id int, status string, attempts int, outputFile, errorMessage string) error
+	UpdateVideoJobThumbnails(id int, thumbnails *VideoThumbnails) error
+	UpdateVideoJobResult(id int, result *VideoResult) error
+	AttachVideoJobToDogOfMonth(id, domID int) (bool, error)
+	FailUnfinishedVideoJobs(errorMessage string) (int, error)
 }
 
 // mysqlRepository is a simple wrapper for the *sql.DB type. This is used to return a MySQL/MariaDB repository
@@ -23,10 +65,72 @@ func newMySqlRepository(conn *sql.DB) Repository {
 
 type testRepository struct {
 	DB *sql.DB
+
+	mu          sync.Mutex
+	dogsOfMonth map[int]*DogOfMonth // entries saved with UpdateDogOfMonth, so tests can read back what was written
 }
 
 func newTestRepository(conn *sql.DB) Repository {
 	return &testRepository{
-		DB: nil,
+		DB:          nil,
+		dogsOfMonth: make(map[int]*DogOfMonth),
+	}
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows, so one scan function can read a single row or a list
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// nullableID turns a zero foreign key into null, since breed_id and breeder_id are optional
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+
+	return id
+}
+
+// where builds the where clause (and its arguments) for a dog or cat filter, using the table alias given
+func (f *PetFilter) where(alias string) (string, []any) {
+	var clauses []string
+	var args []any
+
+	if f.BreedID != 0 {
+		clauses = append(clauses, alias+".breed_id = ?")
+		args = append(args, f.BreedID)
+	}
+
+	if f.BreederID != 0 {
+		clauses = append(clauses, alias+".breeder_id = ?")
+		args = append(args, f.BreederID)
+	}
+
+	if f.Color != "" {
+		clauses = append(clauses, alias+".color = ?")
+		args = append(args, f.Color)
+	}
+
+	// at least MinAge years old means born on or before today minus MinAge years
+	if f.MinAge != nil {
+		clauses = append(clauses, alias+".date_of_birth <= date_sub(curdate(), interval ? year)")
+		args = append(args, *f.MinAge)
+	}
+
+	// at most MaxAge years old means not yet MaxAge + 1
+	if f.MaxAge != nil {
+		clauses = append(clauses, alias+".date_of_birth > date_sub(curdate(), interval ? year)")
+		args = append(args, *f.MaxAge+1)
+	}
+
+	if f.SpayedOrNeutered != nil {
+		clauses = append(clauses, alias+".spayed_neutered = ?")
+		args = append(args, *f.SpayedOrNeutered)
 	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+
+	return "where " + strings.Join(clauses, " and "), args
 }
@@ -0,0 +1,286 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+const videoJobColumns = `j.id, j.input_file, j.output_dir, j.encoding_type, j.status, j.priority, j.output_file,
+				j.error_message, j.attempts, coalesce(j.dog_of_month_id, 0), j.thumbnails, j.result, j.created_at, j.started_at, j.finished_at, j.updated_at`
+
+func scanVideoJob(row scanner) (*VideoJob, error) {
+	var j VideoJob
+	var startedAt, finishedAt sql.NullTime
+	var thumbnails, result sql.NullString
+
+	err := row.Scan(
+		&j.ID,
+		&j.InputFile,
+		&j.OutputDir,
+		&j.EncodingType,
+		&j.Status,
+		&j.Priority,
+		&j.OutputFile,
+		&j.ErrorMessage,
+		&j.Attempts,
+		&j.DogOfMonthID,
+		&thumbnails,
+		&result,
+		&j.CreatedAt,
+		&startedAt,
+		&finishedAt,
+		&j.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+
+	if thumbnails.Valid && thumbnails.String != "" {
+		if err := json.Unmarshal([]byte(thumbnails.String), &j.Thumbnails); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Valid && result.String != "" {
+		if err := json.Unmarshal([]byte(result.String), &j.Result); err != nil {
+			return nil, err
+		}
+	}
+
+	return &j, nil
+}
+
+// AllVideoJobs returns one page of video jobs, newest first, along with the total number of matches
+func (m *mysqlRepository) AllVideoJobs(f *VideoJobFilter) ([]*VideoJob, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if f == nil {
+		f = &VideoJobFilter{}
+	}
+
+	where := ""
+	var args []any
+	if f.Status != "" {
+		where = "where j.status = ?"
+		args = append(args, f.Status)
+	}
+
+	var total int
+	countQuery := fmt.Sprintf(`select count(j.id) from video_jobs j %s`, where)
+	if err := m.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		log.Println("Error counting video jobs:", err)
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`select %s from video_jobs j %s order by j.id desc limit ? offset ?`, videoJobColumns, where)
+	args = append(args, f.Limit(), f.Offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var jobs []*VideoJob
+	for rows.Next() {
+		j, err := scanVideoJob(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return jobs, total, nil
+}
+
+func (m *mysqlRepository) GetVideoJobByID(id int) (*VideoJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`select %s from video_jobs j where j.id = ?`, videoJobColumns)
+
+	j, err := scanVideoJob(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		log.Println("Error getting video job by id:", err)
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (m *mysqlRepository) InsertVideoJob(j *VideoJob) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into video_jobs (input_file, output_dir, encoding_type, status, priority, dog_of_month_id)
+				values (?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, j.InputFile, j.OutputDir, j.EncodingType, j.Status, j.Priority, nullableID(j.DogOfMonthID))
+	if err != nil {
+		log.Println("Error inserting video job:", err)
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *mysqlRepository) UpdateVideoJob(j *VideoJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update video_jobs set input_file = ?, output_dir = ?, encoding_type = ? where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, j.InputFile, j.OutputDir, j.EncodingType, j.ID)
+	if err != nil {
+		log.Println("Error updating video job:", err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateVideoJobStatus records a status change. started_at is set when the job starts running, and finished_at when
+// it succeeds, fails or is cancelled
+func (m *mysqlRepository) UpdateVideoJobStatus(id int, status string, attempts int, outputFile, errorMessage string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update video_jobs set status = ?, attempts = ?, output_file = ?, error_message = ?,
+				started_at = if(? = ?, now(), started_at),
+				finished_at = if(? in (?, ?, ?), now(), null)
+				where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		status, attempts, outputFile, errorMessage,
+		status, VideoJobRunning,
+		status, VideoJobSucceeded, VideoJobFailed, VideoJobCancelled,
+		id,
+	)
+	if err != nil {
+		log.Println("Error updating video job status:", err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateVideoJobThumbnails stores the images made from a job's video, as JSON
+func (m *mysqlRepository) UpdateVideoJobThumbnails(id int, thumbnails *VideoThumbnails) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var value any
+	if thumbnails != nil {
+		b, err := json.Marshal(thumbnails)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+
+	_, err := m.DB.ExecContext(ctx, `update video_jobs set thumbnails = ? where id = ?`, value, id)
+	if err != nil {
+		log.Println("Error updating video job thumbnails:", err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateVideoJobResult stores how the encode of a job went, as JSON
+func (m *mysqlRepository) UpdateVideoJobResult(id int, result *VideoResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var value any
+	if result != nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+
+	_, err := m.DB.ExecContext(ctx, `update video_jobs set result = ? where id = ?`, value, id)
+	if err != nil {
+		log.Println("Error updating video job result:", err)
+		return err
+	}
+
+	return nil
+}
+
+// AttachVideoJobToDogOfMonth sets the dog of the month of a job that hasn't finished yet, and reports whether it did
+func (m *mysqlRepository) AttachVideoJobToDogOfMonth(id, domID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// the status check happens in the update itself, so a job finishing at the same time either sees the dog of the
+	// month or makes this update match nothing
+	stmt := `update video_jobs set dog_of_month_id = ? where id = ? and status in (?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, domID, id, VideoJobQueued, VideoJobRunning, VideoJobRetrying)
+	if err != nil {
+		log.Println("Error attaching video job:", err)
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if rows > 0 {
+		return true, nil
+	}
+
+	// nothing changed: either the job is done, doesn't exist, or already pointed at this entry
+	var status string
+	if err := m.DB.QueryRowContext(ctx, `select status from video_jobs where id = ?`, id).Scan(&status); err != nil {
+		return false, err
+	}
+
+	return status == VideoJobQueued || status == VideoJobRunning || status == VideoJobRetrying, nil
+}
+
+func (m *mysqlRepository) FailUnfinishedVideoJobs(errorMessage string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update video_jobs set status = ?, error_message = ?, finished_at = now() where status in (?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, VideoJobFailed, errorMessage, VideoJobQueued, VideoJobRunning, VideoJobRetrying)
+	if err != nil {
+		log.Println("Error failing unfinished video jobs:", err)
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rows), nil
+}
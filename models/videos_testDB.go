@@ -0,0 +1,53 @@
+package models
+
+import "errors"
+
+func (m *testRepository) AllVideoJobs(f *VideoJobFilter) ([]*VideoJob, int, error) {
+	jobs := []*VideoJob{
+		{ID: 1, EncodingType: "mp4", Status: VideoJobQueued, Priority: "normal"},
+	}
+
+	return jobs, len(jobs), nil
+}
+
+func (m *testRepository) GetVideoJobByID(id int) (*VideoJob, error) {
+	// a finished encode, for the playback tests
+	if id == 2 {
+		return &VideoJob{ID: id, EncodingType: "hls", Status: VideoJobSucceeded, Priority: "normal", OutputFile: "dog.m3u8", Thumbnails: &VideoThumbnails{Poster: "dog-poster.jpg"}}, nil
+	}
+
+	return &VideoJob{ID: id, EncodingType: "mp4", Status: VideoJobQueued, Priority: "normal"}, nil
+}
+
+func (m *testRepository) InsertVideoJob(j *VideoJob) (int, error) {
+	return 1, nil
+}
+
+func (m *testRepository) UpdateVideoJob(j *VideoJob) error {
+	// a dog of the month deleted while the job was being saved, for the upload clean up tests
+	if j.DogOfMonthID == 13 {
+		return errors.New("cannot add or update a child row: a foreign key constraint fails")
+	}
+
+	return nil
+}
+
+func (m *testRepository) UpdateVideoJobStatus(id int, status string, attempts int, outputFile, errorMessage string) error {
+	return nil
+}
+
+func (m *testRepository) UpdateVideoJobThumbnails(id int, thumbnails *VideoThumbnails) error {
+	return nil
+}
+
+func (m *testRepository) UpdateVideoJobResult(id int, result *VideoResult) error {
+	return nil
+}
+
+func (m *testRepository) AttachVideoJobToDogOfMonth(id, domID int) (bool, error) {
+	return true, nil
+}
+
+func (m *testRepository) FailUnfinishedVideoJobs(errorMessage string) (int, error) {
+	return 0, nil
+}
@@ -24,7 +24,17 @@ DROP TABLE IF EXISTS `breeders`;
 /*!40101 SET character_set_client = utf8 */;
 CREATE TABLE `breeders` (
   `id` int(11) unsigned NOT NULL AUTO_INCREMENT,
-  PRIMARY KEY (`id`)
+  `breeder_name` varchar(255) NOT NULL,
+  `address` varchar(255) NOT NULL DEFAULT '',
+  `city` varchar(255) NOT NULL DEFAULT '',
+  `prov_state` varchar(255) NOT NULL DEFAULT '',
+  `country` varchar(255) NOT NULL DEFAULT '',
+  `zip` varchar(20) NOT NULL DEFAULT '',
+  `phone` varchar(50) NOT NULL DEFAULT '',
+  `email` varchar(255) NOT NULL DEFAULT '',
+  `active` int(11) NOT NULL DEFAULT 1,
+  PRIMARY KEY (`id`),
+  KEY `country_prov_state` (`country`,`prov_state`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -387,6 +397,38 @@ INSERT INTO `dog_breeds` VALUES
 /*!40000 ALTER TABLE `dog_breeds` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `dog_of_month`
+--
+
+DROP TABLE IF EXISTS `dog_of_month`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `dog_of_month` (
+  `id` int(11) unsigned NOT NULL AUTO_INCREMENT,
+  `dog_id` int(11) unsigned NOT NULL,
+  `month` tinyint(2) unsigned NOT NULL,
+  `year` smallint(4) unsigned NOT NULL,
+  `image` varchar(512) NOT NULL DEFAULT '',
+  `video` varchar(512) NOT NULL DEFAULT '',
+  `created_at` timestamp NOT NULL DEFAULT current_timestamp(),
+  `updated_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `year_month` (`year`,`month`),
+  KEY `dog_id` (`dog_id`),
+  CONSTRAINT `dog_of_month_ibfk_1` FOREIGN KEY (`dog_id`) REFERENCES `dogs` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `dog_of_month`
+--
+
+LOCK TABLES `dog_of_month` WRITE;
+/*!40000 ALTER TABLE `dog_of_month` DISABLE KEYS */;
+/*!40000 ALTER TABLE `dog_of_month` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `dogs`
 --
@@ -450,6 +492,46 @@ INSERT INTO `users` VALUES
 (1,'Admin','User','admin@example.com','$2a$14$lfQ071jRtaUB6lNXorl7mOjxIlNbla9MWnQJwnZz2n2PM8ML2Velu',1,30);
 /*!40000 ALTER TABLE `users` ENABLE KEYS */;
 UNLOCK TABLES;
+
+--
+-- Table structure for table `video_jobs`
+--
+
+DROP TABLE IF EXISTS `video_jobs`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `video_jobs` (
+  `id` int(11) unsigned NOT NULL AUTO_INCREMENT,
+  `input_file` varchar(512) NOT NULL,
+  `output_dir` varchar(512) NOT NULL DEFAULT '',
+  `encoding_type` varchar(20) NOT NULL,
+  `status` varchar(20) NOT NULL DEFAULT 'queued',
+  `attempts` int(11) unsigned NOT NULL DEFAULT 0,
+  `priority` varchar(10) NOT NULL DEFAULT 'normal',
+  `output_file` varchar(512) NOT NULL DEFAULT '',
+  `error_message` text NOT NULL DEFAULT '',
+  `dog_of_month_id` int(11) unsigned DEFAULT NULL,
+  `thumbnails` text DEFAULT NULL,
+  `result` text DEFAULT NULL,
+  `created_at` timestamp NOT NULL DEFAULT current_timestamp(),
+  `started_at` timestamp NULL DEFAULT NULL,
+  `finished_at` timestamp NULL DEFAULT NULL,
+  `updated_at` timestamp NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `status` (`status`),
+  KEY `dog_of_month_id` (`dog_of_month_id`),
+  CONSTRAINT `video_jobs_ibfk_1` FOREIGN KEY (`dog_of_month_id`) REFERENCES `dog_of_month` (`id`) ON DELETE SET NULL ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `video_jobs`
+--
+
+LOCK TABLES `video_jobs` WRITE;
+/*!40000 ALTER TABLE `video_jobs` DISABLE KEYS */;
+/*!40000 ALTER TABLE `video_jobs` ENABLE KEYS */;
+UNLOCK TABLES;
 /*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
 
 /*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
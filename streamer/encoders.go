@@ -1,107 +1,180 @@
 package streamer
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
-	"strconv"
-
-	"github.com/xfrr/goffmpeg/transcoder"
+	"strings"
+	"time"
 )
 
 // Encoder is an interface for encoding video, any type that wants to satisfy this interface must implement all its methods
 type Encoder interface {
-	EncodeToMP4(v *Video, baseFileName string) error
-	EncodeToHLS(v *Video, baseFileName string) error
+	EncodeToMP4(ctx context.Context, v *Video, baseFileName string) error
+	EncodeToHLS(ctx context.Context, v *Video, baseFileName string) error
+	EncodeToDASH(ctx context.Context, v *Video, baseFileName string) error
+	EncodeToCMAF(ctx context.Context, v *Video, baseFileName string) error
+}
+
+// WithEncoder has the pool encode videos with e, rather than with ffmpeg
+func WithEncoder(e Encoder) Option {
+	return func(vd *VideoDispatcher) {
+		vd.Processor.Engine = e
+	}
 }
 
 // VideoEncoder is a type which satisfies the Encoder interface because it implements all the methods specified in Encoder
 type VideoEncoder struct{}
 
-// Takes a video object and a base file name and encodes to mp4
-func (ve *VideoEncoder) EncodeToMP4(v *Video, baseFileName string) error {
-	// Create transcoder
-	trans := new(transcoder.Transcoder)
+// Takes a video object and a base file name and encodes to mp4. ffmpeg is killed if ctx is cancelled
+func (ve *VideoEncoder) EncodeToMP4(ctx context.Context, v *Video, baseFileName string) error {
+	// the worker probes before encoding, but someone calling us directly may not have
+	media := v.Media
+	if media == nil {
+		var err error
+		if media, err = ve.Probe(ctx, v); err != nil {
+			return err
+		}
+	}
 
-	// Build the output path
-	outputPath := fmt.Sprintf("%s/%s.mp4", v.OutputDir, baseFileName)
+	return runFFmpeg(ctx, v, mp4Args(v, baseFileName, media), media.Duration)
+}
 
-	// Initialize the transcoder
-	err := trans.Initialize(v.InputFile, outputPath)
+// mp4Args builds the ffmpeg arguments that encode the probed video and audio streams of a video to a single mp4
+func mp4Args(v *Video, baseFileName string, media *MediaInfo) []string {
+	args := []string{"-i", v.InputFile, "-map", media.videoMap()}
+	if media.HasAudio {
+		args = append(args, "-map", media.audioMap())
+	}
+
+	args = append(args, "-c:v", "libx264")
+	if media.HasAudio {
+		args = append(args, "-c:a", "aac")
+	}
+
+	return append(args,
+		"-progress", "-",
+		"-nostats",
+		"-y", fmt.Sprintf("%s/%s.mp4", v.WorkDir(), baseFileName),
+	)
+}
+
+// EncodeToHLS encodes to an HLS stream with a variant for every rendition in the ladder that isn't bigger than the
+// source, with its segments encrypted if the options ask for it. ffmpeg is killed if ctx is cancelled
+func (ve *VideoEncoder) EncodeToHLS(ctx context.Context, v *Video, baseFileName string) error {
+	ladder, media, err := ve.prepareLadder(ctx, v)
 	if err != nil {
-		fmt.Println("Error encoding....")
 		return err
 	}
 
-	// Set Codec.
-	trans.MediaFile().SetVideoCodec("libx264")
+	if e := v.Options.Encryption; e != nil {
+		if err := writeHLSKey(e); err != nil {
+			return err
+		}
+		defer os.Remove(e.keyInfoFile())
+	}
 
-	// Start the transcoding process
-	done := trans.Run(false)
+	return runFFmpeg(ctx, v, hlsArgs(v, baseFileName, ladder, media), media.Duration)
+}
+
+// EncodeToDASH encodes to MPEG-DASH, with a representation for every rendition in the ladder that isn't bigger than
+// the source. ffmpeg is killed if ctx is cancelled
+func (ve *VideoEncoder) EncodeToDASH(ctx context.Context, v *Video, baseFileName string) error {
+	if v.Options.Encryption != nil {
+		return fmt.Errorf("%w: encrypted dash", ErrUnsupportedFormat)
+	}
 
-	err = <-done
+	ladder, media, err := ve.prepareLadder(ctx, v)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return runFFmpeg(ctx, v, dashArgs(v, baseFileName, ladder, media, false), media.Duration)
+}
+
+// EncodeToCMAF encodes to fMP4 segments with both a DASH manifest and HLS playlists, so one set of files serves both
+// kinds of player. ffmpeg is killed if ctx is cancelled
+func (ve *VideoEncoder) EncodeToCMAF(ctx context.Context, v *Video, baseFileName string) error {
+	if v.Options.Encryption != nil {
+		return fmt.Errorf("%w: encrypted cmaf", ErrUnsupportedFormat)
+	}
+
+	ladder, media, err := ve.prepareLadder(ctx, v)
+	if err != nil {
+		return err
+	}
+
+	return runFFmpeg(ctx, v, dashArgs(v, baseFileName, ladder, media, true), media.Duration)
 }
 
-func (vd *VideoEncoder) EncodeToHLS(v *Video, baseFileName string) error {
-	// Create a channel to get results
-	result := make(chan error)
-
-	// Spawn a goroutine to do the encode
-	go func(result chan error) {
-		ffmpegCmd := exec.Command(
-			"ffmpeg",
-			"-i", v.InputFile,
-			"-map", "0:v:0",
-			"-map", "0:a:0",
-			"-map", "0:v:0",
-			"-map", "0:a:0",
-			"-map", "0:v:0",
-			"-map", "0:a:0",
-			"-c:v", "libx264",
-			"-crf", "22",
-			"-c:a", "aac",
-			"-ar", "48000",
-			"-filter:v:0", "scale=-2:1080",
-			"-maxrate:v:0", v.Options.MaxRate1080p,
-			"-b:a:0", "128k",
-			"-filter:v:1", "scale=-2:720",
-			"-maxrate:v:1", v.Options.MaxRate720p,
-			"-b:a:1", "128k",
-			"-filter:v:2", "scale=-2:480",
-			"-maxrate:v:2", v.Options.MaxRate480p,
-			"-b:a:2", "64k",
-			"-var_stream_map", "v:0,a:0,name:1080p v:1,a:1,name:720p v:2,a:2,name:480p",
-			"-preset", "slow",
-			"-hls_list_size", "0",
-			"-threads", "0",
-			"-f", "hls",
-			"-hls_playlist_type", "event",
-			"-hls_time", strconv.Itoa(v.Options.SegmentDuration),
-			"-hls_flags", "independent_segments",
-			"-hls_segment_type", "mpegts",
-			"-hls_playlist_type", "vod",
-			"-master_pl_name", fmt.Sprintf("%s.m3u8", baseFileName),
-			"-profile:v", "baseline",
-			"-level", "3.0",
-			"-progress", "-",
-			"-nostats",
-			fmt.Sprintf("%s/%s-%%v.m3u8", v.OutputDir, baseFileName),
-		)
-
-		_, err := ffmpegCmd.CombinedOutput()
-		result <- err
-	}(result)
-
-	// Listen to the result channel
-	err := <-result
+// prepareLadder checks the rendition ladder of the video and fits it to the source
+func (ve *VideoEncoder) prepareLadder(ctx context.Context, v *Video) ([]Rendition, *MediaInfo, error) {
+	ladder := v.Options.ladder()
+	if err := ValidateLadder(ladder); err != nil {
+		return nil, nil, err
+	}
+
+	// the worker probes before encoding, but someone calling us directly may not have
+	media := v.Media
+	if media == nil {
+		var err error
+		if media, err = ve.Probe(ctx, v); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return fitLadder(ladder, media.Width, media.Height), media, nil
+}
+
+// runFFmpeg runs ffmpeg with args, which must include -progress -, and reports its progress on the video
+func runFFmpeg(ctx context.Context, v *Video, args []string, total time.Duration) error {
+	ffmpegCmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	// -progress - writes the progress blocks to stdout, anything else ffmpeg has to say goes to stderr
+	stdout, err := ffmpegCmd.StdoutPipe()
 	if err != nil {
 		return err
 	}
 
-	// Return the results (success or not)
+	var stderr bytes.Buffer
+	ffmpegCmd.Stderr = &stderr
+
+	if err := ffmpegCmd.Start(); err != nil {
+		return err
+	}
+
+	readProgress(stdout, v, total)
+
+	if err := ffmpegCmd.Wait(); err != nil {
+		return &FFmpegError{Err: err, Stderr: stderrTail(stderr.String(), stderrTailLines)}
+	}
+
+	return nil
+}
+
+// runFFmpegQuietly runs a short ffmpeg job that doesn't report progress, like grabbing a frame
+func runFFmpegQuietly(ctx context.Context, args ...string) error {
+	args = append([]string{"-v", "error", "-nostdin"}, args...)
+
+	var stderr bytes.Buffer
+	ffmpegCmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	ffmpegCmd.Stderr = &stderr
+
+	if err := ffmpegCmd.Run(); err != nil {
+		return &FFmpegError{Err: err, Stderr: stderrTail(stderr.String(), stderrTailLines)}
+	}
+
 	return nil
 }
+
+// stderrTail returns the last n lines ffmpeg wrote, which is where it explains what went wrong
+func stderrTail(stderr string, n int) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
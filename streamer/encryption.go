@@ -0,0 +1,57 @@
+package streamer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidEncryption is what an encode fails with when its encryption options are missing something. Retrying
+// won't help
+var ErrInvalidEncryption = errors.New("invalid encryption options")
+
+// EncryptionOptions turn on AES-128 encryption of HLS segments. Every encode gets a new random key and IV
+type EncryptionOptions struct {
+	KeyFile string // where the key is written. Keep it out of the output directory, players get it through KeyURI
+	KeyURI  string // where players fetch the key from, it goes in the playlists as is
+}
+
+// keyInfoFile is where the key info file ffmpeg reads is written while the video is encoded
+func (e *EncryptionOptions) keyInfoFile() string {
+	return e.KeyFile + "info"
+}
+
+// writeHLSKey makes a new key for the video and writes it, along with the key info file that tells ffmpeg where the
+// key is, what URI to put in the playlists and which IV to use. Remove the key info file once the encode is done
+func writeHLSKey(e *EncryptionOptions) error {
+	switch {
+	case e.KeyFile == "":
+		return fmt.Errorf("%w: no key file", ErrInvalidEncryption)
+	case e.KeyURI == "" || strings.ContainsAny(e.KeyURI, "\r\n"):
+		return fmt.Errorf("%w: the key URI must be a single line", ErrInvalidEncryption)
+	}
+
+	key := make([]byte, 16)
+	iv := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(e.KeyFile), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(e.KeyFile, key, 0600); err != nil {
+		return err
+	}
+
+	info := fmt.Sprintf("%s\n%s\n%s\n", e.KeyURI, e.KeyFile, hex.EncodeToString(iv))
+	return os.WriteFile(e.keyInfoFile(), []byte(info), 0600)
+}
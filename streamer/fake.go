@@ -0,0 +1,113 @@
+package streamer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FakeEncoder is an Encoder that doesn't run ffmpeg or write anything, for trying out the pool, or code that uses it,
+// without real videos. Each encode waits for its delay and then fails with its error, if it has one. Set the fields
+// before the pool is started
+type FakeEncoder struct {
+	Delay   time.Duration                     // how long every encode takes
+	Delays  map[int]time.Duration             // how long the encodes of particular videos take, by id, instead of Delay
+	Errors  map[int]error                     // what every encode of particular videos fails with, by id
+	Fail    func(v *Video, attempt int) error // what an encode fails with, nil to succeed. Overrides Errors when set
+	Started chan<- int                        // gets the id of every video as its encode starts, if set
+
+	mu         sync.Mutex
+	encodes    []FakeEncode
+	running    int
+	maxRunning int
+}
+
+// FakeEncode is an encode a FakeEncoder was asked for
+type FakeEncode struct {
+	ID           int
+	Format       string
+	BaseFileName string
+	Attempt      int
+	Video        Video // a copy of the video as it was handed to the encoder, with its options
+}
+
+func (e *FakeEncoder) EncodeToMP4(ctx context.Context, v *Video, baseFileName string) error {
+	return e.encode(ctx, v, "mp4", baseFileName)
+}
+
+func (e *FakeEncoder) EncodeToHLS(ctx context.Context, v *Video, baseFileName string) error {
+	return e.encode(ctx, v, "hls", baseFileName)
+}
+
+func (e *FakeEncoder) EncodeToDASH(ctx context.Context, v *Video, baseFileName string) error {
+	return e.encode(ctx, v, "dash", baseFileName)
+}
+
+func (e *FakeEncoder) EncodeToCMAF(ctx context.Context, v *Video, baseFileName string) error {
+	return e.encode(ctx, v, "cmaf", baseFileName)
+}
+
+// Encodes returns the encodes the encoder has started, in the order it started them
+func (e *FakeEncoder) Encodes() []FakeEncode {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]FakeEncode(nil), e.encodes...)
+}
+
+// Running returns how many encodes are going on right now
+func (e *FakeEncoder) Running() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.running
+}
+
+// MaxRunning returns the most encodes that were ever going on at once
+func (e *FakeEncoder) MaxRunning() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.maxRunning
+}
+
+// encode records the encode, waits out its delay, or until ctx is cancelled, and returns its error
+func (e *FakeEncoder) encode(ctx context.Context, v *Video, format, baseFileName string) error {
+	e.mu.Lock()
+	e.encodes = append(e.encodes, FakeEncode{ID: v.ID, Format: format, BaseFileName: baseFileName, Attempt: v.attempts, Video: *v})
+	e.running++
+	e.maxRunning = max(e.maxRunning, e.running)
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		e.running--
+		e.mu.Unlock()
+	}()
+
+	if e.Started != nil {
+		e.Started <- v.ID
+	}
+
+	delay := e.Delay
+	if d, ok := e.Delays[v.ID]; ok {
+		delay = d
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if e.Fail != nil {
+		return e.Fail(v, v.attempts)
+	}
+
+	return e.Errors[v.ID]
+}
@@ -0,0 +1,113 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// ErrUnsupportedFormat is what encoding a video fails with when its encoder can't produce the format it asked for with
+// the options it was given, like DASH with encryption
+var ErrUnsupportedFormat = errors.New("the encoder does not support this format")
+
+// Format is an output format a video can be encoded to. Its Name is what goes in Video.EncodingType
+type Format struct {
+	Name string
+
+	// OutputFile returns the name of the file players should open, given the base file name of the encode
+	OutputFile func(baseFileName string) string
+
+	// Encode encodes the video with e, writing its files to v.WorkDir()
+	Encode func(ctx context.Context, e Encoder, v *Video, baseFileName string) error
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]Format)
+)
+
+// RegisterFormat makes a format available to encode videos to. Like database/sql's Register, it panics if the format
+// is incomplete or its name is already taken, since that is a programming error
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	if f.Name == "" || f.OutputFile == nil || f.Encode == nil {
+		panic("streamer: RegisterFormat needs a name, an OutputFile and an Encode func")
+	}
+
+	if _, taken := formats[f.Name]; taken {
+		panic(fmt.Sprintf("streamer: RegisterFormat called twice for format %s", f.Name))
+	}
+
+	formats[f.Name] = f
+}
+
+// LookupFormat returns the registered format with the given name
+func LookupFormat(name string) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	f, ok := formats[name]
+	return f, ok
+}
+
+// FormatNames returns the names of every registered format, sorted
+func FormatNames() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// the formats we have encoders for out of the box
+func init() {
+	RegisterFormat(Format{
+		Name: "mp4",
+		OutputFile: func(baseFileName string) string {
+			return fmt.Sprintf("%s.mp4", baseFileName)
+		},
+		Encode: func(ctx context.Context, e Encoder, v *Video, baseFileName string) error {
+			return e.EncodeToMP4(ctx, v, baseFileName)
+		},
+	})
+
+	RegisterFormat(Format{
+		Name: "hls",
+		OutputFile: func(baseFileName string) string {
+			return fmt.Sprintf("%s.m3u8", baseFileName)
+		},
+		Encode: func(ctx context.Context, e Encoder, v *Video, baseFileName string) error {
+			return e.EncodeToHLS(ctx, v, baseFileName)
+		},
+	})
+
+	RegisterFormat(Format{
+		Name: "dash",
+		OutputFile: func(baseFileName string) string {
+			return fmt.Sprintf("%s.mpd", baseFileName)
+		},
+		Encode: func(ctx context.Context, e Encoder, v *Video, baseFileName string) error {
+			return e.EncodeToDASH(ctx, v, baseFileName)
+		},
+	})
+
+	// the HLS master playlist is the output file, the DASH manifest sits next to it with the same base name
+	RegisterFormat(Format{
+		Name: "cmaf",
+		OutputFile: func(baseFileName string) string {
+			return fmt.Sprintf("%s.m3u8", baseFileName)
+		},
+		Encode: func(ctx context.Context, e Encoder, v *Video, baseFileName string) error {
+			return e.EncodeToCMAF(ctx, v, baseFileName)
+		},
+	})
+}
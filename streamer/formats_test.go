@@ -0,0 +1,198 @@
+package streamer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLookupFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		found      bool
+		outputFile string
+	}{
+		{"mp4", true, "dog.mp4"},
+		{"hls", true, "dog.m3u8"},
+		{"dash", true, "dog.mpd"},
+		{"cmaf", true, "dog.m3u8"},
+		{"gif", false, ""},
+		{"", false, ""},
+		{"MP4", false, ""},
+	}
+
+	for _, e := range tests {
+		f, ok := LookupFormat(e.name)
+		if ok != e.found {
+			t.Errorf("%q: expected found %t, got %t", e.name, e.found, ok)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		if f.Name != e.name || f.OutputFile("dog") != e.outputFile {
+			t.Errorf("%q: wrong format %s writing %s", e.name, f.Name, f.OutputFile("dog"))
+		}
+
+		// each format goes to its own method of the encoder
+		fake := &FakeEncoder{}
+		v := &Video{ID: 1, Options: &VideoOptions{}}
+		if err := f.Encode(context.Background(), fake, v, "dog"); err != nil {
+			t.Errorf("%q: encode failed: %s", e.name, err)
+		}
+
+		if encodes := fake.Encodes(); len(encodes) != 1 || encodes[0].Format != e.name || encodes[0].BaseFileName != "dog" {
+			t.Errorf("%q: wrong encodes %+v", e.name, encodes)
+		}
+	}
+}
+
+func TestFormatNames(t *testing.T) {
+	names := FormatNames()
+
+	if !slices.IsSorted(names) {
+		t.Errorf("expected the names sorted, got %v", names)
+	}
+
+	for _, name := range []string{"cmaf", "dash", "hls", "mp4"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("expected %s in %v", name, names)
+		}
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	outputFile := func(baseFileName string) string { return baseFileName + ".webm" }
+	encode := func(ctx context.Context, e Encoder, v *Video, baseFileName string) error { return nil }
+
+	tests := []struct {
+		name   string
+		format Format
+	}{
+		{"no name", Format{OutputFile: outputFile, Encode: encode}},
+		{"no output file", Format{Name: "webm", Encode: encode}},
+		{"no encode", Format{Name: "webm", OutputFile: outputFile}},
+		{"name taken", Format{Name: "mp4", OutputFile: outputFile, Encode: encode}},
+	}
+
+	for _, e := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected RegisterFormat to panic", e.name)
+				}
+			}()
+
+			RegisterFormat(e.format)
+		}()
+	}
+
+	if _, ok := LookupFormat("webm"); ok {
+		t.Error("a format that panicked should not be registered")
+	}
+}
+
+func TestDashArgs(t *testing.T) {
+	ladder := DefaultLadder("1200k", "600k", "400k")[1:]
+	v := &Video{InputFile: "dog.mov", OutputDir: "/videos", Options: &VideoOptions{SegmentDuration: 4}}
+
+	tests := []struct {
+		name           string
+		media          *MediaInfo
+		hlsPlaylists   bool
+		maps           int
+		adaptationSets string
+		contains       []string
+		missing        []string
+	}{
+		{
+			"dash", &MediaInfo{HasAudio: true, AudioStream: 1}, false, 3, "id=0,streams=v id=1,streams=a",
+			[]string{"-map 0:0", "-map 0:1", "-b:a:0 128k"}, []string{"-hls_playlist"},
+		},
+		{
+			"silent dash", &MediaInfo{}, false, 2, "id=0,streams=v",
+			[]string{"-map 0:0"}, []string{"0:1", "-c:a", "-hls_playlist"},
+		},
+		{
+			"cover art first", &MediaInfo{HasAudio: true, VideoStream: 1, AudioStream: 2}, false, 3, "id=0,streams=v id=1,streams=a",
+			[]string{"-map 0:1", "-map 0:2"}, []string{"0:0"},
+		},
+		{
+			"cmaf", &MediaInfo{HasAudio: true, AudioStream: 1}, true, 3, "id=0,streams=v id=1,streams=a",
+			[]string{"-hls_playlist 1", "-hls_master_name dog.m3u8"}, nil,
+		},
+	}
+
+	for _, e := range tests {
+		args := dashArgs(v, "dog", ladder, e.media, e.hlsPlaylists)
+		joined := strings.Join(args, " ")
+
+		maps := 0
+		for i, arg := range args {
+			if arg == "-map" {
+				maps++
+			}
+
+			if arg == "-adaptation_sets" && args[i+1] != e.adaptationSets {
+				t.Errorf("%s: expected adaptation sets %q, got %q", e.name, e.adaptationSets, args[i+1])
+			}
+		}
+
+		if maps != e.maps {
+			t.Errorf("%s: expected %d streams mapped, got %d", e.name, e.maps, maps)
+		}
+
+		common := []string{
+			"-filter:v:0 scale=-2:720", "-maxrate:v:1 400k", "-seg_duration 4",
+			"-force_key_frames expr:gte(t,n_forced*4)", "-init_seg_name dog-init-$RepresentationID$.m4s",
+		}
+		for _, want := range append(e.contains, common...) {
+			if !strings.Contains(joined, want) {
+				t.Errorf("%s: expected %q in %s", e.name, want, joined)
+			}
+		}
+
+		for _, unwanted := range e.missing {
+			if strings.Contains(joined, unwanted) {
+				t.Errorf("%s: did not expect %q in %s", e.name, unwanted, joined)
+			}
+		}
+
+		if last := args[len(args)-1]; last != fmt.Sprintf("%s/dog.mpd", v.OutputDir) {
+			t.Errorf("%s: wrong output %s", e.name, last)
+		}
+	}
+}
+
+func TestMp4Args(t *testing.T) {
+	v := &Video{InputFile: "dog.mov", OutputDir: "/videos", Options: &VideoOptions{}}
+
+	tests := []struct {
+		name     string
+		media    *MediaInfo
+		expected string
+	}{
+		{
+			"with audio", &MediaInfo{HasAudio: true, AudioStream: 1},
+			"-i dog.mov -map 0:0 -map 0:1 -c:v libx264 -c:a aac -progress - -nostats -y /videos/dog.mp4",
+		},
+		{
+			"silent", &MediaInfo{},
+			"-i dog.mov -map 0:0 -c:v libx264 -progress - -nostats -y /videos/dog.mp4",
+		},
+		{
+			"cover art first", &MediaInfo{HasAudio: true, VideoStream: 1, AudioStream: 2},
+			"-i dog.mov -map 0:1 -map 0:2 -c:v libx264 -c:a aac -progress - -nostats -y /videos/dog.mp4",
+		},
+	}
+
+	for _, e := range tests {
+		if got := strings.Join(mp4Args(v, "dog", e.media), " "); got != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package streamer
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidLadder is what an HLS encode fails with when its rendition ladder doesn't make sense. Retrying won't help
+var ErrInvalidLadder = errors.New("invalid rendition ladder")
+
+// Rendition is one variant of an HLS stream. Players switch between them depending on the viewer's bandwidth
+type Rendition struct {
+	Name         string `json:"name"`          // used in the playlist and segment file names, e.g. 720p
+	Width        int    `json:"width"`         // 0 to work it out from Height, keeping the aspect ratio
+	Height       int    `json:"height"`        // 0 to work it out from Width, keeping the aspect ratio
+	VideoBitrate string `json:"video_bitrate"` // the most the video may use, e.g. 600k
+	AudioBitrate string `json:"audio_bitrate"` // e.g. 128k
+	Profile      string `json:"profile"`       // H.264 profile: baseline, main or high
+	Level        string `json:"level"`         // H.264 level, e.g. 3.1. Empty lets ffmpeg pick one
+}
+
+// DefaultPreset is the x264 preset used when VideoOptions doesn't name one
+const DefaultPreset = "slow"
+
+// MaxRenditions is the most renditions a ladder can have. Each one is another output for ffmpeg to encode
+const MaxRenditions = 8
+
+// DefaultSegmentDuration is how long, in seconds, segments are when VideoOptions doesn't say
+const DefaultSegmentDuration = 10
+
+var (
+	renditionNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	bitrateRegex       = regexp.MustCompile(`^[0-9]+[kKmM]?$`)
+	levelRegex         = regexp.MustCompile(`^[1-6](\.[0-2])?$`)
+)
+
+var h264Profiles = map[string]bool{
+	"baseline": true,
+	"main":     true,
+	"high":     true,
+}
+
+var x264Presets = map[string]bool{
+	"ultrafast": true,
+	"superfast": true,
+	"veryfast":  true,
+	"faster":    true,
+	"fast":      true,
+	"medium":    true,
+	"slow":      true,
+	"slower":    true,
+	"veryslow":  true,
+}
+
+// DefaultLadder is the 1080p/720p/480p ladder we have always encoded, with the given video bitrates
+func DefaultLadder(maxRate1080p, maxRate720p, maxRate480p string) []Rendition {
+	return []Rendition{
+		{Name: "1080p", Height: 1080, VideoBitrate: maxRate1080p, AudioBitrate: "128k", Profile: "baseline", Level: "3.0"},
+		{Name: "720p", Height: 720, VideoBitrate: maxRate720p, AudioBitrate: "128k", Profile: "baseline", Level: "3.0"},
+		{Name: "480p", Height: 480, VideoBitrate: maxRate480p, AudioBitrate: "64k", Profile: "baseline", Level: "3.0"},
+	}
+}
+
+// ladder returns the renditions to encode: Ladder if it was set, otherwise the default ladder with the MaxRate
+// options, or our usual bitrates for the ones that aren't set
+func (o *VideoOptions) ladder() []Rendition {
+	if len(o.Ladder) > 0 {
+		return o.Ladder
+	}
+
+	return DefaultLadder(cmp.Or(o.MaxRate1080p, "1200k"), cmp.Or(o.MaxRate720p, "600k"), cmp.Or(o.MaxRate480p, "400k"))
+}
+
+// segmentDuration returns how long segments should be, in seconds
+func (o *VideoOptions) segmentDuration() int {
+	if o.SegmentDuration <= 0 {
+		return DefaultSegmentDuration
+	}
+
+	return o.SegmentDuration
+}
+
+// preset returns the x264 preset to encode with
+func (o *VideoOptions) preset() string {
+	if o.Preset == "" {
+		return DefaultPreset
+	}
+
+	return o.Preset
+}
+
+// ValidateLadder checks there are at most MaxRenditions and every rendition has a usable name, a size, bitrates ffmpeg understands and a known profile
+func ValidateLadder(ladder []Rendition) error {
+	if len(ladder) == 0 {
+		return fmt.Errorf("%w: it needs at least one rendition", ErrInvalidLadder)
+	}
+
+	if len(ladder) > MaxRenditions {
+		return fmt.Errorf("%w: it can't have more than %d renditions", ErrInvalidLadder, MaxRenditions)
+	}
+
+	names := make(map[string]bool)
+	for i, r := range ladder {
+		switch {
+		case !renditionNameRegex.MatchString(r.Name):
+			return fmt.Errorf("%w: rendition %d needs a name made of letters, numbers, - and _", ErrInvalidLadder, i+1)
+		case names[r.Name]:
+			return fmt.Errorf("%w: rendition name %s is used twice", ErrInvalidLadder, r.Name)
+		case r.Width < 0 || r.Height < 0 || (r.Width == 0 && r.Height == 0):
+			return fmt.Errorf("%w: rendition %s needs a width or a height", ErrInvalidLadder, r.Name)
+		case r.Width%2 != 0 || r.Height%2 != 0:
+			return fmt.Errorf("%w: rendition %s needs an even width and height", ErrInvalidLadder, r.Name)
+		case !ValidBitrate(r.VideoBitrate):
+			return fmt.Errorf("%w: rendition %s needs a video bitrate like 1200k", ErrInvalidLadder, r.Name)
+		case !ValidBitrate(r.AudioBitrate):
+			return fmt.Errorf("%w: rendition %s needs an audio bitrate like 128k", ErrInvalidLadder, r.Name)
+		case !h264Profiles[r.Profile]:
+			return fmt.Errorf("%w: rendition %s has profile %q, it must be baseline, main or high", ErrInvalidLadder, r.Name, r.Profile)
+		case r.Level != "" && !levelRegex.MatchString(r.Level):
+			return fmt.Errorf("%w: rendition %s has level %q, it must be like 3.1", ErrInvalidLadder, r.Name, r.Level)
+		}
+
+		names[r.Name] = true
+	}
+
+	return nil
+}
+
+// ValidBitrate reports whether s is a bitrate ffmpeg understands, like 1200k or 6M
+func ValidBitrate(s string) bool {
+	return bitrateRegex.MatchString(s)
+}
+
+// ValidatePreset checks preset is one of the x264 presets. An empty preset is the default
+func ValidatePreset(preset string) error {
+	if preset != "" && !x264Presets[preset] {
+		return fmt.Errorf("unknown x264 preset %q", preset)
+	}
+
+	return nil
+}
+
+// fitLadder drops the renditions that are bigger than the source, since upscaling only makes bigger files. If the
+// source is smaller than every rendition, the smallest one is kept at the source's size. A zero size means we don't
+// know it, and nothing is dropped for it
+func fitLadder(ladder []Rendition, width, height int) []Rendition {
+	var fitted []Rendition
+	smallest := -1
+
+	for i, r := range ladder {
+		if smallest < 0 || r.pixels(width, height) < ladder[smallest].pixels(width, height) {
+			smallest = i
+		}
+
+		if (height > 0 && r.Height > height) || (width > 0 && r.Width > width) {
+			continue
+		}
+
+		fitted = append(fitted, r)
+	}
+
+	if len(fitted) == 0 && smallest >= 0 {
+		r := ladder[smallest]
+		if height > 0 {
+			r.Width, r.Height = 0, height-height%2
+			r.Name = fmt.Sprintf("%dp", r.Height)
+		} else {
+			r.Width, r.Height = width-width%2, 0
+		}
+		fitted = append(fitted, r)
+	}
+
+	return fitted
+}
+
+// pixels is roughly how big the rendition is, filling in a missing side from the source's aspect ratio
+func (r Rendition) pixels(srcWidth, srcHeight int) int {
+	w, h := r.Width, r.Height
+	switch {
+	case w == 0 && srcHeight > 0:
+		w = h * srcWidth / srcHeight
+	case h == 0 && srcWidth > 0:
+		h = w * srcHeight / srcWidth
+	}
+
+	if w == 0 || h == 0 {
+		return max(w, h)
+	}
+
+	return w * h
+}
+
+// scaleFilter is the ffmpeg filter that resizes the video for this rendition. -2 keeps the aspect ratio while making
+// sure the side comes out even, which x264 needs
+func (r Rendition) scaleFilter() string {
+	w, h := r.Width, r.Height
+	if w == 0 {
+		w = -2
+	}
+	if h == 0 {
+		h = -2
+	}
+
+	return fmt.Sprintf("scale=%d:%d", w, h)
+}
+
+// hlsArgs builds the ffmpeg arguments that encode a video to HLS with one variant stream for each rendition. Every
+// rendition gets its own copy of the video stream, and of the audio stream if there is one, and -var_stream_map ties
+// them to a playlist
+func hlsArgs(v *Video, baseFileName string, ladder []Rendition, media *MediaInfo) []string {
+	args := []string{"-i", v.InputFile}
+
+	for range ladder {
+		args = append(args, "-map", media.videoMap())
+		if media.HasAudio {
+			args = append(args, "-map", media.audioMap())
+		}
+	}
+
+	args = append(args, "-c:v", "libx264", "-crf", "22")
+	if media.HasAudio {
+		args = append(args, "-c:a", "aac", "-ar", "48000")
+	}
+
+	args = append(args, renditionArgs(ladder)...)
+
+	streams := make([]string, 0, len(ladder))
+	for i, r := range ladder {
+		if media.HasAudio {
+			args = append(args, fmt.Sprintf("-b:a:%d", i), r.AudioBitrate)
+			streams = append(streams, fmt.Sprintf("v:%d,a:%d,name:%s", i, i, r.Name))
+		} else {
+			streams = append(streams, fmt.Sprintf("v:%d,name:%s", i, r.Name))
+		}
+	}
+
+	args = append(args,
+		"-var_stream_map", strings.Join(streams, " "),
+		"-preset", v.Options.preset(),
+		"-hls_list_size", "0",
+		"-threads", "0",
+		"-f", "hls",
+		"-hls_playlist_type", "event",
+		"-hls_time", fmt.Sprint(v.Options.segmentDuration()),
+		"-hls_flags", "independent_segments",
+		"-hls_segment_type", "mpegts",
+		"-hls_playlist_type", "vod",
+		"-master_pl_name", fmt.Sprintf("%s.m3u8", baseFileName),
+	)
+
+	if e := v.Options.Encryption; e != nil {
+		args = append(args, "-hls_key_info_file", e.keyInfoFile())
+	}
+
+	args = append(args,
+		"-progress", "-",
+		"-nostats",
+		fmt.Sprintf("%s/%s-%%v.m3u8", v.WorkDir(), baseFileName),
+	)
+
+	return args
+}
+
+// dashArgs builds the ffmpeg arguments that encode a video to MPEG-DASH in fMP4 segments, with a representation for
+// each rendition and a single audio one shared by all of them. With hlsPlaylists the same segments also get HLS
+// playlists, which is what CMAF is
+func dashArgs(v *Video, baseFileName string, ladder []Rendition, media *MediaInfo, hlsPlaylists bool) []string {
+	args := []string{"-i", v.InputFile}
+
+	for range ladder {
+		args = append(args, "-map", media.videoMap())
+	}
+
+	args = append(args, "-c:v", "libx264", "-crf", "22")
+	args = append(args, renditionArgs(ladder)...)
+
+	adaptationSets := "id=0,streams=v"
+	if media.HasAudio {
+		// the first rendition is the best one, it gets to pick the audio bitrate
+		args = append(args, "-map", media.audioMap(), "-c:a", "aac", "-ar", "48000", "-b:a:0", ladder[0].AudioBitrate)
+		adaptationSets += " id=1,streams=a"
+	}
+
+	segment := v.Options.segmentDuration()
+
+	args = append(args,
+		"-preset", v.Options.preset(),
+		"-threads", "0",
+		// every representation has to cut its segments at the same frames for players to switch between them
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segment),
+		"-f", "dash",
+		"-seg_duration", fmt.Sprint(segment),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-adaptation_sets", adaptationSets,
+		"-init_seg_name", fmt.Sprintf("%s-init-$RepresentationID$.m4s", baseFileName),
+		"-media_seg_name", fmt.Sprintf("%s-chunk-$RepresentationID$-$Number%%05d$.m4s", baseFileName),
+	)
+
+	if hlsPlaylists {
+		args = append(args, "-hls_playlist", "1", "-hls_master_name", fmt.Sprintf("%s.m3u8", baseFileName))
+	}
+
+	args = append(args,
+		"-progress", "-",
+		"-nostats",
+		fmt.Sprintf("%s/%s.mpd", v.WorkDir(), baseFileName),
+	)
+
+	return args
+}
+
+// renditionArgs sizes and rate limits each of the video streams mapped for the ladder, in order
+func renditionArgs(ladder []Rendition) []string {
+	var args []string
+	for i, r := range ladder {
+		args = append(args,
+			fmt.Sprintf("-filter:v:%d", i), r.scaleFilter(),
+			fmt.Sprintf("-maxrate:v:%d", i), r.VideoBitrate,
+			fmt.Sprintf("-profile:v:%d", i), r.Profile,
+		)
+
+		if r.Level != "" {
+			args = append(args, fmt.Sprintf("-level:v:%d", i), r.Level)
+		}
+	}
+
+	return args
+}
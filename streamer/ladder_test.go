@@ -0,0 +1,215 @@
+package streamer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateLadder(t *testing.T) {
+	rendition := Rendition{Name: "720p", Height: 720, VideoBitrate: "600k", AudioBitrate: "128k", Profile: "main", Level: "3.1"}
+
+	tooMany := make([]Rendition, MaxRenditions+1)
+	for i := range tooMany {
+		tooMany[i] = rendition
+		tooMany[i].Name = fmt.Sprintf("r%d", i)
+	}
+
+	tests := []struct {
+		name   string
+		ladder []Rendition
+		valid  bool
+	}{
+		{"one rendition", []Rendition{rendition}, true},
+		{"default ladder", DefaultLadder("1200k", "600k", "400k"), true},
+		{"empty", nil, false},
+		{"too many", tooMany, false},
+		{"as many as allowed", tooMany[:MaxRenditions], true},
+		{"name used twice", []Rendition{rendition, rendition}, false},
+		{"no size", []Rendition{{Name: "x", VideoBitrate: "600k", AudioBitrate: "128k", Profile: "main"}}, false},
+		{"odd height", []Rendition{{Name: "x", Height: 721, VideoBitrate: "600k", AudioBitrate: "128k", Profile: "main"}}, false},
+		{"bad bitrate", []Rendition{{Name: "x", Height: 720, VideoBitrate: "fast", AudioBitrate: "128k", Profile: "main"}}, false},
+		{"bad profile", []Rendition{{Name: "x", Height: 720, VideoBitrate: "600k", AudioBitrate: "128k", Profile: "extreme"}}, false},
+		{"bad level", []Rendition{{Name: "x", Height: 720, VideoBitrate: "600k", AudioBitrate: "128k", Profile: "main", Level: "9"}}, false},
+	}
+
+	for _, e := range tests {
+		err := ValidateLadder(e.ladder)
+		if e.valid != (err == nil) {
+			t.Errorf("%s: expected valid %t, got %v", e.name, e.valid, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrInvalidLadder) {
+			t.Errorf("%s: expected ErrInvalidLadder, got %v", e.name, err)
+		}
+	}
+}
+
+func TestValidBitrate(t *testing.T) {
+	tests := []struct {
+		bitrate string
+		valid   bool
+	}{
+		{"1200k", true},
+		{"6M", true},
+		{"800000", true},
+		{"", false},
+		{"1.5M", false},
+		{"fast", false},
+		{"600kb", false},
+	}
+
+	for _, e := range tests {
+		if ValidBitrate(e.bitrate) != e.valid {
+			t.Errorf("%q: expected valid %t", e.bitrate, e.valid)
+		}
+	}
+}
+
+func TestFitLadder(t *testing.T) {
+	ladder := DefaultLadder("1200k", "600k", "400k")
+
+	tests := []struct {
+		name           string
+		width, height  int
+		expectedNames  []string
+		expectedHeight int // of the last rendition
+	}{
+		{"1080p source", 1920, 1080, []string{"1080p", "720p", "480p"}, 480},
+		{"720p source", 1280, 720, []string{"720p", "480p"}, 480},
+		{"between rungs", 1024, 576, []string{"480p"}, 480},
+		{"smaller than every rung", 640, 360, []string{"360p"}, 360},
+		{"odd height", 426, 241, []string{"240p"}, 240},
+		{"unknown size", 0, 0, []string{"1080p", "720p", "480p"}, 480},
+	}
+
+	for _, e := range tests {
+		fitted := fitLadder(ladder, e.width, e.height)
+
+		var names []string
+		for _, r := range fitted {
+			names = append(names, r.Name)
+		}
+
+		if !slices.Equal(names, e.expectedNames) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expectedNames, names)
+			continue
+		}
+
+		if last := fitted[len(fitted)-1]; last.Height != e.expectedHeight || last.Width != 0 {
+			t.Errorf("%s: expected the last rendition %d high, got %dx%d", e.name, e.expectedHeight, last.Width, last.Height)
+		}
+	}
+
+	// the fallback keeps the settings of the smallest rung
+	if r := fitLadder(ladder, 640, 360)[0]; r.VideoBitrate != "400k" || r.AudioBitrate != "64k" {
+		t.Errorf("the fallback should be the 480p rung at the source size, got %+v", r)
+	}
+
+	// ladders sized by width are fitted by width
+	wide := []Rendition{{Name: "wide", Width: 1920, VideoBitrate: "1200k"}, {Name: "narrow", Width: 640, VideoBitrate: "400k"}}
+	if fitted := fitLadder(wide, 1280, 720); len(fitted) != 1 || fitted[0].Name != "narrow" {
+		t.Errorf("expected only the narrow rendition, got %+v", fitted)
+	}
+
+	if fitted := fitLadder(wide, 480, 270); len(fitted) != 1 || fitted[0].Height != 270 || fitted[0].VideoBitrate != "400k" {
+		t.Errorf("expected the narrow rendition at the source size, got %+v", fitted)
+	}
+}
+
+func TestHlsArgs(t *testing.T) {
+	ladder := DefaultLadder("1200k", "600k", "400k")[1:]
+	v := &Video{InputFile: "dog.mov", OutputDir: "/videos", Options: &VideoOptions{SegmentDuration: 6}}
+
+	tests := []struct {
+		name      string
+		media     *MediaInfo
+		maps      int
+		streamMap string
+		contains  []string
+		missing   []string
+	}{
+		{
+			"with audio", &MediaInfo{HasAudio: true, AudioStream: 1}, 4, "v:0,a:0,name:720p v:1,a:1,name:480p",
+			[]string{"-map 0:0", "-map 0:1", "-c:a aac", "-b:a:0 128k", "-b:a:1 64k"}, nil,
+		},
+		{
+			"silent", &MediaInfo{}, 2, "v:0,name:720p v:1,name:480p",
+			[]string{"-map 0:0"}, []string{"0:1", "-c:a", "-b:a:"},
+		},
+		{
+			"audio before the video", &MediaInfo{HasAudio: true, VideoStream: 1}, 4, "v:0,a:0,name:720p v:1,a:1,name:480p",
+			[]string{"-map 0:1 -map 0:0"}, nil,
+		},
+	}
+
+	for _, e := range tests {
+		args := hlsArgs(v, "dog", ladder, e.media)
+		joined := strings.Join(args, " ")
+
+		maps := 0
+		for i, arg := range args {
+			if arg == "-map" {
+				maps++
+			}
+
+			if arg == "-var_stream_map" && args[i+1] != e.streamMap {
+				t.Errorf("%s: expected stream map %q, got %q", e.name, e.streamMap, args[i+1])
+			}
+		}
+
+		if maps != e.maps {
+			t.Errorf("%s: expected %d streams mapped, got %d", e.name, e.maps, maps)
+		}
+
+		for _, want := range append(e.contains, "-filter:v:0 scale=-2:720", "-filter:v:1 scale=-2:480", "-hls_time 6", "-master_pl_name dog.m3u8") {
+			if !strings.Contains(joined, want) {
+				t.Errorf("%s: expected %q in %s", e.name, want, joined)
+			}
+		}
+
+		for _, unwanted := range e.missing {
+			if strings.Contains(joined, unwanted) {
+				t.Errorf("%s: did not expect %q in %s", e.name, unwanted, joined)
+			}
+		}
+
+		if last := args[len(args)-1]; last != "/videos/dog-%v.m3u8" {
+			t.Errorf("%s: wrong output %s", e.name, last)
+		}
+	}
+}
+
+func TestHlsArgs_coverArt(t *testing.T) {
+	// a phone video with a thumbnail stored as cover art ahead of the real video
+	media, err := parseProbe("dog.mov", []byte(`{"format":{"format_name":"mov,mp4,m4a,3gp,3g2,mj2","duration":"30"},"streams":[
+		{"index":0,"codec_type":"video","codec_name":"mjpeg","width":320,"height":320,"disposition":{"attached_pic":1}},
+		{"index":1,"codec_type":"audio","codec_name":"aac","disposition":{"attached_pic":0}},
+		{"index":2,"codec_type":"video","codec_name":"h264","width":1280,"height":720,"disposition":{"attached_pic":0}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Video{InputFile: "dog.mov", OutputDir: "/videos", Options: &VideoOptions{}}
+	args := hlsArgs(v, "dog", fitLadder(DefaultLadder("1200k", "600k", "400k"), media.Width, media.Height), media)
+
+	var maps []string
+	for i, arg := range args {
+		if arg == "-map" {
+			maps = append(maps, args[i+1])
+		}
+	}
+
+	if len(maps) == 0 || len(maps)%2 != 0 {
+		t.Fatalf("expected the video and audio mapped for every rendition, got %v", maps)
+	}
+
+	for i := 0; i < len(maps); i += 2 {
+		if maps[i] != "0:2" || maps[i+1] != "0:1" {
+			t.Errorf("expected the video and audio streams to be mapped, not the cover art, got %v", maps)
+			break
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tsawler/toolbox"
+)
+
+// ErrInvalidNaming is what an encode fails with when its naming template doesn't make sense. Retrying won't help
+var ErrInvalidNaming = errors.New("invalid output naming")
+
+// Naming templates for the usual ways of naming the files of an encode. A template is any mix of the placeholders
+// {id}, {basename}, {hash} and {random} with letters, digits, ., - and _, like {id}-{basename}
+const (
+	NamingBasename    = "{basename}" // the name of the input file without its extension, what we have always done
+	NamingID          = "{id}"       // the id of the video
+	NamingContentHash = "{hash}"     // the start of the SHA-256 of the input, the same video always gets the same name
+	NamingRandom      = "{random}"   // ten random characters, what RenameOutput asks for
+)
+
+// namingPlaceholders are what can go between braces in a naming template
+var namingPlaceholders = map[string]bool{
+	"id":       true,
+	"basename": true,
+	"hash":     true,
+	"random":   true,
+}
+
+// namingStrategies are the names the naming templates above can be asked for by
+var namingStrategies = map[string]string{
+	"basename": NamingBasename,
+	"id":       NamingID,
+	"hash":     NamingContentHash,
+	"random":   NamingRandom,
+}
+
+// OnExisting says what an encode does when its output file is already there
+type OnExisting string
+
+const (
+	OverwriteExisting OnExisting = "overwrite" // encode again over the top of it, the default
+	SkipExisting      OnExisting = "skip"      // don't encode, the output that is there is the result
+	VersionExisting   OnExisting = "version"   // encode to the first free name of name-v2, name-v3 and so on
+)
+
+// maxVersions is how far VersionExisting counts before giving up on finding a free name
+const maxVersions = 1000
+
+var (
+	namingPlaceholderRegex = regexp.MustCompile(`\{([a-z]+)\}`)
+	namingLiteralRegex     = regexp.MustCompile(`^[A-Za-z0-9._-]*$`)
+	unsafeNameRegex        = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// ParseNaming returns the naming template for s, which is the name of a strategy (basename, id, hash or random) or a
+// template of its own. Empty is the basename
+func ParseNaming(s string) (string, error) {
+	if s == "" {
+		return NamingBasename, nil
+	}
+
+	if template, ok := namingStrategies[s]; ok {
+		return template, nil
+	}
+
+	if err := validateNaming(s); err != nil {
+		return "", err
+	}
+
+	return s, nil
+}
+
+// validateNaming checks a template only uses placeholders we know, and characters that are safe in a file name
+func validateNaming(template string) error {
+	for _, m := range namingPlaceholderRegex.FindAllStringSubmatch(template, -1) {
+		if !namingPlaceholders[m[1]] {
+			return fmt.Errorf("%w: unknown placeholder {%s}", ErrInvalidNaming, m[1])
+		}
+	}
+
+	literal := namingPlaceholderRegex.ReplaceAllString(template, "")
+	if template == "" || !namingLiteralRegex.MatchString(literal) {
+		return fmt.Errorf("%w: %q must be placeholders like {id} with letters, digits, ., - and _", ErrInvalidNaming, template)
+	}
+
+	return nil
+}
+
+// ParseOnExisting returns the policy for s: overwrite, skip or version. Empty is overwrite
+func ParseOnExisting(s string) (OnExisting, error) {
+	switch p := OnExisting(s); p {
+	case "":
+		return OverwriteExisting, nil
+	case OverwriteExisting, SkipExisting, VersionExisting:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown existing output policy %q, it must be overwrite, skip or version", s)
+	}
+}
+
+// naming returns the naming template of the video
+func (o *VideoOptions) naming() string {
+	switch {
+	case o.Naming != "":
+		return o.Naming
+	case o.RenameOutput:
+		return NamingRandom
+	default:
+		return NamingBasename
+	}
+}
+
+// baseFileName is what the encoded files are called, before the extension: the naming template of the options
+// filled in for the video, then moved on to a free name or not according to OnExisting. skip is true when the output
+// is there already and the options say to leave it
+func (v *Video) baseFileName(ctx context.Context, format Format) (name string, skip bool, err error) {
+	name, err = v.expandNaming(v.Options.naming())
+	if err != nil {
+		return "", false, err
+	}
+
+	if v.Options.OnExisting == "" || v.Options.OnExisting == OverwriteExisting {
+		return name, false, nil
+	}
+
+	exists, err := v.outputExists(ctx, format.OutputFile(name))
+	if err != nil || !exists {
+		return name, false, err
+	}
+
+	if v.Options.OnExisting == SkipExisting {
+		return name, true, nil
+	}
+
+	for n := 2; n <= maxVersions; n++ {
+		versioned := fmt.Sprintf("%s-v%d", name, n)
+		exists, err := v.outputExists(ctx, format.OutputFile(versioned))
+		if err != nil || !exists {
+			return versioned, false, err
+		}
+	}
+
+	return "", false, fmt.Errorf("%w: %d versions of %s already", ErrInvalidNaming, maxVersions, name)
+}
+
+// expandNaming fills in the placeholders of a naming template for the video
+func (v *Video) expandNaming(template string) (string, error) {
+	if err := validateNaming(template); err != nil {
+		return "", err
+	}
+
+	var expandErr error
+	name := namingPlaceholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case "{id}":
+			return strconv.Itoa(v.ID)
+		case "{basename}":
+			b := path.Base(filepath.ToSlash(v.InputFile))
+			return safeName(strings.TrimSuffix(b, filepath.Ext(b))) // ex. cat.mp4 becomes cat
+		case "{hash}":
+			if err := checkInputFile(v.InputFile); err != nil {
+				expandErr = err
+				return ""
+			}
+			hash, err := fileHash(v.InputFile)
+			if err != nil {
+				expandErr = fmt.Errorf("hashing %s: %w", v.InputFile, err)
+			}
+			return hash
+		default:
+			var t toolbox.Tools
+			return t.RandomString(10)
+		}
+	})
+
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	return name, nil
+}
+
+// outputExists reports whether the encode has a file called name already, in its storage or its output directory
+func (v *Video) outputExists(ctx context.Context, name string) (bool, error) {
+	if v.Encoder.Storage != nil {
+		return v.Encoder.Storage.Exists(ctx, v.OutputDir, name)
+	}
+
+	_, err := os.Stat(filepath.Join(v.OutputDir, name))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// safeName turns what is left of an input file name into something safe in a file name and a URL
+func safeName(s string) string {
+	s = strings.Trim(unsafeNameRegex.ReplaceAllString(s, "-"), "-.")
+	if s == "" {
+		return "video"
+	}
+
+	return s
+}
+
+// fileHash returns the first 16 hex digits of the SHA-256 of a file, plenty to tell videos apart
+func fileHash(file string) (string, error) {
+	sum, _, err := fileSHA256(file)
+	if err != nil {
+		return "", err
+	}
+
+	return sum[:16], nil
+}
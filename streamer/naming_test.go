@@ -0,0 +1,132 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		naming   string
+		expected string
+		valid    bool
+	}{
+		{"default", "", NamingBasename, true},
+		{"strategy", "hash", NamingContentHash, true},
+		{"template", "{id}-{basename}", "{id}-{basename}", true},
+		{"unknown placeholder", "{id}-{breed}", "", false},
+		{"path", "../{id}", "", false},
+		{"spaces", "{id} {basename}", "", false},
+	}
+
+	for _, e := range tests {
+		naming, err := ParseNaming(e.naming)
+		if e.valid != (err == nil) {
+			t.Errorf("%s: expected valid %t, got %v", e.name, e.valid, err)
+			continue
+		}
+
+		if e.valid && naming != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, naming)
+		}
+
+		if !e.valid && !errors.Is(err, ErrInvalidNaming) {
+			t.Errorf("%s: expected ErrInvalidNaming, got %v", e.name, err)
+		}
+	}
+}
+
+func TestVideo_baseFileName(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "my dog.mov")
+	_ = os.WriteFile(input, []byte("woof"), 0644)
+
+	output := t.TempDir()
+	_ = os.WriteFile(filepath.Join(output, "7-my-dog.mp4"), []byte("mp4"), 0644)
+	_ = os.WriteFile(filepath.Join(output, "7-my-dog-v2.mp4"), []byte("mp4"), 0644)
+
+	tests := []struct {
+		name         string
+		naming       string
+		onExisting   OnExisting
+		expected     string
+		expectedSkip bool
+	}{
+		{"basename", "", "", "my-dog", false},
+		{"id", NamingID, SkipExisting, "7", false},
+		{"content hash", NamingContentHash, "", "1811bdd29f2cfe95", false},
+		{"overwrite", "{id}-{basename}", OverwriteExisting, "7-my-dog", false},
+		{"skip", "{id}-{basename}", SkipExisting, "7-my-dog", true},
+		{"version", "{id}-{basename}", VersionExisting, "7-my-dog-v3", false},
+	}
+
+	mp4, _ := LookupFormat("mp4")
+
+	for _, e := range tests {
+		v := &Video{
+			ID:        7,
+			InputFile: input,
+			OutputDir: output,
+			Options:   &VideoOptions{Naming: e.naming, OnExisting: e.onExisting},
+		}
+
+		name, skip, err := v.baseFileName(context.Background(), mp4)
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		if name != e.expected || skip != e.expectedSkip {
+			t.Errorf("%s: expected %s skip %t, got %s skip %t", e.name, e.expected, e.expectedSkip, name, skip)
+		}
+	}
+}
+
+func TestVideo_encodeSkipsExisting(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := &S3Storage{Endpoint: server.URL, Bucket: "videos", AccessKey: fake.accessKey, SecretKey: fake.secretKey}
+	encoder := &countingEncoder{}
+
+	// the second encode finds the first one's output in the bucket and leaves it be
+	for attempt := 1; attempt <= 2; attempt++ {
+		v := &Video{
+			ID:           7,
+			InputFile:    "/uploads/dog.mov",
+			OutputDir:    "7",
+			EncodingType: "mp4",
+			Options:      &VideoOptions{Naming: NamingID, OnExisting: SkipExisting},
+			Encoder:      Processor{Engine: encoder, Storage: s, ScratchDir: t.TempDir()},
+		}
+
+		outputFile, err := v.encode(context.Background())
+		if err != nil {
+			t.Fatalf("attempt %d: %s", attempt, err)
+		}
+
+		if outputFile != server.URL+"/videos/7/7.mp4" {
+			t.Errorf("attempt %d: wrong output file %s", attempt, outputFile)
+		}
+	}
+
+	if encoder.calls != 1 {
+		t.Errorf("expected one encode, got %d", encoder.calls)
+	}
+}
+
+// countingEncoder is a writingEncoder that counts the MP4 encodes it does
+type countingEncoder struct {
+	writingEncoder
+	calls int
+}
+
+func (e *countingEncoder) EncodeToMP4(ctx context.Context, v *Video, baseFileName string) error {
+	e.calls++
+	return e.writingEncoder.EncodeToMP4(ctx, v, baseFileName)
+}
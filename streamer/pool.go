@@ -1,13 +1,46 @@
 package streamer
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
 
 // Worker Pool
 type VideoDispatcher struct {
 	WorkerPool chan chan VideoProcessingJob // channel of channels, enables 2 way communication within a channel
-	maxWorkers int
-	jobQueue   chan VideoProcessingJob // Send things to our worker pool to process them
-	Processor  Processor               // Adapter allows us process the videos
+	maxWorkers int                          // how many workers the pool should have, see Resize
+	jobQueue   chan VideoProcessingJob      // Send things to our worker pool to process them
+	Processor  Processor                    // Adapter allows us process the videos
+	Progress   *ProgressBroker              // encode progress of every video, by video id
+	webhooks   *Webhooks                    // where the final messages of videos with a webhook are posted from
+
+	mu         sync.Mutex
+	cancels    map[int]context.CancelFunc // every job that has been submitted and hasn't finished, by video id
+	lanes      [laneCount][]queuedJob     // jobs waiting for a worker, by priority, oldest first in each lane
+	turns      [laneCount]int             // how many more jobs each lane may hand out in this round
+	queueLimit int                        // how many jobs can wait for a worker before TrySubmit turns new ones away
+	avgWait    time.Duration              // moving average of how long jobs waited for a worker
+	stopped    bool
+	running    bool // Run has been called
+	retire     int  // how many idle workers the dispatcher should still stop to shrink the pool
+	lastWorker int  // the id of the most recently started worker
+
+	deadLetters     []DeadLetter // videos that failed on their last attempt, oldest first
+	deadLetterLimit int          // how many dead letters are kept
+
+	wake chan struct{} // pokes the dispatcher when a job is added to pending
+	room chan struct{} // pokes the jobQueue reader when a job leaves pending
+
+	quit        chan struct{} // closed by Shutdown, tells the dispatcher and the workers to stop
+	stopOnce    sync.Once     // so Shutdown can be called more than once
+	abandon     chan struct{} // closed when a Shutdown deadline passes, messages nobody is reading are dropped then
+	abandonOnce sync.Once
+	workers     sync.WaitGroup // running workers
+	dispatching sync.WaitGroup // the dispatcher and the jobQueue reader
+	retrying    sync.WaitGroup // jobs waiting out a backoff before they go back on the queue
 }
 
 // type videoWorker -> this is one of the individual workers in the pool
@@ -15,32 +48,56 @@ type videoWorker struct {
 	id         int
 	jobQueue   chan VideoProcessingJob
 	workerPool chan chan VideoProcessingJob // bidirectional channel (https://tleyden.github.io/blog/2013/11/23/understanding-chan-chans-in-go/)
+	quit       chan struct{}
+	done       *sync.WaitGroup
+	dispatcher *VideoDispatcher // where failed jobs go to be retried or dead-lettered
 }
 
 // newVideoWorker
-func newVideoWorker(id int, workerPool chan chan VideoProcessingJob) videoWorker {
+func newVideoWorker(id int, vd *VideoDispatcher) videoWorker {
 	fmt.Println("newVideoWorker: Creating video worker id", id)
 	return videoWorker{
 		id:         id,
 		jobQueue:   make(chan VideoProcessingJob),
-		workerPool: workerPool,
+		workerPool: vd.WorkerPool,
+		quit:       vd.quit,
+		done:       &vd.workers,
+		dispatcher: vd,
 	}
 }
 
 // start()
-// Anytime start is called it calls an individual worker as a goroutine which executes forever
+// Anytime start is called it calls an individual worker as a goroutine which executes until the pool is shut down
 func (w videoWorker) start() {
 	fmt.Println("w.Start(): Starting worker id", w.id)
+	w.done.Add(1)
 	go func() {
+		defer w.done.Done()
+
 		for {
 			// Add jobQueue to the worker pool
-			w.workerPool <- w.jobQueue // whats going on here?
+			select {
+			case w.workerPool <- w.jobQueue: // whats going on here?
+			case <-w.quit:
+				fmt.Println("w.Start(): stopping worker id", w.id)
+				return
+			}
 
 			// Wait for a job to come back (because this go routine will block until something comes in to populate this variable "job")
-			job := <-w.jobQueue
+			select {
+			case job, ok := <-w.jobQueue:
+				if !ok {
+					// the dispatcher closes our job queue to shrink the pool
+					fmt.Println("w.Start(): retiring worker id", w.id)
+					return
+				}
 
-			// Process the job
-			w.processVideoJob(job.Video)
+				// Process the job
+				w.processVideoJob(job)
+			case <-w.quit:
+				fmt.Println("w.Start(): stopping worker id", w.id)
+				return
+			}
 		}
 	}()
 }
@@ -48,32 +105,313 @@ func (w videoWorker) start() {
 // run()
 func (vd *VideoDispatcher) Run() {
 	fmt.Println("vd.Run(): Starting worker pool by running workers")
-	for i := 0; i < vd.maxWorkers; i++ {
-		fmt.Println("vd.Run(): starting worker id", i+1)
-		worker := newVideoWorker(i+1, vd.WorkerPool)
+	vd.mu.Lock()
+	vd.running = true
+	vd.startWorkers(vd.maxWorkers)
+	vd.mu.Unlock()
+
+	vd.dispatching.Add(2)
+	go vd.readJobQueue()
+	go vd.dispatch()
+}
+
+// startWorkers adds n workers to the pool. vd.mu must be held
+func (vd *VideoDispatcher) startWorkers(n int) {
+	for i := 0; i < n; i++ {
+		vd.lastWorker++
+		fmt.Println("vd.startWorkers(): starting worker id", vd.lastWorker)
+		worker := newVideoWorker(vd.lastWorker, vd)
 		worker.start()
 	}
+}
 
-	go vd.dispatch()
+// Resize grows or shrinks the pool to n workers while it is running. New workers start right away. When shrinking,
+// idle workers stop right away and busy ones once they have finished the video they are encoding
+func (vd *VideoDispatcher) Resize(n int) error {
+	if n < 1 {
+		return ErrInvalidPoolSize
+	}
+
+	vd.mu.Lock()
+
+	if vd.stopped {
+		vd.mu.Unlock()
+		return ErrStopped
+	}
+
+	diff := n - vd.maxWorkers
+	vd.maxWorkers = n
+
+	if !vd.running {
+		// Run starts however many we end up with
+		vd.mu.Unlock()
+		return nil
+	}
+
+	if diff > 0 {
+		// workers that were about to be stopped can stay instead of starting new ones
+		kept := min(diff, vd.retire)
+		vd.retire -= kept
+		vd.startWorkers(diff - kept)
+	} else {
+		vd.retire -= diff
+	}
+
+	vd.mu.Unlock()
+
+	fmt.Println("vd.Resize(): worker pool resized to", n)
+
+	// the dispatcher may be holding an idle worker it now has to stop
+	vd.signal(vd.wake)
+	return nil
+}
+
+// Workers returns how many workers the pool has, or will have once the workers being stopped finish their videos
+func (vd *VideoDispatcher) Workers() int {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	return vd.maxWorkers
+}
+
+// retireWorker reports whether the pool has more workers than it should, and counts one off if it does
+func (vd *VideoDispatcher) retireWorker() bool {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	if vd.retire == 0 {
+		return false
+	}
+
+	vd.retire--
+	return true
+}
+
+// Shutdown stops taking jobs and waits for the videos being encoded to finish. Videos still waiting for a worker
+// are cancelled, and TrySubmit returns ErrStopped from now on. If ctx ends first the encodes that are still running
+// are killed, and ctx's error is returned once they have stopped.
+//
+// Whoever reads the notify chans should keep reading until Shutdown returns. Once ctx has ended, messages that can't
+// be sent straight away are dropped rather than waited on
+func (vd *VideoDispatcher) Shutdown(ctx context.Context) error {
+	vd.stopOnce.Do(func() {
+		fmt.Println("vd.Shutdown(): stopping worker pool")
+
+		vd.mu.Lock()
+		vd.stopped = true
+		vd.mu.Unlock()
+
+		close(vd.quit)
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		vd.dispatching.Wait()
+		vd.cancelPending()
+		vd.workers.Wait()
+		// once the workers are done nothing else can start a backoff, and the ones waiting give up on quit
+		vd.retrying.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		vd.abandonOnce.Do(func() { close(vd.abandon) })
+		vd.cancelAll()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// Stop is Shutdown without a deadline
+func (vd *VideoDispatcher) Stop() {
+	_ = vd.Shutdown(context.Background())
 }
 
 // dispatch() (dispatch a worker, assign it a worker)
+// It waits for a free worker, then hands it the job that has been waiting the longest
 func (vd *VideoDispatcher) dispatch() {
+	defer vd.dispatching.Done()
+
+	var workerJobQueue chan VideoProcessingJob
+
 	for {
-		// Wait for a job to come in
-		job := <-vd.jobQueue
+		if workerJobQueue == nil {
+			select {
+			case workerJobQueue = <-vd.WorkerPool:
+			case <-vd.quit:
+				return
+			}
+		}
+
+		if vd.retireWorker() {
+			// the pool is being shrunk, this worker stops instead of taking a job
+			close(workerJobQueue)
+			workerJobQueue = nil
+			continue
+		}
+
+		job, ok := vd.next()
+		if !ok {
+			// nothing to do, wait for TrySubmit to add something
+			select {
+			case <-vd.wake:
+				continue
+			case <-vd.quit:
+				return
+			}
+		}
 
 		fmt.Println("vd.dispatch(): sending", job.Video.ID, "to worker job queue")
 
-		go func() {
-			workerJobQueue := <-vd.WorkerPool
-			workerJobQueue <- job
-		}()
+		select {
+		case workerJobQueue <- job:
+			workerJobQueue = nil
+		case <-vd.quit:
+			// the worker stopped instead of taking it
+			job.release()
+			job.Video.sendCancelled()
+			return
+		}
+	}
+}
+
+// readJobQueue moves jobs sent on the job queue channel into the pending queue. When that is full it stops reading,
+// so senders block instead of piling up work
+func (vd *VideoDispatcher) readJobQueue() {
+	defer vd.dispatching.Done()
+
+	for {
+		var job VideoProcessingJob
+		select {
+		case job = <-vd.jobQueue:
+		case <-vd.quit:
+			vd.drain()
+			return
+		}
+
+		for {
+			err := vd.TrySubmit(job)
+			if err == nil {
+				break
+			}
+
+			if errors.Is(err, ErrStopped) {
+				job.Video.sendCancelled()
+				vd.drain()
+				return
+			}
+
+			select {
+			case <-vd.room:
+			case <-vd.quit:
+				job.Video.sendCancelled()
+				vd.drain()
+				return
+			}
+		}
+	}
+}
+
+// drain cancels the jobs that were sent on the job queue channel but never read
+func (vd *VideoDispatcher) drain() {
+	for {
+		select {
+		case job := <-vd.jobQueue:
+			job.Video.sendCancelled()
+		default:
+			return
+		}
 	}
 }
 
-// processVideoJob
-func (w *videoWorker) processVideoJob(video Video) {
-	fmt.Println("w.processVideoJob(): staring encode on video", video.ID)
-	video.encode()
+// Cancel stops the video with the given id, killing ffmpeg if it is being encoded. It returns false if the video
+// isn't waiting or being encoded
+func (vd *VideoDispatcher) Cancel(id int) bool {
+	vd.mu.Lock()
+
+	cancel, ok := vd.cancels[id]
+	if !ok {
+		vd.mu.Unlock()
+		return false
+	}
+
+	// a job that is still waiting for a worker can be taken out of the queue and reported right away
+	if q, ok := vd.removePending(id); ok {
+		vd.mu.Unlock()
+
+		q.job.release()
+		q.job.Video.sendCancelled()
+		vd.signal(vd.room)
+		return true
+	}
+
+	vd.mu.Unlock()
+
+	cancel()
+	return true
+}
+
+// cancelAll cancels every job that has been dispatched and hasn't finished
+func (vd *VideoDispatcher) cancelAll() {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	for _, cancel := range vd.cancels {
+		cancel()
+	}
+}
+
+// processVideoJob encodes a video and reports how it went. A failed encode is retried after a backoff if the video's
+// retry policy allows it, otherwise the video goes on the dead-letter list
+func (w *videoWorker) processVideoJob(job VideoProcessingJob) {
+	// it may have been cancelled just as this worker picked it up
+	if job.ctx.Err() != nil {
+		job.release()
+		job.Video.sendCancelled()
+		return
+	}
+
+	job.Video.attempts++
+	video := &job.Video
+
+	ctx := job.ctx
+	cancel := func() {}
+	if video.Options.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, video.Options.Timeout)
+	}
+
+	fmt.Println("w.processVideoJob(): staring encode on video", video.ID, "attempt", video.attempts)
+	video.sendStarted()
+	started := time.Now()
+	fileName, err := video.encode(ctx)
+	video.Timings.Total = time.Since(started)
+	failure, kind := "", ErrorKind("")
+	if err != nil {
+		failure, kind = video.failureMessage(ctx, err), errorKind(ctx, err)
+	}
+	cancel()
+
+	switch {
+	case err == nil:
+		job.release()
+		fmt.Println("w.processVideoJob(): sending success message for video id", video.ID, "to notify chan")
+		video.sendSucceeded(fileName, fmt.Sprintf("video id %d processed and saved as %s", video.ID, video.savedAs(fileName)))
+	case job.ctx.Err() != nil:
+		// cancelled, rather than timed out
+		job.release()
+		video.sendCancelled()
+	case retryable(err) && video.Options.Retry.allows(video.attempts):
+		delay := video.Options.Retry.Backoff(video.attempts)
+		video.sendRetrying(kind, failure, err, delay)
+		w.dispatcher.retryLater(job, delay)
+	default:
+		job.release()
+		// the final message goes first, so a video requeued from the dead-letter list can't have it land after the
+		// messages of its new attempts
+		video.sendFailed(kind, failure, err)
+		w.dispatcher.deadLetter(*video, failure)
+	}
 }
@@ -0,0 +1,414 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestPool returns a pool of n workers that encodes with e, and a notify chan big enough that workers never wait
+// on it
+func newTestPool(n int, e Encoder) (*VideoDispatcher, chan ProcessingMessage) {
+	vd := New(make(chan VideoProcessingJob), n, WithEncoder(e), WithQueueLimit(0))
+	return vd, make(chan ProcessingMessage, 1000)
+}
+
+// submit queues a video for encoding to mp4
+func submit(t *testing.T, vd *VideoDispatcher, notify chan ProcessingMessage, id int, priority Priority, options *VideoOptions) {
+	t.Helper()
+
+	v := vd.NewVideo(id, fmt.Sprintf("/uploads/%d.mov", id), "/videos", "mp4", notify, options)
+	v.Priority = priority
+	if err := vd.TrySubmit(VideoProcessingJob{Video: v}); err != nil {
+		t.Fatalf("submitting %d: %s", id, err)
+	}
+}
+
+// results waits for the final message of n videos, by id, and returns every message each of them got
+func results(t *testing.T, notify chan ProcessingMessage, n int) map[int][]ProcessingMessage {
+	t.Helper()
+
+	messages := make(map[int][]ProcessingMessage)
+	for done := 0; done < n; {
+		select {
+		case msg := <-notify:
+			messages[msg.ID] = append(messages[msg.ID], msg)
+			if msg.Done() {
+				done++
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d videos finished", done, n)
+		}
+	}
+
+	return messages
+}
+
+// statuses returns the status of each message, in order
+func statuses(messages []ProcessingMessage) string {
+	var s []string
+	for _, msg := range messages {
+		s = append(s, msg.Status)
+	}
+
+	return fmt.Sprint(s)
+}
+
+func TestNew_WithEncoder(t *testing.T) {
+	if _, ok := New(nil, 1).Processor.Engine.(*VideoEncoder); !ok {
+		t.Error("the pool should encode with ffmpeg by default")
+	}
+
+	fake := &FakeEncoder{}
+	vd := New(nil, 1, WithEncoder(fake))
+	if v := vd.NewVideo(1, "dog.mov", "/videos", "mp4", nil, nil); v.Encoder.Engine != fake {
+		t.Error("videos should be encoded with the encoder the pool was given")
+	}
+}
+
+func TestVideoDispatcher_dispatchOrder(t *testing.T) {
+	fake := &FakeEncoder{}
+	vd, notify := newTestPool(1, fake)
+
+	// everything is queued before the only worker starts, so the order is down to the queue alone
+	submit(t, vd, notify, 1, PriorityNormal, nil)
+	submit(t, vd, notify, 2, PriorityLow, nil)
+	submit(t, vd, notify, 3, PriorityNormal, nil)
+	submit(t, vd, notify, 4, PriorityHigh, nil)
+	submit(t, vd, notify, 5, PriorityHigh, nil)
+
+	vd.Run()
+	results(t, notify, 5)
+	vd.Stop()
+
+	var order []int
+	for _, e := range fake.Encodes() {
+		order = append(order, e.ID)
+	}
+
+	if fmt.Sprint(order) != "[4 5 1 3 2]" {
+		t.Errorf("wrong dispatch order %v", order)
+	}
+}
+
+func TestVideoDispatcher_concurrencyLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		videos  int
+	}{
+		{"one worker", 1, 4},
+		{"three workers", 3, 9},
+		{"more workers than videos", 5, 2},
+	}
+
+	for _, e := range tests {
+		fake := &FakeEncoder{Delay: 50 * time.Millisecond}
+		vd, notify := newTestPool(e.workers, fake)
+
+		for id := 1; id <= e.videos; id++ {
+			submit(t, vd, notify, id, PriorityNormal, nil)
+		}
+
+		vd.Run()
+		results(t, notify, e.videos)
+		vd.Stop()
+
+		if want := min(e.workers, e.videos); fake.MaxRunning() != want {
+			t.Errorf("%s: expected %d encodes at once, got %d", e.name, want, fake.MaxRunning())
+		}
+
+		if len(fake.Encodes()) != e.videos {
+			t.Errorf("%s: expected %d encodes, got %d", e.name, e.videos, len(fake.Encodes()))
+		}
+	}
+}
+
+func TestVideoDispatcher_failureNotifications(t *testing.T) {
+	retry := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	fake := &FakeEncoder{
+		Fail: func(v *Video, attempt int) error {
+			switch {
+			case v.ID == 2:
+				return &FFmpegError{Err: errors.New("exit status 1"), Stderr: "No space left on device"}
+			case v.ID == 3:
+				return fmt.Errorf("%w: no video stream", ErrInvalidInput)
+			case v.ID == 4 && attempt == 1:
+				// it works the second time
+				return errors.New("ffmpeg crashed")
+			default:
+				return nil
+			}
+		},
+	}
+
+	vd, notify := newTestPool(2, fake)
+	for id := 1; id <= 4; id++ {
+		submit(t, vd, notify, id, PriorityNormal, &VideoOptions{Retry: retry})
+	}
+
+	vd.Run()
+	messages := results(t, notify, 4)
+	vd.Stop()
+
+	tests := []struct {
+		id       int
+		statuses string
+		attempts int
+		kind     ErrorKind
+		stderr   string
+	}{
+		{1, "[running succeeded]", 1, "", ""},
+		{2, "[running retrying running retrying running failed]", 3, ErrorKindEncoderFailed, "No space left on device"},
+		{3, "[running failed]", 1, ErrorKindInvalidInput, ""},
+		{4, "[running retrying running succeeded]", 2, "", ""},
+	}
+
+	for _, e := range tests {
+		got := messages[e.id]
+		if statuses(got) != e.statuses {
+			t.Errorf("video %d: expected %s, got %s", e.id, e.statuses, statuses(got))
+			continue
+		}
+
+		last := got[len(got)-1]
+		if last.Attempt != e.attempts {
+			t.Errorf("video %d: expected %d attempts, got %d", e.id, e.attempts, last.Attempt)
+		}
+
+		if last.Status == StatusSucceeded && last.OutputFile != fmt.Sprintf("%d.mp4", e.id) {
+			t.Errorf("video %d: wrong output file %s", e.id, last.OutputFile)
+		}
+
+		if last.Status == StatusFailed && last.Successful {
+			t.Errorf("video %d: a failure was reported as successful", e.id)
+		}
+
+		if last.ErrorKind != e.kind || last.StderrTail != e.stderr {
+			t.Errorf("video %d: expected %q with %q, got %q with %q", e.id, e.kind, e.stderr, last.ErrorKind, last.StderrTail)
+		}
+	}
+
+	var dead []int
+	for _, dl := range vd.DeadLetters() {
+		dead = append(dead, dl.Video.ID)
+	}
+
+	if fmt.Sprint(dead) != "[3 2]" && fmt.Sprint(dead) != "[2 3]" {
+		t.Errorf("expected videos 2 and 3 to be dead-lettered, got %v", dead)
+	}
+}
+
+func TestVideoDispatcher_deadLetterAfterFinalMessage(t *testing.T) {
+	fake := &FakeEncoder{Errors: map[int]error{1: fmt.Errorf("%w: no video stream", ErrInvalidInput)}}
+	vd := New(make(chan VideoProcessingJob), 1, WithEncoder(fake), WithQueueLimit(0))
+
+	// nobody reads the final message yet, so the worker waits to send it
+	notify := make(chan ProcessingMessage)
+	submit(t, vd, notify, 1, PriorityNormal, nil)
+	vd.Run()
+	defer func() {
+		// a worker still waiting to send mustn't keep Stop from returning
+		stopped := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-notify:
+				case <-stopped:
+					return
+				}
+			}
+		}()
+		vd.Stop()
+		close(stopped)
+	}()
+
+	if msg := <-notify; msg.Status != StatusRunning {
+		t.Fatalf("expected %s, got %s", StatusRunning, msg.Status)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if err := vd.Requeue(1); !errors.Is(err, ErrNotDeadLettered) {
+		t.Fatalf("the video shouldn't be requeued before its final message is sent, got %v", err)
+	}
+
+	if msg := <-notify; msg.Status != StatusFailed {
+		t.Fatalf("expected %s, got %s", StatusFailed, msg.Status)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for vd.Requeue(1) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the video was never dead-lettered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the requeued video starts over, and none of its first attempt's messages come after it
+	for _, expected := range []string{StatusRunning, StatusFailed} {
+		select {
+		case msg := <-notify:
+			if msg.Status != expected || msg.Attempt != 1 {
+				t.Errorf("expected %s on attempt 1, got %s on attempt %d", expected, msg.Status, msg.Attempt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the requeued video never got %s", expected)
+		}
+	}
+}
+
+func TestVideoDispatcher_Shutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		timeout  time.Duration
+		err      error
+		statuses map[int]string
+	}{
+		{
+			"waits for the running encode", 50 * time.Millisecond, time.Minute, nil,
+			map[int]string{1: "[running succeeded]", 2: "[cancelled]", 3: "[cancelled]"},
+		},
+		{
+			"kills the running encode", time.Minute, 50 * time.Millisecond, context.DeadlineExceeded,
+			map[int]string{1: "[running cancelled]", 2: "[cancelled]", 3: "[cancelled]"},
+		},
+	}
+
+	for _, e := range tests {
+		started := make(chan int, 3)
+		fake := &FakeEncoder{Delay: e.delay, Started: started}
+		vd, notify := newTestPool(1, fake)
+
+		for id := 1; id <= 3; id++ {
+			submit(t, vd, notify, id, PriorityNormal, nil)
+		}
+
+		vd.Run()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+		err := vd.Shutdown(ctx)
+		cancel()
+
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.err, err)
+		}
+
+		messages := results(t, notify, 3)
+		for id, want := range e.statuses {
+			if statuses(messages[id]) != want {
+				t.Errorf("%s: video %d: expected %s, got %s", e.name, id, want, statuses(messages[id]))
+			}
+		}
+
+		if fake.Running() != 0 {
+			t.Errorf("%s: %d encodes still running after shutdown", e.name, fake.Running())
+		}
+
+		if err := vd.TrySubmit(VideoProcessingJob{Video: vd.NewVideo(4, "4.mov", "/videos", "mp4", notify, nil)}); !errors.Is(err, ErrStopped) {
+			t.Errorf("%s: expected ErrStopped after shutdown, got %v", e.name, err)
+		}
+	}
+}
+
+func TestVideoDispatcher_timeout(t *testing.T) {
+	fake := &FakeEncoder{Delay: time.Minute}
+	vd, notify := newTestPool(1, fake)
+	submit(t, vd, notify, 1, PriorityNormal, &VideoOptions{Timeout: 20 * time.Millisecond})
+
+	vd.Run()
+	messages := results(t, notify, 1)
+	vd.Stop()
+
+	last := messages[1][len(messages[1])-1]
+	if last.Status != StatusFailed || last.ErrorKind != ErrorKindTimeout {
+		t.Errorf("expected a timeout, got %s %q: %s", last.Status, last.ErrorKind, last.Message)
+	}
+
+	if last.Timings.Total < 20*time.Millisecond {
+		t.Errorf("the encode should have taken at least its timeout, took %s", last.Timings.Total)
+	}
+}
+
+func TestVideoDispatcher_oneFinalMessage(t *testing.T) {
+	const videos = 30
+
+	started := make(chan int, videos)
+	fake := &FakeEncoder{
+		Delay:   5 * time.Millisecond,
+		Started: started,
+		Fail: func(v *Video, attempt int) error {
+			if v.ID%3 == 0 {
+				return errors.New("ffmpeg crashed")
+			}
+			return nil
+		},
+	}
+
+	vd, notify := newTestPool(3, fake)
+	for id := 1; id <= videos; id++ {
+		submit(t, vd, notify, id, PriorityNormal, &VideoOptions{Retry: RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}})
+	}
+
+	vd.Run()
+
+	// cancel some while they are being encoded, some while they wait, and shut down with the rest in every state
+	for i := 0; i < 5; i++ {
+		id := <-started
+		vd.Cancel(id)
+		vd.Cancel(videos - i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_ = vd.Shutdown(ctx)
+	cancel()
+
+	final := make(map[int]int)
+	for len(notify) > 0 {
+		msg := <-notify
+		if msg.Done() {
+			final[msg.ID]++
+		}
+	}
+
+	for id := 1; id <= videos; id++ {
+		if final[id] != 1 {
+			t.Errorf("video %d got %d final messages", id, final[id])
+		}
+	}
+}
+
+func TestVideoDispatcher_ShutdownWithoutReader(t *testing.T) {
+	vd := New(make(chan VideoProcessingJob), 1, WithEncoder(&FakeEncoder{}), WithQueueLimit(0))
+
+	// nobody reads this, so the worker is stuck sending the first message it has
+	notify := make(chan ProcessingMessage)
+	for id := 1; id <= 3; id++ {
+		v := vd.NewVideo(id, fmt.Sprintf("/uploads/%d.mov", id), "/videos", "mp4", notify, nil)
+		if err := vd.TrySubmit(VideoProcessingJob{Video: v}); err != nil {
+			t.Fatalf("submitting %d: %s", id, err)
+		}
+	}
+
+	vd.Run()
+
+	stopped := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		stopped <- vd.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the deadline to pass, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown is stuck waiting for the notify chan to be read")
+	}
+}
@@ -0,0 +1,135 @@
+package streamer
+
+import (
+	"errors"
+	"time"
+)
+
+// Priority decides which lane a video waits in. Workers take from the higher lanes first, but every lane gets a turn
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityLow    Priority = "low"
+)
+
+// ErrInvalidPriority is returned by ParsePriority for anything but high, normal or low
+var ErrInvalidPriority = errors.New("priority must be high, normal or low")
+
+// the lanes, highest first
+const (
+	laneHigh = iota
+	laneNormal
+	laneLow
+	laneCount
+)
+
+// laneWeights is how many jobs each lane may hand to a worker in one round while the lanes above it have work. A low
+// priority video still gets one worker in seven when the queue is busy, so it never starves
+var laneWeights = [laneCount]int{4, 2, 1}
+
+// lanePriorities is the priority of each lane
+var lanePriorities = [laneCount]Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// ParsePriority turns a string like "high" into a Priority. An empty string is normal
+func ParsePriority(s string) (Priority, error) {
+	switch Priority(s) {
+	case "":
+		return PriorityNormal, nil
+	case PriorityHigh, PriorityNormal, PriorityLow:
+		return Priority(s), nil
+	default:
+		return "", ErrInvalidPriority
+	}
+}
+
+// lane returns which lane a video with this priority waits in. Anything we don't know waits with the normal ones
+func (p Priority) lane() int {
+	switch p {
+	case PriorityHigh:
+		return laneHigh
+	case PriorityLow:
+		return laneLow
+	default:
+		return laneNormal
+	}
+}
+
+// push adds a job to the back of its lane. vd.mu must be held
+func (vd *VideoDispatcher) push(job VideoProcessingJob) {
+	lane := job.Video.Priority.lane()
+	vd.lanes[lane] = append(vd.lanes[lane], queuedJob{job: job, queuedAt: time.Now()})
+}
+
+// pop takes the next job off the queue, using weighted round robin across the lanes: a lane with work and turns left
+// in this round goes first, highest lane first, and a new round starts once none has. vd.mu must be held
+func (vd *VideoDispatcher) pop() (queuedJob, bool) {
+	if vd.pendingLen() == 0 {
+		return queuedJob{}, false
+	}
+
+	for {
+		for lane := range vd.lanes {
+			if len(vd.lanes[lane]) > 0 && vd.turns[lane] > 0 {
+				vd.turns[lane]--
+
+				q := vd.lanes[lane][0]
+				vd.lanes[lane][0] = queuedJob{}
+				vd.lanes[lane] = vd.lanes[lane][1:]
+				return q, true
+			}
+		}
+
+		vd.turns = laneWeights
+	}
+}
+
+// removePending takes the job for a video out of whichever lane it is waiting in. vd.mu must be held
+func (vd *VideoDispatcher) removePending(id int) (queuedJob, bool) {
+	for lane := range vd.lanes {
+		for i, q := range vd.lanes[lane] {
+			if q.job.Video.ID == id {
+				vd.lanes[lane] = append(vd.lanes[lane][:i], vd.lanes[lane][i+1:]...)
+				return q, true
+			}
+		}
+	}
+
+	return queuedJob{}, false
+}
+
+// pendingLen returns how many jobs are waiting in all the lanes. vd.mu must be held
+func (vd *VideoDispatcher) pendingLen() int {
+	n := 0
+	for _, lane := range vd.lanes {
+		n += len(lane)
+	}
+
+	return n
+}
+
+// oldestQueued returns when the job that has been waiting longest, in any lane, was queued. vd.mu must be held
+func (vd *VideoDispatcher) oldestQueued() (time.Time, bool) {
+	var oldest time.Time
+	for _, lane := range vd.lanes {
+		if len(lane) > 0 && (oldest.IsZero() || lane[0].queuedAt.Before(oldest)) {
+			oldest = lane[0].queuedAt
+		}
+	}
+
+	return oldest, !oldest.IsZero()
+}
+
+// QueueDepthByPriority returns how many jobs are waiting in each lane
+func (vd *VideoDispatcher) QueueDepthByPriority() map[Priority]int {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	depths := make(map[Priority]int, laneCount)
+	for lane, p := range lanePriorities {
+		depths[p] = len(vd.lanes[lane])
+	}
+
+	return depths
+}
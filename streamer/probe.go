@@ -0,0 +1,169 @@
+package streamer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ErrInvalidInput is what encoding a video fails with when its input file isn't a video we can encode. The error
+// says what is wrong with it. Retrying won't help
+var ErrInvalidInput = errors.New("invalid input")
+
+// MediaInfo is what probing the input file told us about a video. Sizes and the duration are 0 if they couldn't be
+// worked out
+type MediaInfo struct {
+	Format      string        `json:"format"` // container, as ffprobe names it, e.g. mov,mp4,m4a,3gp,3g2,mj2
+	Duration    time.Duration `json:"duration"`
+	Width       int           `json:"width"`
+	Height      int           `json:"height"`
+	VideoCodec  string        `json:"video_codec"`
+	AudioCodec  string        `json:"audio_codec"` // empty for a silent video
+	HasAudio    bool          `json:"has_audio"`
+	VideoStream int           `json:"video_stream"` // index of the stream in the input, cover art can come before it
+	AudioStream int           `json:"audio_stream"`
+}
+
+// videoMap is what ffmpeg's -map takes to pick the video stream that was probed
+func (m *MediaInfo) videoMap() string {
+	return fmt.Sprintf("0:%d", m.VideoStream)
+}
+
+// audioMap is what ffmpeg's -map takes to pick the audio stream that was probed
+func (m *MediaInfo) audioMap() string {
+	return fmt.Sprintf("0:%d", m.AudioStream)
+}
+
+// Prober is implemented by encoders that can look at an input before encoding it. When the encoder of a video is
+// one, the video is probed before it is encoded, the result goes in Video.Media, and an input the prober rejects is
+// never handed to the encoder
+type Prober interface {
+	Probe(ctx context.Context, v *Video) (*MediaInfo, error)
+}
+
+// probe looks at the input of the video, if its encoder knows how, and records what it finds on the video
+func (v *Video) probe(ctx context.Context) error {
+	p, ok := v.Encoder.Engine.(Prober)
+	if !ok {
+		return nil
+	}
+
+	media, err := p.Probe(ctx, v)
+	if err != nil {
+		return err
+	}
+
+	v.Media = media
+	return nil
+}
+
+// Probe checks the input of the video is a file ffprobe can read with a video stream in it, and says what's in it
+func (ve *VideoEncoder) Probe(ctx context.Context, v *Video) (*MediaInfo, error) {
+	if err := checkInputFile(v.InputFile); err != nil {
+		return nil, err
+	}
+
+	return probeMedia(ctx, v.InputFile)
+}
+
+// checkInputFile makes sure the input is a file with something in it
+func checkInputFile(input string) error {
+	if input == "" {
+		return fmt.Errorf("%w: no input file", ErrInvalidInput)
+	}
+
+	info, err := os.Stat(input)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w: %s does not exist", ErrInvalidInput, input)
+	case err != nil:
+		return err
+	case info.IsDir():
+		return fmt.Errorf("%w: %s is a directory", ErrInvalidInput, input)
+	case info.Size() == 0:
+		return fmt.Errorf("%w: %s is empty", ErrInvalidInput, input)
+	}
+
+	return nil
+}
+
+// probeMedia asks ffprobe what is in a file
+func probeMedia(ctx context.Context, input string) (*MediaInfo, error) {
+	out, err := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "format=format_name,duration:stream=index,codec_type,codec_name,width,height:stream_disposition=attached_pic",
+		"-of", "json",
+		input,
+	).Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// ffprobe exits with an error for anything it can't make sense of
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("%w: %s is not a video ffprobe can read: %s", ErrInvalidInput, input, stderrTail(string(exitErr.Stderr), 1))
+		}
+
+		// ffprobe isn't installed or couldn't be started, that's on us rather than the input
+		return nil, fmt.Errorf("probing %s: %w", input, err)
+	}
+
+	return parseProbe(input, out)
+}
+
+// parseProbe reads the JSON ffprobe writes, and rejects inputs without a video stream
+func parseProbe(input string, out []byte) (*MediaInfo, error) {
+	var probed struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			Index       int    `json:"index"`
+			CodecType   string `json:"codec_type"`
+			CodecName   string `json:"codec_name"`
+			Width       int    `json:"width"`
+			Height      int    `json:"height"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probed); err != nil {
+		return nil, fmt.Errorf("reading what ffprobe said about %s: %w", input, err)
+	}
+
+	media := &MediaInfo{
+		Format:   probed.Format.FormatName,
+		Duration: secondsToDuration(probed.Format.Duration),
+	}
+
+	// only the first video and audio streams are encoded, so those are the ones that matter. Cover art shows up as a
+	// video stream too, but it's a single picture
+	for _, s := range probed.Streams {
+		switch {
+		case s.CodecType == "video" && s.Disposition.AttachedPic == 0 && media.VideoCodec == "":
+			media.VideoCodec, media.Width, media.Height, media.VideoStream = s.CodecName, s.Width, s.Height, s.Index
+		case s.CodecType == "audio" && !media.HasAudio:
+			media.AudioCodec, media.HasAudio, media.AudioStream = s.CodecName, true, s.Index
+		}
+	}
+
+	if media.VideoCodec == "" {
+		return nil, fmt.Errorf("%w: %s has no video stream", ErrInvalidInput, input)
+	}
+
+	if media.Width == 0 || media.Height == 0 {
+		return nil, fmt.Errorf("%w: the video stream in %s has no picture size", ErrInvalidInput, input)
+	}
+
+	return media, nil
+}
@@ -0,0 +1,90 @@
+package streamer
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected *MediaInfo
+		err      error
+	}{
+		{
+			"video with audio",
+			`{"format":{"format_name":"mov,mp4,m4a,3gp,3g2,mj2","duration":"12.500000"},"streams":[
+				{"index":0,"codec_type":"video","codec_name":"h264","width":1920,"height":1080,"disposition":{"attached_pic":0}},
+				{"index":1,"codec_type":"audio","codec_name":"aac","disposition":{"attached_pic":0}}]}`,
+			&MediaInfo{Format: "mov,mp4,m4a,3gp,3g2,mj2", Duration: 12500 * time.Millisecond, Width: 1920, Height: 1080, VideoCodec: "h264", AudioCodec: "aac", HasAudio: true, AudioStream: 1},
+			nil,
+		},
+		{
+			"silent video",
+			`{"format":{"format_name":"matroska,webm","duration":"3.0"},"streams":[
+				{"codec_type":"video","codec_name":"vp9","width":640,"height":360,"disposition":{"attached_pic":0}}]}`,
+			&MediaInfo{Format: "matroska,webm", Duration: 3 * time.Second, Width: 640, Height: 360, VideoCodec: "vp9"},
+			nil,
+		},
+		{
+			"cover art before the video",
+			`{"format":{"format_name":"mov,mp4,m4a,3gp,3g2,mj2","duration":"60"},"streams":[
+				{"index":0,"codec_type":"video","codec_name":"mjpeg","width":600,"height":600,"disposition":{"attached_pic":1}},
+				{"index":1,"codec_type":"video","codec_name":"hevc","width":1280,"height":720,"disposition":{"attached_pic":0}},
+				{"index":2,"codec_type":"audio","codec_name":"opus","disposition":{"attached_pic":0}}]}`,
+			&MediaInfo{Format: "mov,mp4,m4a,3gp,3g2,mj2", Duration: time.Minute, Width: 1280, Height: 720, VideoCodec: "hevc", AudioCodec: "opus", HasAudio: true, VideoStream: 1, AudioStream: 2},
+			nil,
+		},
+		{
+			"audio with cover art only",
+			`{"format":{"format_name":"mp3","duration":"180"},"streams":[
+				{"codec_type":"audio","codec_name":"mp3","disposition":{"attached_pic":0}},
+				{"codec_type":"video","codec_name":"png","width":500,"height":500,"disposition":{"attached_pic":1}}]}`,
+			nil,
+			ErrInvalidInput,
+		},
+		{
+			"no video stream",
+			`{"format":{"format_name":"wav","duration":"5"},"streams":[{"codec_type":"audio","codec_name":"pcm_s16le"}]}`,
+			nil,
+			ErrInvalidInput,
+		},
+		{
+			"no streams",
+			`{"format":{"format_name":"tty"},"streams":[]}`,
+			nil,
+			ErrInvalidInput,
+		},
+		{
+			"no picture size",
+			`{"format":{"format_name":"h264"},"streams":[{"codec_type":"video","codec_name":"h264","width":0,"height":0}]}`,
+			nil,
+			ErrInvalidInput,
+		},
+		{
+			"unknown duration",
+			`{"format":{"format_name":"h264","duration":"N/A"},"streams":[{"codec_type":"video","codec_name":"h264","width":320,"height":240}]}`,
+			&MediaInfo{Format: "h264", Width: 320, Height: 240, VideoCodec: "h264"},
+			nil,
+		},
+	}
+
+	for _, e := range tests {
+		media, err := parseProbe("dog.mov", []byte(e.json))
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected error %v, got %v", e.name, e.err, err)
+			continue
+		}
+
+		if e.expected != nil && (media == nil || *media != *e.expected) {
+			t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, media)
+		}
+	}
+
+	// what ffprobe said couldn't be read, which is on us rather than the input
+	if _, err := parseProbe("dog.mov", []byte("not json")); err == nil || errors.Is(err, ErrInvalidInput) {
+		t.Errorf("expected an error that isn't ErrInvalidInput, got %v", err)
+	}
+}
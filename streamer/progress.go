@@ -0,0 +1,194 @@
+package streamer
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of how far along an encode is. Percent is 0 when we don't know how long the source is
+type Progress struct {
+	ID      int     `json:"id"`
+	OutTime float64 `json:"out_time"` // seconds of output written so far
+	Speed   string  `json:"speed"`    // as reported by ffmpeg, e.g. 2.5x
+	Percent float64 `json:"percent"`
+	Done    bool    `json:"done"`
+}
+
+// ProgressBroker fans out progress updates to anyone watching a video. The latest update for every video is kept, so
+// a late subscriber doesn't have to wait for the next one, until Forget is called for it
+type ProgressBroker struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan Progress]struct{}
+	latest      map[int]Progress
+}
+
+// NewProgressBroker returns an empty broker
+func NewProgressBroker() *ProgressBroker {
+	return &ProgressBroker{
+		subscribers: make(map[int]map[chan Progress]struct{}),
+		latest:      make(map[int]Progress),
+	}
+}
+
+// Subscribe returns a channel with the progress of a video, starting with the latest update we have. The channel is
+// closed after the final (Done) update. Call the returned func to stop listening
+func (b *ProgressBroker) Subscribe(id int) (<-chan Progress, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Progress, 16)
+
+	if p, ok := b.latest[id]; ok {
+		ch <- p
+		if p.Done {
+			close(ch)
+			return ch, func() {}
+		}
+	}
+
+	if b.subscribers[id] == nil {
+		b.subscribers[id] = make(map[chan Progress]struct{})
+	}
+	b.subscribers[id][ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[id][ch]; ok {
+			delete(b.subscribers[id], ch)
+			if len(b.subscribers[id]) == 0 {
+				delete(b.subscribers, id)
+			}
+			close(ch)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+// Publish sends an update to everyone watching the video. It never blocks: a subscriber that isn't keeping up misses
+// updates, except the final one
+func (b *ProgressBroker) Publish(p Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.latest[p.ID] = p
+
+	for ch := range b.subscribers[p.ID] {
+		if p.Done && len(ch) == cap(ch) {
+			// make room for the final update, it's the one that matters
+			select {
+			case <-ch:
+			default:
+			}
+		}
+
+		select {
+		case ch <- p:
+		default:
+		}
+
+		if p.Done {
+			close(ch)
+		}
+	}
+
+	if p.Done {
+		delete(b.subscribers, p.ID)
+	}
+}
+
+// Latest returns the last update published for a video
+func (b *ProgressBroker) Latest(id int) (Progress, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, ok := b.latest[id]
+	return p, ok
+}
+
+// Forget drops what we know about a video. Call it once the result has been recorded somewhere else
+func (b *ProgressBroker) Forget(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.latest, id)
+}
+
+// PublishProgress reports how far the encode of this video has got. Encoders call it as they go
+func (v *Video) PublishProgress(outTime time.Duration, speed string, total time.Duration) {
+	if v.progress == nil {
+		return
+	}
+
+	p := Progress{
+		ID:      v.ID,
+		OutTime: outTime.Seconds(),
+		Speed:   speed,
+	}
+
+	if total > 0 {
+		p.Percent = min(100, outTime.Seconds()*100/total.Seconds())
+	}
+
+	v.progress.Publish(p)
+}
+
+// publishDone sends the final progress update for this video
+func (v *Video) publishDone(successful bool) {
+	if v.progress == nil {
+		return
+	}
+
+	p, _ := v.progress.Latest(v.ID)
+	p.ID = v.ID
+	p.Done = true
+	if successful {
+		p.Percent = 100
+	}
+
+	v.progress.Publish(p)
+}
+
+// readProgress parses the key=value blocks ffmpeg writes with -progress and reports each one on the video. Every
+// block ends with a progress=continue (or progress=end) line
+func readProgress(r io.Reader, v *Video, total time.Duration) {
+	var outTime time.Duration
+	speed := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "out_time_us", "out_time_ms": // both are in microseconds
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				outTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			if value != "N/A" {
+				speed = strings.TrimSpace(value)
+			}
+		case "progress":
+			v.PublishProgress(outTime, speed, total)
+		}
+	}
+}
+
+// secondsToDuration parses a number of seconds like 12.345, as ffprobe reports durations
+func secondsToDuration(s string) time.Duration {
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
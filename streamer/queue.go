@@ -0,0 +1,202 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultQueueLimit is how many jobs can wait for a worker when New isn't given WithQueueLimit
+const DefaultQueueLimit = 100
+
+// ErrQueueFull is returned (wrapped in a *QueueFullError) by TrySubmit when too many jobs are already waiting
+var ErrQueueFull = errors.New("video queue is full")
+
+// ErrStopped is returned by TrySubmit once the pool has been shut down
+var ErrStopped = errors.New("video worker pool is shut down")
+
+// ErrInvalidPoolSize is returned by Resize when asked for fewer than one worker
+var ErrInvalidPoolSize = errors.New("the worker pool needs at least one worker")
+
+// QueueFullError says how busy the queue was when a job was turned away, so callers can tell clients when to retry
+type QueueFullError struct {
+	Limit      int
+	OldestWait time.Duration // how long the job that has been waiting longest has waited
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("%s: %d jobs waiting, the oldest for %s", ErrQueueFull, e.Limit, e.OldestWait.Round(time.Second))
+}
+
+func (e *QueueFullError) Unwrap() error {
+	return ErrQueueFull
+}
+
+// Option configures a VideoDispatcher in New
+type Option func(vd *VideoDispatcher)
+
+// WithQueueLimit sets how many jobs can wait for a worker. Zero or less means no limit
+func WithQueueLimit(n int) Option {
+	return func(vd *VideoDispatcher) {
+		vd.queueLimit = n
+	}
+}
+
+// queuedJob is a job waiting for a worker, and when it started waiting
+type queuedJob struct {
+	job      VideoProcessingJob
+	queuedAt time.Time
+}
+
+// TrySubmit adds a job to the queue without blocking. It returns a *QueueFullError when the queue is at its limit, and
+// ErrStopped after Shutdown
+func (vd *VideoDispatcher) TrySubmit(job VideoProcessingJob) error {
+	vd.mu.Lock()
+
+	if err := vd.checkCapacity(); err != nil {
+		vd.mu.Unlock()
+		return err
+	}
+
+	vd.enqueue(job)
+	vd.mu.Unlock()
+
+	vd.signal(vd.wake)
+	return nil
+}
+
+// enqueue starts tracking a new job and adds it to the back of the queue. vd.mu must be held
+func (vd *VideoDispatcher) enqueue(job VideoProcessingJob) {
+	job.ctx, job.release = vd.track(job.Video.ID)
+	job.Video.finished = new(atomic.Bool)
+	vd.push(job)
+}
+
+// next takes the job that should go to a worker next off the queue, see pop
+func (vd *VideoDispatcher) next() (VideoProcessingJob, bool) {
+	vd.mu.Lock()
+
+	q, ok := vd.pop()
+	if !ok {
+		vd.mu.Unlock()
+		return VideoProcessingJob{}, false
+	}
+
+	// an exponential moving average, so the figure follows the current load rather than all of history
+	wait := time.Since(q.queuedAt)
+	q.job.Video.Timings.Queued += wait
+	if vd.avgWait == 0 {
+		vd.avgWait = wait
+	} else {
+		vd.avgWait = (vd.avgWait*4 + wait) / 5
+	}
+
+	vd.mu.Unlock()
+
+	vd.signal(vd.room)
+	return q.job, true
+}
+
+// cancelPending cancels every job still waiting for a worker
+func (vd *VideoDispatcher) cancelPending() {
+	vd.mu.Lock()
+	lanes := vd.lanes
+	vd.lanes = [laneCount][]queuedJob{}
+	vd.mu.Unlock()
+
+	for _, pending := range lanes {
+		for _, q := range pending {
+			q.job.release()
+			q.job.Video.sendCancelled()
+		}
+	}
+}
+
+// signal pokes a goroutine waiting on ch, without blocking if it has already been poked
+func (vd *VideoDispatcher) signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// QueueDepth returns how many jobs are waiting for a worker
+func (vd *VideoDispatcher) QueueDepth() int {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	return vd.pendingLen()
+}
+
+// QueueLimit returns how many jobs can wait for a worker, 0 meaning no limit
+func (vd *VideoDispatcher) QueueLimit() int {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	return max(vd.queueLimit, 0)
+}
+
+// OldestWait returns how long the job that has been waiting longest, whatever its priority, has been waiting. It is 0
+// if the queue is empty
+func (vd *VideoDispatcher) OldestWait() time.Duration {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	oldest, ok := vd.oldestQueued()
+	if !ok {
+		return 0
+	}
+
+	return time.Since(oldest)
+}
+
+// AverageWait returns a moving average of how long recent jobs waited for a worker
+func (vd *VideoDispatcher) AverageWait() time.Duration {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	return vd.avgWait
+}
+
+// track registers a job so it can be cancelled. vd.mu must be held. Call release once the job is done
+func (vd *VideoDispatcher) track(id int) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	vd.cancels[id] = cancel
+
+	release := func() {
+		vd.mu.Lock()
+		delete(vd.cancels, id)
+		vd.mu.Unlock()
+		cancel()
+	}
+
+	return ctx, release
+}
+
+// CanSubmit returns the error TrySubmit would return right now, if any. It lets a caller turn work away before doing
+// anything expensive, but TrySubmit can still fail if other jobs get in first
+func (vd *VideoDispatcher) CanSubmit() error {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	return vd.checkCapacity()
+}
+
+// checkCapacity is CanSubmit for callers that hold vd.mu
+func (vd *VideoDispatcher) checkCapacity() error {
+	if vd.stopped {
+		return ErrStopped
+	}
+
+	if vd.queueLimit > 0 && vd.pendingLen() >= vd.queueLimit {
+		oldest, _ := vd.oldestQueued()
+		return &QueueFullError{
+			Limit:      vd.queueLimit,
+			OldestWait: time.Since(oldest),
+		}
+	}
+
+	return nil
+}
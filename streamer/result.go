@@ -0,0 +1,187 @@
+package streamer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrorKind says why a video wasn't encoded, in ProcessingMessage.ErrorKind
+type ErrorKind string
+
+const (
+	ErrorKindInvalidInput  ErrorKind = "invalid_input"  // the input or the options asked for something we can't do
+	ErrorKindEncoderFailed ErrorKind = "encoder_failed" // ffmpeg, or publishing what it wrote, failed
+	ErrorKindCancelled     ErrorKind = "cancelled"      // cancelled, or the pool was shut down
+	ErrorKindTimeout       ErrorKind = "timeout"        // the encode took longer than VideoOptions.Timeout
+)
+
+// ArtifactKind says what a file written by an encode is for
+type ArtifactKind string
+
+const (
+	ArtifactVideo           ArtifactKind = "video"            // an MP4 to play as it is
+	ArtifactMasterPlaylist  ArtifactKind = "master_playlist"  // the HLS playlist players open
+	ArtifactVariantPlaylist ArtifactKind = "variant_playlist" // the HLS playlist of one rendition
+	ArtifactManifest        ArtifactKind = "manifest"         // the DASH manifest
+	ArtifactInitSegment     ArtifactKind = "init_segment"     // the header a rendition's fMP4 segments need
+	ArtifactSegment         ArtifactKind = "segment"
+	ArtifactImage           ArtifactKind = "image" // a poster, thumbnail or sprite sheet
+	ArtifactTrack           ArtifactKind = "track" // a WebVTT file, like the one mapping times to the sprite sheet
+	ArtifactOther           ArtifactKind = "other"
+)
+
+// Artifact is a file an encode wrote
+type Artifact struct {
+	Name string       `json:"name"` // relative to the output directory of the video
+	Kind ArtifactKind `json:"kind"`
+	Size int64        `json:"size"`          // in bytes
+	URL  string       `json:"url,omitempty"` // where it was published to, when the video has a storage
+}
+
+// Timings says where the time went on a video. Everything but Queued is for the last attempt
+type Timings struct {
+	Queued     time.Duration // waiting for a worker, over every attempt
+	Probe      time.Duration
+	Encode     time.Duration
+	Thumbnails time.Duration
+	Publish    time.Duration
+	Total      time.Duration // from a worker picking the video up to it being done with it
+}
+
+// MarshalJSON writes the timings in milliseconds, which is what anyone reading them wants rather than nanoseconds
+func (t Timings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]int64{
+		"queued_ms":     t.Queued.Milliseconds(),
+		"probe_ms":      t.Probe.Milliseconds(),
+		"encode_ms":     t.Encode.Milliseconds(),
+		"thumbnails_ms": t.Thumbnails.Milliseconds(),
+		"publish_ms":    t.Publish.Milliseconds(),
+		"total_ms":      t.Total.Milliseconds(),
+	})
+}
+
+// stderrTailLines is how much of what ffmpeg said a failure message carries
+const stderrTailLines = 20
+
+// FFmpegError is what an encode fails with when ffmpeg exits with an error. Stderr is the end of what it wrote there,
+// which is where it explains what went wrong
+type FFmpegError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *FFmpegError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, stderrTail(e.Stderr, 5))
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// errorKind sorts the error an attempt failed with. ctx is the context the attempt ran with
+func errorKind(ctx context.Context, err error) ErrorKind {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrorKindTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorKindCancelled
+	case !retryable(err):
+		return ErrorKindInvalidInput
+	default:
+		return ErrorKindEncoderFailed
+	}
+}
+
+// ffmpegStderr returns the end of what ffmpeg wrote to stderr, if err came from it
+func ffmpegStderr(err error) string {
+	var ffmpegErr *FFmpegError
+	if errors.As(err, &ffmpegErr) {
+		return ffmpegErr.Stderr
+	}
+
+	return ""
+}
+
+// snapshotFiles returns the modification time of every file under dir, so artifacts can tell what an encode wrote
+// from what was already there. A dir that doesn't exist has no files
+func snapshotFiles(dir string) map[string]time.Time {
+	files := make(map[string]time.Time)
+
+	_ = walkFiles(dir, func(file, rel string) error {
+		if info, err := os.Stat(file); err == nil {
+			files[rel] = info.ModTime()
+		}
+		return nil
+	})
+
+	return files
+}
+
+// artifacts lists the files under dir that are new or have changed since before was taken, sorted by name
+func artifacts(dir string, before map[string]time.Time, format Format, baseFileName string) []Artifact {
+	var found []Artifact
+
+	_ = walkFiles(dir, func(file, rel string) error {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil
+		}
+
+		if modTime, ok := before[rel]; ok && modTime.Equal(info.ModTime()) {
+			return nil
+		}
+
+		name := filepath.ToSlash(rel)
+		found = append(found, Artifact{
+			Name: name,
+			Kind: artifactKind(name, format.OutputFile(baseFileName)),
+			Size: info.Size(),
+		})
+		return nil
+	})
+
+	return found
+}
+
+// artifactKind works out what a file is for from its name. outputFile is what players open
+func artifactKind(name, outputFile string) ArtifactKind {
+	ext := strings.ToLower(path.Ext(name))
+
+	switch {
+	case name == outputFile && ext == ".m3u8":
+		return ArtifactMasterPlaylist
+	case name == outputFile && ext == ".mp4":
+		return ArtifactVideo
+	case ext == ".m3u8":
+		return ArtifactVariantPlaylist
+	case ext == ".mpd":
+		return ArtifactManifest
+	case strings.Contains(path.Base(name), "-init-") || (ext == ".mp4" && strings.HasPrefix(path.Base(name), "init")):
+		return ArtifactInitSegment
+	case ext == ".ts" || ext == ".m4s":
+		return ArtifactSegment
+	case ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".webp":
+		return ArtifactImage
+	case ext == ".vtt":
+		return ArtifactTrack
+	default:
+		return ArtifactOther
+	}
+}
+
+// outputSize adds up the sizes of the artifacts
+func outputSize(artifacts []Artifact) int64 {
+	var size int64
+	for _, a := range artifacts {
+		size += a.Size
+	}
+
+	return size
+}
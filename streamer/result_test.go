@@ -0,0 +1,72 @@
+package streamer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArtifactKind(t *testing.T) {
+	tests := []struct {
+		name       string
+		outputFile string
+		expected   ArtifactKind
+	}{
+		{"dog.mp4", "dog.mp4", ArtifactVideo},
+		{"dog.m3u8", "dog.m3u8", ArtifactMasterPlaylist},
+		{"dog-720p.m3u8", "dog.m3u8", ArtifactVariantPlaylist},
+		{"dog-720p-003.ts", "dog.m3u8", ArtifactSegment},
+		{"dog.mpd", "dog.mpd", ArtifactManifest},
+		{"dog-init-0.m4s", "dog.mpd", ArtifactInitSegment},
+		{"dog-chunk-0-00001.m4s", "dog.mpd", ArtifactSegment},
+		{"dog-poster.jpg", "dog.mpd", ArtifactImage},
+		{"dog-sprite.vtt", "dog.mp4", ArtifactTrack},
+		{"dog.txt", "dog.mp4", ArtifactOther},
+	}
+
+	for _, e := range tests {
+		if kind := artifactKind(e.name, e.outputFile); kind != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, kind)
+		}
+	}
+}
+
+func TestVideo_encodeArtifacts(t *testing.T) {
+	output := t.TempDir()
+
+	// another video in the same directory isn't part of this encode
+	_ = os.WriteFile(filepath.Join(output, "cat.m3u8"), []byte("#EXTM3U"), 0644)
+
+	v := &Video{
+		ID:           7,
+		InputFile:    "/uploads/dog.mov",
+		OutputDir:    output,
+		EncodingType: "hls",
+		Options:      &VideoOptions{},
+		Encoder:      Processor{Engine: &writingEncoder{}},
+	}
+
+	if _, err := v.encode(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Artifact{
+		"dog.m3u8": {Name: "dog.m3u8", Kind: ArtifactMasterPlaylist, Size: 7},
+		"dog-0.ts": {Name: "dog-0.ts", Kind: ArtifactSegment, Size: 7},
+	}
+
+	if len(v.Artifacts) != len(expected) {
+		t.Fatalf("expected %d artifacts, got %+v", len(expected), v.Artifacts)
+	}
+
+	for _, a := range v.Artifacts {
+		if a != expected[a.Name] {
+			t.Errorf("expected %+v, got %+v", expected[a.Name], a)
+		}
+	}
+
+	if size := outputSize(v.Artifacts); size != 14 {
+		t.Errorf("expected 14 bytes of output, got %d", size)
+	}
+}
@@ -0,0 +1,181 @@
+package streamer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultDeadLetterLimit is how many dead letters are kept when New isn't given WithDeadLetterLimit
+const DefaultDeadLetterLimit = 100
+
+// ErrNotDeadLettered is returned by Requeue for a video that isn't in the dead-letter list
+var ErrNotDeadLettered = errors.New("video is not in the dead-letter list")
+
+// RetryPolicy says how many times a video is encoded before we give up on it, and how long to wait between attempts.
+// The wait starts at InitialBackoff and doubles after every failed attempt, up to MaxBackoff
+type RetryPolicy struct {
+	MaxAttempts    int           // including the first one, 0 or 1 means a failed encode isn't retried
+	InitialBackoff time.Duration // the wait before the second attempt
+	MaxBackoff     time.Duration // the longest wait between attempts, 0 for no limit
+}
+
+// DefaultRetryPolicy is a sensible policy for encodes that may fail for reasons that go away, like a full disk
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 10 * time.Second,
+	MaxBackoff:     5 * time.Minute,
+}
+
+// Backoff returns how long to wait after the given number of failed attempts
+func (p RetryPolicy) Backoff(failed int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < failed; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	return max(delay, 0)
+}
+
+// permanentErrors are what a video fails with when it asked for something we can't do. Trying again won't help
+var permanentErrors = []error{ErrInvalidEncodingType, ErrInvalidLadder, ErrInvalidInput, ErrUnsupportedFormat, ErrInvalidEncryption, ErrInvalidNaming}
+
+// retryable reports whether an encode that failed with err might work if it is tried again
+func retryable(err error) bool {
+	for _, permanent := range permanentErrors {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allows reports whether a video that has already been tried this many times gets another go
+func (p RetryPolicy) allows(attempts int) bool {
+	return attempts < p.MaxAttempts
+}
+
+// DeadLetter is a video that failed on its last attempt. It can be put back on the queue with Requeue
+type DeadLetter struct {
+	Video    Video
+	Attempts int
+	Error    string
+	FailedAt time.Time
+}
+
+// WithDeadLetterLimit sets how many dead letters are kept, the oldest being dropped first. Zero or less means no limit
+func WithDeadLetterLimit(n int) Option {
+	return func(vd *VideoDispatcher) {
+		vd.deadLetterLimit = n
+	}
+}
+
+// retryLater puts a job whose encode failed back on the queue once the backoff is over. The job stays tracked while it
+// waits, so it can still be cancelled
+func (vd *VideoDispatcher) retryLater(job VideoProcessingJob, delay time.Duration) {
+	vd.retrying.Add(1)
+	go func() {
+		defer vd.retrying.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			vd.requeue(job)
+		case <-job.ctx.Done():
+			job.release()
+			job.Video.sendCancelled()
+		case <-vd.quit:
+			job.release()
+			job.Video.sendCancelled()
+		}
+	}()
+}
+
+// requeue adds a job that is being retried to the back of its lane. It was accepted once already, so the queue
+// limit doesn't apply
+func (vd *VideoDispatcher) requeue(job VideoProcessingJob) {
+	vd.mu.Lock()
+
+	if vd.stopped {
+		vd.mu.Unlock()
+		job.release()
+		job.Video.sendCancelled()
+		return
+	}
+
+	vd.push(job)
+	vd.mu.Unlock()
+
+	vd.signal(vd.wake)
+}
+
+// deadLetter records a video that failed on its last attempt
+func (vd *VideoDispatcher) deadLetter(v Video, message string) {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	vd.deadLetters = append(vd.deadLetters, DeadLetter{
+		Video:    v,
+		Attempts: v.attempts,
+		Error:    message,
+		FailedAt: time.Now(),
+	})
+
+	if vd.deadLetterLimit > 0 && len(vd.deadLetters) > vd.deadLetterLimit {
+		vd.deadLetters = append([]DeadLetter(nil), vd.deadLetters[len(vd.deadLetters)-vd.deadLetterLimit:]...)
+	}
+}
+
+// DeadLetters returns the videos that ran out of attempts, oldest first
+func (vd *VideoDispatcher) DeadLetters() []DeadLetter {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	return append([]DeadLetter(nil), vd.deadLetters...)
+}
+
+// Requeue takes a video out of the dead-letter list and puts it back on the queue with a fresh set of attempts. It
+// returns ErrNotDeadLettered if there is no such dead letter, and the same errors as TrySubmit when the queue can't
+// take it
+func (vd *VideoDispatcher) Requeue(id int) error {
+	vd.mu.Lock()
+
+	i := -1
+	for n, dl := range vd.deadLetters {
+		if dl.Video.ID == id {
+			i = n
+			break
+		}
+	}
+
+	if i < 0 {
+		vd.mu.Unlock()
+		return fmt.Errorf("%w: %d", ErrNotDeadLettered, id)
+	}
+
+	if err := vd.checkCapacity(); err != nil {
+		vd.mu.Unlock()
+		return err
+	}
+
+	job := VideoProcessingJob{Video: vd.deadLetters[i].Video}
+	job.Video.attempts = 0
+	job.Video.Timings = Timings{}
+	vd.deadLetters = append(vd.deadLetters[:i], vd.deadLetters[i+1:]...)
+
+	vd.enqueue(job)
+	vd.mu.Unlock()
+
+	vd.signal(vd.wake)
+	return nil
+}
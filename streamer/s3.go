@@ -0,0 +1,247 @@
+package streamer
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3Storage publishes encoded videos to a bucket on S3, or on anything that speaks its API, like MinIO. Objects are
+// addressed path style, Endpoint/Bucket/key, which every S3 compatible server understands, and requests are signed
+// with AWS Signature Version 4
+type S3Storage struct {
+	Endpoint  string       // e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Bucket    string       // has to exist already
+	Region    string       // us-east-1 when empty
+	AccessKey string       // access key id
+	SecretKey string       // secret access key
+	PublicURL string       // where published objects can be fetched from, Endpoint/Bucket when empty. Set it for a CDN
+	Client    *http.Client // http.DefaultClient when nil
+}
+
+// Publish uploads every file under dir to the bucket, under prefix
+func (s *S3Storage) Publish(ctx context.Context, dir, prefix string) error {
+	return walkFiles(dir, func(file, rel string) error {
+		return s.put(ctx, objectKey(prefix, rel), file)
+	})
+}
+
+// URL returns the URL of a published object
+func (s *S3Storage) URL(prefix, name string) string {
+	base := s.PublicURL
+	if base == "" {
+		base = strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + escapeKey(objectKey(prefix, name))
+}
+
+// Exists reports whether the bucket has an object called name under prefix
+func (s *S3Storage) Exists(ctx context.Context, prefix, name string) (bool, error) {
+	key := objectKey(prefix, name)
+
+	// an empty payload has a hash too
+	req, err := s.newRequest(ctx, http.MethodHead, key, nil, hashHex(nil))
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("looking for %s: %s", key, resp.Status)
+	}
+}
+
+// put uploads one file as the object with the given key
+func (s *S3Storage) put(ctx context.Context, key, file string) error {
+	payloadHash, size, err := fileSHA256(file)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := s.newRequest(ctx, http.MethodPut, key, f, payloadHash)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", ContentType(file))
+
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("uploading %s: %s%s", key, resp.Status, s3ErrorMessage(resp.Body))
+	}
+
+	return nil
+}
+
+// newRequest builds a request for the object with the given key. Requests without a body are signed here, the ones
+// with one need their headers set first, and signing after
+func (s *S3Storage) newRequest(ctx context.Context, method, key string, body io.Reader, payloadHash string) (*http.Request, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint %q: %w", s.Endpoint, err)
+	}
+	u.Path = "/" + s.Bucket + "/" + key
+	u.RawPath = "/" + s.Bucket + "/" + awsEscape(key)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body == nil {
+		s.sign(req, payloadHash, time.Now())
+	}
+
+	return req, nil
+}
+
+func (s *S3Storage) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+
+	return s.Client
+}
+
+// sign adds the headers that authenticate the request with the access key: the time, the hash of the payload and
+// the Authorization header with the signature over them
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	region := cmp.Or(s.Region, "us-east-1")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", amzDate[:8], region)
+	signedHeaders, signature := s3Signature(s.SecretKey, region, req.Method, req.URL.EscapedPath(), req.URL.Host, req.Header)
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// s3Signature works out the Signature Version 4 of a request without a query string, from the headers sign sets and
+// the content type, if there is one. It is the same sum the server does to check the request, which is why it only
+// takes what the server can see
+func s3Signature(secretKey, region, method, escapedPath, host string, header http.Header) (signedHeaders, signature string) {
+	amzDate := header.Get("X-Amz-Date")
+	payloadHash := header.Get("X-Amz-Content-Sha256")
+
+	// the headers have to be in order, and content-type comes first
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", host, payloadHash, amzDate)
+	signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	if ct := strings.TrimSpace(header.Get("Content-Type")); ct != "" {
+		canonicalHeaders = "content-type:" + ct + "\n" + canonicalHeaders
+		signedHeaders = "content-type;" + signedHeaders
+	}
+
+	canonicalRequest := strings.Join([]string{method, escapedPath, "", canonicalHeaders, signedHeaders, payloadHash}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", amzDate[:min(8, len(amzDate))], region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := sigV4Key(secretKey, amzDate[:min(8, len(amzDate))], region, "s3")
+	return signedHeaders, hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+}
+
+// sigV4Key derives the key requests are signed with on the given day (yyyymmdd), for a region and service
+func sigV4Key(secretKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileSHA256 returns the hex SHA-256 of a file and its size, which signing the upload of it needs up front
+func fileSHA256(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// awsEscape escapes an object key the way Signature Version 4 wants: everything but letters, digits, -._~ and the /
+// between segments is percent-encoded
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// s3ErrorMessage pulls the message out of the XML error S3 answers with, if there is one
+func s3ErrorMessage(body io.Reader) string {
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+
+	if err := xml.NewDecoder(io.LimitReader(body, 4096)).Decode(&s3Err); err != nil || s3Err.Code == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(": %s: %s", s3Err.Code, s3Err.Message)
+}
@@ -0,0 +1,239 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// errNoScratch is what publishing fails with when the video wasn't encoded to a scratch directory
+var errNoScratch = errors.New("the video has no scratch directory to publish")
+
+// Storage is where the files of encoded videos end up. When the processor of a video has one, the encoder writes to
+// a scratch directory that is published to the storage once everything in it is done, so nobody ever sees half an
+// encode, and the messages for the video carry URLs from the storage rather than file names
+type Storage interface {
+	// Publish stores every file under dir, keeping their paths relative to dir, under prefix
+	Publish(ctx context.Context, dir, prefix string) error
+
+	// URL returns where a published file can be fetched from
+	URL(prefix, name string) string
+
+	// Exists reports whether a file has been published under prefix with the given name
+	Exists(ctx context.Context, prefix, name string) (bool, error)
+}
+
+// WithStorage publishes encoded videos to s, with Video.OutputDir as the prefix they go under. Encoders write to a
+// new directory under scratchDir first, or under the system's temporary directory when scratchDir is empty
+func WithStorage(s Storage, scratchDir string) Option {
+	return func(vd *VideoDispatcher) {
+		vd.Processor.Storage = s
+		vd.Processor.ScratchDir = scratchDir
+	}
+}
+
+// WorkDir is the directory encoders write the files of the video to: OutputDir, or the scratch directory of the
+// encode when the video is published to a storage
+func (v *Video) WorkDir() string {
+	if v.workDir != "" {
+		return v.workDir
+	}
+
+	return v.OutputDir
+}
+
+// publish stores what the encoder wrote and swaps the names of the output file and the thumbnails for their URLs
+func (v *Video) publish(ctx context.Context, outputFile string) (string, error) {
+	s := v.Encoder.Storage
+	if v.workDir == "" {
+		return "", errNoScratch
+	}
+
+	if err := s.Publish(ctx, v.workDir, v.OutputDir); err != nil {
+		return "", fmt.Errorf("publishing: %w", err)
+	}
+
+	if t := v.Thumbnails; t != nil {
+		published := &Thumbnails{Poster: s.URL(v.OutputDir, t.Poster)}
+		for _, image := range t.Images {
+			published.Images = append(published.Images, s.URL(v.OutputDir, image))
+		}
+		if t.Sprite != "" {
+			published.Sprite = s.URL(v.OutputDir, t.Sprite)
+			published.SpriteVTT = s.URL(v.OutputDir, t.SpriteVTT)
+		}
+		v.Thumbnails = published
+	}
+
+	for i, a := range v.Artifacts {
+		v.Artifacts[i].URL = s.URL(v.OutputDir, a.Name)
+	}
+
+	return s.URL(v.OutputDir, outputFile), nil
+}
+
+// savedAs is where the output file of an encode ended up, for messages: its URL when the video was published to a
+// storage, its path otherwise
+func (v *Video) savedAs(outputFile string) string {
+	if v.Encoder.Storage != nil {
+		return outputFile
+	}
+
+	return fmt.Sprintf("%s/%s", v.OutputDir, outputFile)
+}
+
+// LocalStorage publishes encoded videos to a directory on this machine
+type LocalStorage struct {
+	Root    string // directory the prefixes are under, prefixes are paths of their own when empty
+	BaseURL string // URL Root is served at. Without one, URL returns the path of the file
+}
+
+// Publish moves the files under dir to Root/prefix, replacing files already there with the same name
+func (ls *LocalStorage) Publish(ctx context.Context, dir, prefix string) error {
+	dest := filepath.Join(ls.Root, prefix)
+
+	return walkFiles(dir, func(file, rel string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		// renaming only works on the same file system, the scratch directory may well be on another one
+		if err := os.Rename(file, target); err == nil {
+			return nil
+		}
+
+		return copyFile(file, target)
+	})
+}
+
+// URL returns the URL of a published file under BaseURL, or its path when there is no BaseURL
+func (ls *LocalStorage) URL(prefix, name string) string {
+	if ls.BaseURL == "" {
+		return filepath.Join(ls.Root, prefix, name)
+	}
+
+	return strings.TrimSuffix(ls.BaseURL, "/") + "/" + escapeKey(objectKey(prefix, name))
+}
+
+// Exists reports whether Root/prefix has a file with the given name
+func (ls *LocalStorage) Exists(ctx context.Context, prefix, name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(ls.Root, prefix, name))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// walkFiles calls fn for every regular file under dir, with its path relative to dir
+func walkFiles(dir string, fn func(file, rel string) error) error {
+	return filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		return fn(file, rel)
+	})
+}
+
+// copyFile copies src to dst, through a temporary file so dst is never seen half written
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(out.Name())
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	if err = out.Chmod(0644); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), dst)
+}
+
+// objectKey joins a prefix and a file name into the slash separated key of an object, without leading or trailing
+// slashes
+func objectKey(prefix, name string) string {
+	key := path.Clean("/" + path.Join(filepath.ToSlash(prefix), filepath.ToSlash(name)))
+	return strings.TrimPrefix(key, "/")
+}
+
+// escapeKey escapes each segment of an object key for use in a URL path
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// contentTypes are the content types of the files an encode writes, and the images served next to them, which Go's
+// mime package gets wrong or doesn't know
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".mpd":  "application/dash+xml",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".vtt":  "text/vtt; charset=utf-8",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+}
+
+// ContentType returns the content type to store or serve a file with, by the extension of its name
+func ContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+
+	return "application/octet-stream"
+}
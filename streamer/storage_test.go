@@ -0,0 +1,250 @@
+package streamer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a stand-in for an S3 compatible server like MinIO. It checks the signature of every request the way S3
+// does, keeps what it was sent and answers HEAD requests for it
+type fakeS3 struct {
+	accessKey, secretKey, region string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		accessKey: "minio",
+		secretKey: "minio-secret",
+		region:    "us-east-1",
+		objects:   make(map[string][]byte),
+		types:     make(map[string]string),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodHead {
+		http.Error(w, "only uploads and lookups", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		s3Error(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+		return
+	}
+
+	signedHeaders, signature := s3Signature(f.secretKey, f.region, r.Method, r.URL.EscapedPath(), r.Host, r.Header)
+	date := r.Header.Get("X-Amz-Date")
+	want := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s/%s/s3/aws4_request, SignedHeaders=%s, Signature=%s", f.accessKey, date[:min(8, len(date))], f.region, signedHeaders, signature)
+	if r.Header.Get("Authorization") != want {
+		s3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodHead {
+		if _, ok := f.objects[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+
+	f.objects[r.URL.Path] = body
+	f.types[r.URL.Path] = r.Header.Get("Content-Type")
+}
+
+func s3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, message)
+}
+
+// writingEncoder writes a small output file for each format, like ffmpeg would
+type writingEncoder struct{}
+
+func (e *writingEncoder) EncodeToMP4(ctx context.Context, v *Video, baseFileName string) error {
+	return os.WriteFile(filepath.Join(v.WorkDir(), baseFileName+".mp4"), []byte("mp4"), 0644)
+}
+
+func (e *writingEncoder) EncodeToHLS(ctx context.Context, v *Video, baseFileName string) error {
+	if err := os.WriteFile(filepath.Join(v.WorkDir(), baseFileName+"-0.ts"), []byte("segment"), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(v.WorkDir(), baseFileName+".m3u8"), []byte("#EXTM3U"), 0644)
+}
+
+func (e *writingEncoder) EncodeToDASH(ctx context.Context, v *Video, baseFileName string) error {
+	if err := os.WriteFile(filepath.Join(v.WorkDir(), baseFileName+"-chunk-0-00001.m4s"), []byte("segment"), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(v.WorkDir(), baseFileName+".mpd"), []byte("<MPD/>"), 0644)
+}
+
+func (e *writingEncoder) EncodeToCMAF(ctx context.Context, v *Video, baseFileName string) error {
+	if err := e.EncodeToDASH(ctx, v, baseFileName); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(v.WorkDir(), baseFileName+".m3u8"), []byte("#EXTM3U"), 0644)
+}
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"dog.m3u8", "application/vnd.apple.mpegurl"},
+		{"videos/7/dog-720p0.ts", "video/mp2t"},
+		{"dog.mpd", "application/dash+xml"},
+		{"dog-chunk-0-00001.m4s", "video/iso.segment"},
+		{"DOG.MP4", "video/mp4"},
+		{"dog-sprite.vtt", "text/vtt; charset=utf-8"},
+		{"dog-poster.jpg", "image/jpeg"},
+		{"dog.webp", "image/webp"},
+		{"dog.css", "text/css; charset=utf-8"},
+		{"dog", "application/octet-stream"},
+	}
+
+	for _, e := range tests {
+		if got := ContentType(e.name); got != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestSigV4Key(t *testing.T) {
+	// the example from the AWS documentation on deriving a signing key
+	key := sigV4Key("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+
+	if got := hex.EncodeToString(key); got != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Errorf("wrong signing key %s", got)
+	}
+}
+
+func TestS3Storage_Publish(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "dog.m3u8"), []byte("#EXTM3U"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "dog 720p+0.ts"), []byte("segment"), 0644)
+
+	tests := []struct {
+		name      string
+		secretKey string
+		expectErr string
+	}{
+		{"signed upload", fake.secretKey, ""},
+		{"wrong secret", "guess", "SignatureDoesNotMatch"},
+	}
+
+	for _, e := range tests {
+		s := &S3Storage{Endpoint: server.URL, Bucket: "videos", AccessKey: fake.accessKey, SecretKey: e.secretKey}
+
+		err := s.Publish(context.Background(), dir, "12")
+		if e.expectErr != "" {
+			if err == nil || !strings.Contains(err.Error(), e.expectErr) {
+				t.Errorf("%s: expected an error with %s, got %v", e.name, e.expectErr, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		if string(fake.objects["/videos/12/dog 720p+0.ts"]) != "segment" {
+			t.Errorf("%s: segment was not uploaded, have %v", e.name, fake.objects)
+		}
+
+		if fake.types["/videos/12/dog.m3u8"] != "application/vnd.apple.mpegurl" {
+			t.Errorf("%s: playlist uploaded as %s", e.name, fake.types["/videos/12/dog.m3u8"])
+		}
+
+		if url := s.URL("12", "dog.m3u8"); url != server.URL+"/videos/12/dog.m3u8" {
+			t.Errorf("%s: wrong url %s", e.name, url)
+		}
+	}
+}
+
+func TestVideo_encodeWithStorage(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	local := t.TempDir()
+
+	tests := []struct {
+		name         string
+		storage      Storage
+		encodingType string
+		expectedURL  string
+		published    func() bool
+	}{
+		{
+			"s3", &S3Storage{Endpoint: server.URL, Bucket: "videos", AccessKey: fake.accessKey, SecretKey: fake.secretKey, PublicURL: "https://cdn.example.com"},
+			"hls", "https://cdn.example.com/videos/7/dog.m3u8",
+			func() bool { return fake.objects["/videos/videos/7/dog-0.ts"] != nil },
+		},
+		{
+			"local", &LocalStorage{Root: local, BaseURL: "/static"},
+			"mp4", "/static/videos/7/dog.mp4",
+			func() bool { _, err := os.Stat(filepath.Join(local, "videos", "7", "dog.mp4")); return err == nil },
+		},
+	}
+
+	for _, e := range tests {
+		scratch := t.TempDir()
+
+		v := &Video{
+			ID:           7,
+			InputFile:    "/uploads/dog.mov",
+			OutputDir:    "videos/7",
+			EncodingType: e.encodingType,
+			Options:      &VideoOptions{},
+			Encoder:      Processor{Engine: &writingEncoder{}, Storage: e.storage, ScratchDir: scratch},
+		}
+
+		outputFile, err := v.encode(context.Background())
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+
+		if outputFile != e.expectedURL {
+			t.Errorf("%s: wrong output file %s", e.name, outputFile)
+		}
+
+		if !e.published() {
+			t.Errorf("%s: the encode was not published", e.name)
+		}
+
+		for _, a := range v.Artifacts {
+			if a.URL != e.storage.URL(v.OutputDir, a.Name) {
+				t.Errorf("%s: %s was published to %s", e.name, a.Name, a.URL)
+			}
+		}
+
+		if left, _ := os.ReadDir(scratch); len(left) != 0 {
+			t.Errorf("%s: the scratch directory was not cleaned up", e.name)
+		}
+	}
+}
@@ -1,31 +1,65 @@
 package streamer
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"path"
+	"os"
 	"path/filepath"
-	"strings"
+	"sync/atomic"
+	"time"
+)
 
-	"github.com/tsawler/toolbox"
+// Statuses a video goes through, as reported in ProcessingMessage.Status
+const (
+	StatusRunning   = "running"
+	StatusRetrying  = "retrying" // an attempt failed, the video will be encoded again after a backoff
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
 )
 
+// ErrInvalidEncodingType is what encoding a video with an EncodingType we don't know fails with. Retrying won't help
+var ErrInvalidEncodingType = errors.New("invalid encoding type")
+
+// ProcessingMessage is sent to a video's NotifyChan when a worker starts on it, when an attempt fails and will be
+// retried, and again when it is done. Every video that is submitted gets exactly one message that is Done
 type ProcessingMessage struct {
-	ID         int
-	Successful bool
-	Message    string
-	OutputFile string
+	ID         int         `json:"id"`
+	Status     string      `json:"status"`
+	Successful bool        `json:"successful"`
+	Message    string      `json:"message"`
+	OutputFile string      `json:"output_file,omitempty"`
+	Attempt    int         `json:"attempt"` // how many times the video has been picked up by a worker, 0 if it never was
+	Priority   Priority    `json:"priority"`
+	Thumbnails *Thumbnails `json:"thumbnails,omitempty"`  // the images made for the video, on success if its options asked for any
+	ErrorKind  ErrorKind   `json:"error_kind,omitempty"`  // why the attempt failed, on retrying, failed and cancelled messages
+	Artifacts  []Artifact  `json:"artifacts,omitempty"`   // every file the encode wrote, on success
+	OutputSize int64       `json:"output_size"`           // the size of all the artifacts together, in bytes
+	Timings    Timings     `json:"timings"`               // where the time went, on the final message
+	StderrTail string      `json:"stderr_tail,omitempty"` // the end of what ffmpeg wrote to stderr, when the attempt failed because of it
+}
+
+// Done reports whether this is the final message for the video
+func (pm ProcessingMessage) Done() bool {
+	return pm.Status != StatusRunning && pm.Status != StatusRetrying
 }
 
 // This will hold the unit of work that we want our worker pool to perform
 // We wrap this type around a Video, which has all the information we need about the input source and what we want the output to look like()
 type VideoProcessingJob struct {
 	Video Video
+
+	ctx     context.Context // cancelled through VideoDispatcher.Cancel, set when the job is dispatched
+	release func()          // stops tracking the job once it's done
 }
 
 // This will return the format of the data we need (ex. convert mp4 into a web mp4)
 // Does the actually processing
 type Processor struct {
-	Engine Encoder
+	Engine     Encoder
+	Storage    Storage // where encoded videos are published to, nil to leave them in Video.OutputDir
+	ScratchDir string  // where videos are encoded before they are published, the system's temporary directory when empty
 }
 
 type Video struct {
@@ -37,14 +71,34 @@ type Video struct {
 	Options      *VideoOptions
 	Encoder      Processor
 	EncodingType string
+	Priority     Priority        // which lane the video waits in for a worker, normal unless set otherwise
+	Media        *MediaInfo      // what is in the input file, once it has been probed
+	Thumbnails   *Thumbnails     // the images made from the video, once it has been encoded
+	Artifacts    []Artifact      // the files the encode wrote, once it has been encoded
+	Timings      Timings         // where the time went on the video so far
+	progress     *ProgressBroker // where encode progress is published, if anyone wants it
+	attempts     int             // how many times a worker has started on it
+	workDir      string          // the scratch directory of the encode, when the video is published to a storage
+	finished     *atomic.Bool    // shared by every copy of the video once it is submitted, set by its final message
+	webhooks     *Webhooks       // posts the final message to Options.WebhookURL
+	abandoned    <-chan struct{} // closed when the pool stops waiting for the notify chan to be read
 }
 
 type VideoOptions struct {
-	RenameOutput    bool
+	RenameOutput    bool // name the output randomly, the same as Naming NamingRandom
 	SegmentDuration int
 	MaxRate1080p    string
 	MaxRate720p     string
 	MaxRate480p     string
+	Ladder          []Rendition        // the HLS variants to encode, the default 1080p/720p/480p ladder with the MaxRate options when empty
+	Preset          string             // x264 preset, DefaultPreset when empty
+	Timeout         time.Duration      // how long the encode may take once a worker starts on it, 0 for no limit
+	Retry           RetryPolicy        // what to do when an encode fails, the zero value gives up after the first attempt
+	Thumbnails      *ThumbnailOptions  // which images to make once the video is encoded, nil for none
+	Encryption      *EncryptionOptions // encrypt the segments of an HLS encode, nil to leave them in the clear
+	Naming          string             // naming template for the output files, see ParseNaming. NamingBasename when empty
+	OnExisting      OnExisting         // what to do when the output file is already there, overwrite when empty
+	WebhookURL      string             // where the final message is posted when the pool has WithWebhooks, see ValidateWebhookURL
 }
 
 func (vd *VideoDispatcher) NewVideo(id int, input string, output string, encType string, notifyChan chan ProcessingMessage, options *VideoOptions) Video {
@@ -59,116 +113,251 @@ func (vd *VideoDispatcher) NewVideo(id int, input string, output string, encType
 		InputFile:    input,
 		OutputDir:    output,
 		EncodingType: encType,
+		Priority:     PriorityNormal,
 		NotifyChan:   notifyChan,
 		Encoder:      vd.Processor,
 		Options:      options,
+		progress:     vd.Progress,
+		webhooks:     vd.webhooks,
+		abandoned:    vd.abandon,
 	}
 }
 
-// All pushes to the notify chan will be in this func
-func (v *Video) encode() {
-	var fileName string
-
-	switch v.EncodingType {
-	case "mp4":
-		fmt.Println("v.encode(): About to encode to mp4", v.ID)
-		// encode the video
-		name, err := v.encodeToMp4()
-		if err != nil {
-			// send info to notify chan
-			v.sendToNotifyChan(false, "", fmt.Sprintf("encode failed for %d %s", v.ID, err.Error()))
-		}
-		fileName = fmt.Sprintf("%s.mp4", name)
-	case "hls":
-		fmt.Println("v.encode(): About to encode to mp4", v.ID)
-		// encode the video
-		name, err := v.encodeToHLS()
-		if err != nil {
-			// send info to notify chan
-			v.sendToNotifyChan(false, "", fmt.Sprintf("encode failed for %d %s", v.ID, err.Error()))
-		}
-		fileName = fmt.Sprintf("%s.m3u8", name)
+// encode probes the input and runs the encoder for the video's format, and returns the name of the file it wrote.
+// Cancelling ctx kills the encode. The worker that calls it reports the result on the notify chan. Every file the
+// encode wrote ends up in v.Artifacts, and how long each step took in v.Timings
+func (v *Video) encode(ctx context.Context) (string, error) {
+	v.Artifacts = nil
+	v.Timings = Timings{Queued: v.Timings.Queued}
 
-	default:
+	format, ok := LookupFormat(v.EncodingType)
+	if !ok {
 		fmt.Println("v.encode(): error trying to encode video", v.ID)
-		v.sendToNotifyChan(false, "", fmt.Sprintf("error processing for %d: invalid encoding type", v.ID))
-		return
+		return "", ErrInvalidEncodingType
 	}
 
-	fmt.Println("v.encode(): sending success message for video id", v.ID, "to notify chan")
-	v.sendToNotifyChan(true, fileName, fmt.Sprintf("video id %d processed and saved as %s", v.ID, fmt.Sprintf("%s/%s", v.OutputDir, fileName)))
-}
+	baseFileName, skip, err := v.baseFileName(ctx, format)
+	if err != nil {
+		return "", err
+	}
 
-func (v *Video) encodeToMp4() (string, error) {
-	baseFileName := ""
-	fmt.Println("v.encodeToMP4: about to try to encode video id", v.ID)
+	// the options say what is there already will do, so there is nothing to encode
+	if skip {
+		outputFile := format.OutputFile(baseFileName)
+		fmt.Println("v.encode(): video id", v.ID, "is already encoded as", outputFile)
 
-	if !v.Options.RenameOutput {
-		// Get the base filename
-		b := path.Base(v.InputFile)
-		baseFileName = strings.TrimSuffix(b, filepath.Ext(b)) // ex. cat.mp4 becomes cat
-	} else {
-		var t toolbox.Tools
-		baseFileName = t.RandomString(10)
+		existing := Artifact{Name: outputFile, Kind: artifactKind(outputFile, outputFile)}
+		if v.Encoder.Storage != nil {
+			existing.URL = v.Encoder.Storage.URL(v.OutputDir, outputFile)
+			v.Artifacts = []Artifact{existing}
+			return existing.URL, nil
+		}
+		if info, err := os.Stat(filepath.Join(v.OutputDir, outputFile)); err == nil {
+			existing.Size = info.Size()
+		}
+		v.Artifacts = []Artifact{existing}
+		return outputFile, nil
 	}
 
-	// Encode
-	err := v.Encoder.Engine.EncodeToMP4(v, baseFileName)
-	if err != nil {
+	started := time.Now()
+	if err := v.probe(ctx); err != nil {
 		return "", err
 	}
-	fmt.Println("v.encodeToMp4: successfully encoded video id", v.ID)
+	v.Timings.Probe = time.Since(started)
 
-	return baseFileName, nil
-}
+	// the encode goes to a scratch directory first when it is published to a storage, and is thrown away after
+	if v.Encoder.Storage != nil {
+		scratch, err := os.MkdirTemp(v.Encoder.ScratchDir, fmt.Sprintf("video-%d-", v.ID))
+		if err != nil {
+			return "", err
+		}
+		v.workDir = scratch
+		defer func() {
+			_ = os.RemoveAll(scratch)
+			v.workDir = ""
+		}()
+	}
 
-func (v *Video) encodeToHLS() (string, error) {
-	baseFileName := ""
+	// the output directory may have other videos in it, what this encode wrote is what changed
+	before := snapshotFiles(v.WorkDir())
 
-	if !v.Options.RenameOutput {
-		// Get the base filename
-		b := path.Base(v.InputFile)
-		baseFileName = strings.TrimSuffix(b, filepath.Ext(b)) // ex. cat.mp4 becomes cat
-	} else {
-		var t toolbox.Tools
-		baseFileName = t.RandomString(10)
+	fmt.Println("v.encode(): About to encode to", format.Name, v.ID)
+	started = time.Now()
+	if err := format.Encode(ctx, v.Encoder.Engine, v, baseFileName); err != nil {
+		return "", err
 	}
+	v.Timings.Encode = time.Since(started)
+	fmt.Println("v.encode(): successfully encoded video id", v.ID, "to", format.Name)
 
-	// Encode
-	err := v.Encoder.Engine.EncodeToHLS(v, baseFileName)
-	if err != nil {
-		return "", err
+	// the video is fine without its images, so a failure here doesn't throw the encode away unless we were stopped
+	started = time.Now()
+	if err := v.thumbnails(ctx, baseFileName); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		fmt.Println("v.encode(): video id", v.ID, "has no thumbnails:", err)
 	}
-	fmt.Println("v.encodeToHLS: successfully encoded video id", v.ID)
+	v.Timings.Thumbnails = time.Since(started)
+
+	v.Artifacts = artifacts(v.WorkDir(), before, format, baseFileName)
 
-	return baseFileName, nil
+	if v.Encoder.Storage != nil {
+		started = time.Now()
+		defer func() { v.Timings.Publish = time.Since(started) }()
+		return v.publish(ctx, format.OutputFile(baseFileName))
+	}
+
+	return format.OutputFile(baseFileName), nil
 }
 
-func (v *Video) sendToNotifyChan(successful bool, fileName, message string) {
-	fmt.Println("v.sendToNotifyChan(): sending message to notifyChan for video id", v.ID)
-	v.NotifyChan <- ProcessingMessage{
+// sendSucceeded lets whoever is listening on the notify chan know the video was encoded, and what the encode wrote
+func (v *Video) sendSucceeded(fileName, message string) {
+	fmt.Println("v.sendSucceeded(): sending message to notifyChan for video id", v.ID)
+	v.sendDone(ProcessingMessage{
 		ID:         v.ID,
-		Successful: successful,
+		Status:     StatusSucceeded,
+		Successful: true,
 		Message:    message,
 		OutputFile: fileName,
+		Attempt:    v.attempts,
+		Priority:   v.Priority,
+		Thumbnails: v.Thumbnails,
+		Artifacts:  v.Artifacts,
+		OutputSize: outputSize(v.Artifacts),
+		Timings:    v.Timings,
+	})
+}
+
+// sendFailed lets whoever is listening on the notify chan know the video won't be encoded, and why
+func (v *Video) sendFailed(kind ErrorKind, message string, err error) {
+	fmt.Println("v.sendFailed(): sending message to notifyChan for video id", v.ID)
+	v.sendDone(ProcessingMessage{
+		ID:         v.ID,
+		Status:     StatusFailed,
+		Message:    message,
+		Attempt:    v.attempts,
+		Priority:   v.Priority,
+		ErrorKind:  kind,
+		Timings:    v.Timings,
+		StderrTail: ffmpegStderr(err),
+	})
+}
+
+// sendDone sends the final message for the video. A video only ever gets one, anything trying to send another is
+// ignored
+func (v *Video) sendDone(msg ProcessingMessage) {
+	if v.finished != nil && !v.finished.CompareAndSwap(false, true) {
+		fmt.Println("v.sendDone(): video id", v.ID, "already has its final message, not sending", msg.Status)
+		return
+	}
+
+	v.publishDone(msg.Successful)
+	v.notify(msg)
+
+	if v.Options != nil && v.Options.WebhookURL != "" {
+		if v.webhooks == nil {
+			fmt.Println("v.sendDone(): video id", v.ID, "has a webhook but the pool has no WithWebhooks, not posting to it")
+			return
+		}
+		v.webhooks.deliver(v.Options.WebhookURL, msg)
+	}
+}
+
+// failureMessage describes a failed encode, telling a timeout apart from ffmpeg itself failing
+func (v *Video) failureMessage(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidEncodingType):
+		return fmt.Sprintf("error processing for %d: invalid encoding type", v.ID)
+	case errors.Is(err, ErrInvalidInput):
+		return fmt.Sprintf("rejected %d, %s", v.ID, err.Error())
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Sprintf("encode timed out for %d after %s", v.ID, v.Options.Timeout)
+	default:
+		return fmt.Sprintf("encode failed for %d %s", v.ID, err.Error())
 	}
 }
 
-// New creates and returns a new worker pool
-func New(jobQueue chan VideoProcessingJob, maxWorkers int) *VideoDispatcher {
+// sendRetrying lets whoever is listening on the notify chan know an attempt failed and when the next one starts
+func (v *Video) sendRetrying(kind ErrorKind, failure string, err error, delay time.Duration) {
+	v.notify(ProcessingMessage{
+		ID:         v.ID,
+		Status:     StatusRetrying,
+		Message:    fmt.Sprintf("%s, attempt %d of %d, retrying in %s", failure, v.attempts, v.Options.Retry.MaxAttempts, delay),
+		Attempt:    v.attempts,
+		Priority:   v.Priority,
+		ErrorKind:  kind,
+		StderrTail: ffmpegStderr(err),
+	})
+}
+
+// sendCancelled lets whoever is listening on the notify chan know the video was cancelled before it finished
+func (v *Video) sendCancelled() {
+	v.sendDone(ProcessingMessage{
+		ID:        v.ID,
+		Status:    StatusCancelled,
+		Message:   fmt.Sprintf("encode cancelled for %d", v.ID),
+		Attempt:   v.attempts,
+		Priority:  v.Priority,
+		ErrorKind: ErrorKindCancelled,
+		Timings:   v.Timings,
+	})
+}
+
+// sendStarted lets whoever is listening on the notify chan know a worker has picked up the video
+func (v *Video) sendStarted() {
+	v.notify(ProcessingMessage{
+		ID:       v.ID,
+		Status:   StatusRunning,
+		Message:  fmt.Sprintf("video id %d is being encoded", v.ID),
+		Attempt:  v.attempts,
+		Priority: v.Priority,
+	})
+}
+
+// notify sends msg on the notify chan. It waits for room there until the pool gives up on a shutdown, and drops msg
+// after that if nobody is reading
+func (v *Video) notify(msg ProcessingMessage) {
+	select {
+	case v.NotifyChan <- msg:
+		return
+	default:
+	}
+
+	select {
+	case v.NotifyChan <- msg:
+	case <-v.abandoned:
+		fmt.Println("v.notify(): nobody is reading the notify chan, dropping", msg.Status, "message for video id", v.ID)
+	}
+}
+
+// New creates and returns a new worker pool. Jobs can be sent on jobQueue, or added with TrySubmit. Videos are
+// encoded with ffmpeg, unless the options say otherwise with WithEncoder
+func New(jobQueue chan VideoProcessingJob, maxWorkers int, options ...Option) *VideoDispatcher {
 	fmt.Println("New: Creating worker pool")
 	workerPool := make(chan chan VideoProcessingJob, maxWorkers)
 
-	// Todo: implement processor logic
-	var e VideoEncoder
 	p := Processor{
-		Engine: &e,
+		Engine: &VideoEncoder{},
 	}
 
-	return &VideoDispatcher{
-		jobQueue:   jobQueue,
-		maxWorkers: maxWorkers,
-		WorkerPool: workerPool,
-		Processor:  p,
+	vd := &VideoDispatcher{
+		jobQueue:        jobQueue,
+		maxWorkers:      maxWorkers,
+		WorkerPool:      workerPool,
+		Processor:       p,
+		Progress:        NewProgressBroker(),
+		cancels:         make(map[int]context.CancelFunc),
+		queueLimit:      DefaultQueueLimit,
+		deadLetterLimit: DefaultDeadLetterLimit,
+		wake:            make(chan struct{}, 1),
+		room:            make(chan struct{}, 1),
+		quit:            make(chan struct{}),
+		abandon:         make(chan struct{}),
 	}
+
+	for _, option := range options {
+		option(vd)
+	}
+
+	return vd
 }
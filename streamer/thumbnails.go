@@ -0,0 +1,230 @@
+package streamer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultThumbnailWidth is how wide thumbnails and sprite tiles are when ThumbnailOptions doesn't say
+const DefaultThumbnailWidth = 320
+
+// DefaultSpriteColumns is how many tiles make up a row of the sprite sheet when ThumbnailOptions doesn't say
+const DefaultSpriteColumns = 10
+
+// maxSpriteTiles keeps the sprite sheet of a long video to a sensible size, by spacing the tiles further apart
+const maxSpriteTiles = 400
+
+// ThumbnailOptions says which images to make from a video once it has been encoded
+type ThumbnailOptions struct {
+	PosterAt       time.Duration // where the poster frame is taken from. 0 means a tenth of the way in
+	Count          int           // how many evenly spaced thumbnails to make, 0 for none
+	Width          int           // width of the thumbnails and sprite tiles, the height keeps the aspect ratio
+	SpriteInterval time.Duration // time between the tiles of the sprite sheet, 0 for no sprite sheet
+	SpriteColumns  int           // tiles in a row of the sprite sheet
+}
+
+// Thumbnails are the images made for a video. File names are relative to Video.OutputDir, or URLs when the video is
+// published to a storage
+type Thumbnails struct {
+	Poster    string   `json:"poster"`
+	Images    []string `json:"images"`
+	Sprite    string   `json:"sprite,omitempty"`
+	SpriteVTT string   `json:"sprite_vtt,omitempty"` // WebVTT cues mapping times in the video to tiles of the sprite
+}
+
+// Thumbnailer is implemented by encoders that can make images from a video. When the encoder of a video is one and
+// the video's options ask for thumbnails, they are made after the encode succeeds and go in Video.Thumbnails
+type Thumbnailer interface {
+	Thumbnails(ctx context.Context, v *Video, baseFileName string) (*Thumbnails, error)
+}
+
+// thumbnails makes the images the options of the video ask for, if its encoder knows how
+func (v *Video) thumbnails(ctx context.Context, baseFileName string) error {
+	if v.Options.Thumbnails == nil {
+		return nil
+	}
+
+	t, ok := v.Encoder.Engine.(Thumbnailer)
+	if !ok {
+		return nil
+	}
+
+	thumbs, err := t.Thumbnails(ctx, v, baseFileName)
+	if err != nil {
+		return fmt.Errorf("making thumbnails: %w", err)
+	}
+
+	v.Thumbnails = thumbs
+	return nil
+}
+
+// Thumbnails makes a poster frame, evenly spaced thumbnails and a sprite sheet with its WebVTT index, as the options
+// of the video ask for
+func (ve *VideoEncoder) Thumbnails(ctx context.Context, v *Video, baseFileName string) (*Thumbnails, error) {
+	opts := *v.Options.Thumbnails
+	if opts.Width <= 0 {
+		opts.Width = DefaultThumbnailWidth
+	}
+	if opts.SpriteColumns <= 0 {
+		opts.SpriteColumns = DefaultSpriteColumns
+	}
+
+	media := v.Media
+	if media == nil {
+		var err error
+		if media, err = ve.Probe(ctx, v); err != nil {
+			return nil, err
+		}
+	}
+
+	thumbs := &Thumbnails{
+		Poster: fmt.Sprintf("%s-poster.jpg", baseFileName),
+	}
+
+	err := runFFmpegQuietly(ctx,
+		"-ss", ffmpegTime(posterTime(opts.PosterAt, media.Duration)),
+		"-i", v.InputFile,
+		"-map", media.videoMap(),
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-y", filepath.Join(v.WorkDir(), thumbs.Poster),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// spacing anything out needs to know how long the video is
+	if media.Duration <= 0 {
+		return thumbs, nil
+	}
+
+	tileHeight := scaledHeight(opts.Width, media.Width, media.Height)
+
+	if opts.Count > 0 {
+		pattern := fmt.Sprintf("%s-thumb-%%03d.jpg", baseFileName)
+
+		err := runFFmpegQuietly(ctx, thumbnailArgs(v, media, opts.Count, opts.Width, tileHeight, pattern)...)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 1; i <= opts.Count; i++ {
+			name := fmt.Sprintf(pattern, i)
+			if _, err := os.Stat(filepath.Join(v.WorkDir(), name)); err == nil {
+				thumbs.Images = append(thumbs.Images, name)
+			}
+		}
+	}
+
+	if opts.SpriteInterval > 0 {
+		interval := opts.SpriteInterval
+		tiles := int(math.Ceil(media.Duration.Seconds() / interval.Seconds()))
+		if tiles > maxSpriteTiles {
+			tiles = maxSpriteTiles
+			interval = time.Duration(math.Ceil(float64(media.Duration) / maxSpriteTiles))
+		}
+
+		columns := min(opts.SpriteColumns, tiles)
+		rows := (tiles + columns - 1) / columns
+
+		thumbs.Sprite = fmt.Sprintf("%s-sprite.jpg", baseFileName)
+		thumbs.SpriteVTT = fmt.Sprintf("%s-sprite.vtt", baseFileName)
+
+		err := runFFmpegQuietly(ctx,
+			"-i", v.InputFile,
+			"-map", media.videoMap(),
+			"-vf", fmt.Sprintf("fps=1/%f,scale=%d:%d,tile=%dx%d", interval.Seconds(), opts.Width, tileHeight, columns, rows),
+			"-frames:v", "1",
+			"-q:v", "4",
+			"-y", filepath.Join(v.WorkDir(), thumbs.Sprite),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		vtt := spriteVTT(thumbs.Sprite, media.Duration, interval, tiles, columns, opts.Width, tileHeight)
+		if err := os.WriteFile(filepath.Join(v.WorkDir(), thumbs.SpriteVTT), []byte(vtt), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	return thumbs, nil
+}
+
+// thumbnailArgs builds the ffmpeg arguments that take count frames, one from the middle of each of count equal slices
+// of the video. The fps filter puts its first frame where the input starts, so the input is started half a slice in
+func thumbnailArgs(v *Video, media *MediaInfo, count, width, height int, pattern string) []string {
+	slice := media.Duration / time.Duration(count)
+
+	return []string{
+		"-ss", ffmpegTime(slice / 2),
+		"-i", v.InputFile,
+		"-map", media.videoMap(),
+		"-vf", fmt.Sprintf("fps=%d/%f,scale=%d:%d", count, media.Duration.Seconds(), width, height),
+		"-frames:v", fmt.Sprint(count),
+		"-q:v", "3",
+		"-y", filepath.Join(v.WorkDir(), pattern),
+	}
+}
+
+// posterTime works out where to take the poster frame from. Asking for a time past the end of the video gets the
+// middle of it instead
+func posterTime(at, duration time.Duration) time.Duration {
+	switch {
+	case at <= 0 && duration > 0:
+		return duration / 10
+	case at <= 0:
+		return 0
+	case duration > 0 && at >= duration:
+		return duration / 2
+	default:
+		return at
+	}
+}
+
+// scaledHeight is how high a frame width wide comes out, keeping the aspect ratio of the source and rounding to an
+// even number like ffmpeg wants
+func scaledHeight(width, srcWidth, srcHeight int) int {
+	if srcWidth <= 0 || srcHeight <= 0 {
+		return width * 9 / 16 / 2 * 2
+	}
+
+	h := int(math.Round(float64(width)*float64(srcHeight)/float64(srcWidth)/2)) * 2
+	return max(h, 2)
+}
+
+// spriteVTT writes the WebVTT index of a sprite sheet: one cue per tile, pointing at where the tile is in the image
+// with a media fragment, which is what players use for scrubbing previews
+func spriteVTT(sprite string, duration, interval time.Duration, tiles, columns, width, height int) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	for i := 0; i < tiles; i++ {
+		start := time.Duration(i) * interval
+		end := min(start+interval, duration)
+		if start >= end {
+			break
+		}
+
+		x, y := (i%columns)*width, (i/columns)*height
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTime(start), vttTime(end), sprite, x, y, width, height)
+	}
+
+	return b.String()
+}
+
+// vttTime formats a duration as a WebVTT timestamp, e.g. 00:01:02.500
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// ffmpegTime formats a duration as seconds for ffmpeg's -ss
+func ffmpegTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
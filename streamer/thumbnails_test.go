@@ -0,0 +1,174 @@
+package streamer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPosterTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		at       time.Duration
+		duration time.Duration
+		expected time.Duration
+	}{
+		{"default", 0, 60 * time.Second, 6 * time.Second},
+		{"asked for", 2500 * time.Millisecond, 60 * time.Second, 2500 * time.Millisecond},
+		{"past the end", 90 * time.Second, 60 * time.Second, 30 * time.Second},
+		{"at the end", 60 * time.Second, 60 * time.Second, 30 * time.Second},
+		{"unknown duration", 0, 0, 0},
+		{"asked for with unknown duration", 5 * time.Second, 0, 5 * time.Second},
+		{"negative", -time.Second, 10 * time.Second, time.Second},
+	}
+
+	for _, e := range tests {
+		if got := posterTime(e.at, e.duration); got != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestThumbnailArgs(t *testing.T) {
+	tests := []struct {
+		count    int
+		duration time.Duration
+		expected []time.Duration
+	}{
+		{4, 60 * time.Second, []time.Duration{7500 * time.Millisecond, 22500 * time.Millisecond, 37500 * time.Millisecond, 52500 * time.Millisecond}},
+		{1, 10 * time.Second, []time.Duration{5 * time.Second}},
+		{3, 1500 * time.Millisecond, []time.Duration{250 * time.Millisecond, 750 * time.Millisecond, 1250 * time.Millisecond}},
+	}
+
+	v := &Video{InputFile: "dog.mov", OutputDir: "/videos"}
+	for _, e := range tests {
+		args := thumbnailArgs(v, &MediaInfo{Duration: e.duration}, e.count, 320, 180, "dog-thumb-%03d.jpg")
+
+		var start, interval float64
+		for i, arg := range args {
+			switch {
+			case arg == "-ss":
+				start, _ = strconv.ParseFloat(args[i+1], 64)
+			case arg == "-vf":
+				var frames int
+				var seconds float64
+				if _, err := fmt.Sscanf(args[i+1], "fps=%d/%f,", &frames, &seconds); err != nil {
+					t.Fatalf("%d in %s: can't read %q: %s", e.count, e.duration, args[i+1], err)
+				}
+				interval = seconds / float64(frames)
+			}
+		}
+
+		// the fps filter takes its first frame where the input starts, and one every interval after that
+		for i, want := range e.expected {
+			got := time.Duration((start + float64(i)*interval) * float64(time.Second)).Round(time.Millisecond)
+			if got != want {
+				t.Errorf("%d in %s: expected thumbnail %d at %s, got %s", e.count, e.duration, i+1, want, got)
+			}
+		}
+	}
+}
+
+func TestScaledHeight(t *testing.T) {
+	tests := []struct {
+		name                string
+		width               int
+		srcWidth, srcHeight int
+		expected            int
+	}{
+		{"16:9", 320, 1920, 1080, 180},
+		{"4:3", 320, 640, 480, 240},
+		{"portrait", 160, 1080, 1920, 284},
+		{"rounds to even", 100, 1920, 1080, 56},
+		{"unknown size", 320, 0, 0, 180},
+		{"very wide", 16, 4000, 10, 2},
+	}
+
+	for _, e := range tests {
+		if got := scaledHeight(e.width, e.srcWidth, e.srcHeight); got != e.expected {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestVttTime(t *testing.T) {
+	tests := []struct {
+		d        time.Duration
+		expected string
+	}{
+		{0, "00:00:00.000"},
+		{2500 * time.Millisecond, "00:00:02.500"},
+		{62*time.Second + 5*time.Millisecond, "00:01:02.005"},
+		{time.Hour + 59*time.Minute + 59*time.Second, "01:59:59.000"},
+	}
+
+	for _, e := range tests {
+		if got := vttTime(e.d); got != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.d, e.expected, got)
+		}
+	}
+}
+
+func TestSpriteVTT(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		interval time.Duration
+		tiles    int
+		columns  int
+		expected []string // the cues, without the blank lines between them
+	}{
+		{
+			"wraps onto a second row", 25 * time.Second, 5 * time.Second, 5, 3,
+			[]string{
+				"00:00:00.000 --> 00:00:05.000", "sprite.jpg#xywh=0,0,160,90",
+				"00:00:05.000 --> 00:00:10.000", "sprite.jpg#xywh=160,0,160,90",
+				"00:00:10.000 --> 00:00:15.000", "sprite.jpg#xywh=320,0,160,90",
+				"00:00:15.000 --> 00:00:20.000", "sprite.jpg#xywh=0,90,160,90",
+				"00:00:20.000 --> 00:00:25.000", "sprite.jpg#xywh=160,90,160,90",
+			},
+		},
+		{
+			"last cue ends with the video", 12 * time.Second, 5 * time.Second, 3, 10,
+			[]string{
+				"00:00:00.000 --> 00:00:05.000", "sprite.jpg#xywh=0,0,160,90",
+				"00:00:05.000 --> 00:00:10.000", "sprite.jpg#xywh=160,0,160,90",
+				"00:00:10.000 --> 00:00:12.000", "sprite.jpg#xywh=320,0,160,90",
+			},
+		},
+		{
+			"more tiles than the video has room for", 10 * time.Second, 5 * time.Second, 4, 10,
+			[]string{
+				"00:00:00.000 --> 00:00:05.000", "sprite.jpg#xywh=0,0,160,90",
+				"00:00:05.000 --> 00:00:10.000", "sprite.jpg#xywh=160,0,160,90",
+			},
+		},
+		{
+			"no tiles", 10 * time.Second, 5 * time.Second, 0, 10,
+			nil,
+		},
+	}
+
+	for _, e := range tests {
+		vtt := spriteVTT("sprite.jpg", e.duration, e.interval, e.tiles, e.columns, 160, 90)
+
+		lines := strings.Split(vtt, "\n")
+		if lines[0] != "WEBVTT" {
+			t.Errorf("%s: expected a WEBVTT header, got %q", e.name, lines[0])
+			continue
+		}
+
+		var cues []string
+		for _, line := range lines[1:] {
+			if line != "" {
+				cues = append(cues, line)
+			}
+		}
+
+		if strings.Join(cues, "\n") != strings.Join(e.expected, "\n") {
+			t.Errorf("%s: expected\n%s\ngot\n%s", e.name, strings.Join(e.expected, "\n"), strings.Join(cues, "\n"))
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package streamer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrInvalidWebhook is returned by ValidateWebhookURL for anything but an absolute http or https URL on a public host
+var ErrInvalidWebhook = errors.New("webhook must be an absolute http or https url on a public host")
+
+// ErrWebhookAddress is what a delivery fails with when the host of the webhook resolves to an address that isn't
+// public, like loopback, a private range or a cloud metadata endpoint. Webhooks are posted from inside our network,
+// they mustn't be a way into it
+var ErrWebhookAddress = errors.New("webhook host is not a public address")
+
+// ErrWebhookSignature is returned by VerifyWebhookSignature when a delivery wasn't signed with the secret, or was
+// signed too long ago
+var ErrWebhookSignature = errors.New("invalid webhook signature")
+
+// The headers every webhook delivery is sent with
+const (
+	WebhookIDHeader        = "X-Webhook-Id"        // the same on every attempt at a delivery, to spot repeats
+	WebhookEventHeader     = "X-Webhook-Event"     // video.succeeded, video.failed or video.cancelled
+	WebhookTimestampHeader = "X-Webhook-Timestamp" // unix seconds when the attempt was signed
+	WebhookSignatureHeader = "X-Webhook-Signature" // sha256= and the hex HMAC-SHA256 of the timestamp, a . and the body
+)
+
+// Statuses of a webhook delivery, in WebhookDelivery.Status
+const (
+	WebhookPending   = "pending" // being sent, or waiting to be sent again
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed" // out of attempts, turned away for good, or given up on at shutdown
+)
+
+// DefaultWebhookRetryPolicy is how often deliveries are attempted when NewWebhooks isn't told otherwise
+var DefaultWebhookRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 2 * time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+// DefaultWebhookLogLimit is how many deliveries a Webhooks remembers when NewWebhooks isn't told otherwise
+const DefaultWebhookLogLimit = 100
+
+// defaultWebhookClient sends deliveries for a Webhooks without a Client of its own. An attempt can't take forever,
+// and it only connects to public addresses, whatever the host resolves to and wherever it redirects. It doesn't go
+// through a proxy, which would connect for it without the check
+var defaultWebhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: webhookDialControl}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// WebhookDelivery is a final message being, or having been, posted to the webhook of a video
+type WebhookDelivery struct {
+	ID          string
+	VideoID     int
+	URL         string
+	Event       string
+	Status      string
+	Attempts    int
+	StatusCode  int    // what the last attempt was answered with, 0 if it never got an answer
+	Error       string // why the last attempt failed
+	CreatedAt   time.Time
+	DeliveredAt time.Time // zero until it is delivered
+}
+
+// Webhooks posts the final message of every video with a VideoOptions.WebhookURL to that URL, as JSON signed with
+// Secret. Deliveries that fail are tried again according to Retry, unless they were turned away with a 4xx that
+// isn't worth repeating, and every delivery is kept in a log. Make one with NewWebhooks
+type Webhooks struct {
+	Secret   string
+	Client   *http.Client // one with a 10 second timeout that only connects to public addresses when nil
+	Retry    RetryPolicy
+	LogLimit int // how many deliveries are kept in the log, 0 for no limit
+
+	mu         sync.Mutex
+	deliveries []*WebhookDelivery // oldest first
+	sending    sync.WaitGroup     // deliveries that haven't been delivered or given up on
+	ctx        context.Context    // cancelled by Shutdown when its deadline passes
+	cancel     context.CancelFunc
+}
+
+// NewWebhooks returns a Webhooks that signs deliveries with secret, retried according to DefaultWebhookRetryPolicy
+func NewWebhooks(secret string) *Webhooks {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Webhooks{
+		Secret:   secret,
+		Retry:    DefaultWebhookRetryPolicy,
+		LogLimit: DefaultWebhookLogLimit,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// WithWebhooks has the pool send the final message of videos that have a webhook through w
+func WithWebhooks(w *Webhooks) Option {
+	return func(vd *VideoDispatcher) {
+		vd.webhooks = w
+	}
+}
+
+// ValidateWebhookURL checks s is somewhere a webhook can be posted to. Hosts that are plainly not public, like
+// localhost or a private IP address, are turned away here. Names that resolve to one are refused when the delivery
+// connects
+func ValidateWebhookURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidWebhook, s)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %q", ErrInvalidWebhook, s)
+	}
+
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return fmt.Errorf("%w: %q", ErrInvalidWebhook, s)
+	}
+
+	return nil
+}
+
+// webhookDialControl refuses connections to addresses that aren't public. It runs once the host has been resolved,
+// for every address tried, so a name pointing somewhere private can't get around ValidateWebhookURL
+func webhookDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrWebhookAddress, host)
+	}
+
+	return nil
+}
+
+// nonPublicNets are the ranges that aren't on the internet but net.IP doesn't know about: carrier-grade NAT, IETF
+// protocol assignments, benchmarking, and NAT64, which reaches whatever IPv4 address is put in it
+var nonPublicNets = mustParseCIDRs("100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "64:ff9b::/96")
+
+// mustParseCIDRs parses a list of ranges we know are valid
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// publicIP reports whether ip is an address on the internet: not loopback, private, link-local (where cloud metadata
+// endpoints like 169.254.169.254 live), unspecified, multicast or in one of the nonPublicNets
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() {
+		return false
+	}
+
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SignWebhook returns the signature of a delivery of body at timestamp (unix seconds), as it goes in the
+// X-Webhook-Signature header
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks a delivery with the given headers and body was signed with secret no more than maxAge
+// ago, for whoever receives webhooks. A maxAge of 0 accepts signatures of any age
+func VerifyWebhookSignature(secret string, header http.Header, body []byte, maxAge time.Duration) error {
+	timestamp, err := strconv.ParseInt(header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: no timestamp", ErrWebhookSignature)
+	}
+
+	if maxAge > 0 && time.Since(time.Unix(timestamp, 0)).Abs() > maxAge {
+		return fmt.Errorf("%w: signed too long ago", ErrWebhookSignature)
+	}
+
+	if !hmac.Equal([]byte(header.Get(WebhookSignatureHeader)), []byte(SignWebhook(secret, timestamp, body))) {
+		return ErrWebhookSignature
+	}
+
+	return nil
+}
+
+// Deliveries returns the delivery log, oldest first
+func (w *Webhooks) Deliveries() []WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	deliveries := make([]WebhookDelivery, 0, len(w.deliveries))
+	for _, d := range w.deliveries {
+		deliveries = append(deliveries, *d)
+	}
+
+	return deliveries
+}
+
+// Shutdown waits for the deliveries that are still going, retries included. If ctx ends first they are given up on,
+// and ctx's error is returned once they have stopped
+func (w *Webhooks) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		w.sending.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// deliver starts posting msg to url in the background
+func (w *Webhooks) deliver(url string, msg ProcessingMessage) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Println("w.deliver(): could not encode the message for video id", msg.ID, err)
+		return
+	}
+
+	d := &WebhookDelivery{
+		ID:        newDeliveryID(),
+		VideoID:   msg.ID,
+		URL:       url,
+		Event:     "video." + msg.Status,
+		Status:    WebhookPending,
+		CreatedAt: time.Now(),
+	}
+
+	w.mu.Lock()
+	w.deliveries = append(w.deliveries, d)
+	if w.LogLimit > 0 && len(w.deliveries) > w.LogLimit {
+		w.deliveries = append([]*WebhookDelivery(nil), w.deliveries[len(w.deliveries)-w.LogLimit:]...)
+	}
+	w.mu.Unlock()
+
+	w.sending.Add(1)
+	go func() {
+		defer w.sending.Done()
+		w.send(d, body)
+	}()
+}
+
+// send makes attempts at a delivery until one works, or the retry policy says to stop
+func (w *Webhooks) send(d *WebhookDelivery, body []byte) {
+	for attempt := 1; ; attempt++ {
+		statusCode, err := w.post(d, body)
+
+		w.mu.Lock()
+		d.Attempts = attempt
+		d.StatusCode = statusCode
+		d.Error = ""
+		if err != nil {
+			d.Error = err.Error()
+		}
+
+		// an address that isn't public won't become one by trying again
+		retry := err != nil && !errors.Is(err, ErrWebhookAddress) && retryableStatus(statusCode) && w.Retry.allows(attempt) && w.ctx.Err() == nil
+		switch {
+		case err == nil:
+			d.Status = WebhookDelivered
+			d.DeliveredAt = time.Now()
+		case !retry:
+			d.Status = WebhookFailed
+		}
+		w.mu.Unlock()
+
+		if !retry {
+			if err != nil {
+				fmt.Println("w.send(): giving up on webhook", d.ID, "for video id", d.VideoID, "after", attempt, "attempts:", err)
+			}
+			return
+		}
+
+		timer := time.NewTimer(w.Retry.Backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-w.ctx.Done():
+			timer.Stop()
+			w.mu.Lock()
+			d.Status = WebhookFailed
+			d.Error = "shut down before it could be delivered: " + d.Error
+			w.mu.Unlock()
+			return
+		}
+	}
+}
+
+// post makes one attempt at a delivery, signed just before it goes
+func (w *Webhooks) post(d *WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-breeders-webhooks")
+	req.Header.Set(WebhookIDHeader, d.ID)
+	req.Header.Set(WebhookEventHeader, d.Event)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, timestamp, body))
+
+	client := w.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// let the connection be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// retryableStatus reports whether an attempt answered with statusCode is worth making again. No answer at all, a
+// server error, a timeout or being told to slow down are, the other 4xx mean the receiver doesn't want it
+func retryableStatus(statusCode int) bool {
+	return statusCode == 0 || statusCode >= 500 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+}
+
+// newDeliveryID returns a random id for a delivery
+func newDeliveryID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,306 @@
+package streamer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a stand-in for the service a webhook posts to. It answers with the statuses it is given, one per
+// request, then 200s, and keeps the messages that were signed properly
+type webhookReceiver struct {
+	secret string
+
+	mu       sync.Mutex
+	statuses []int
+	ids      []string
+	messages []ProcessingMessage
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := VerifyWebhookSignature(wr.secret, r.Header, body, time.Minute); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	wr.ids = append(wr.ids, r.Header.Get(WebhookIDHeader))
+
+	if len(wr.statuses) > 0 {
+		status := wr.statuses[0]
+		wr.statuses = wr.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	var msg ProcessingMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wr.messages = append(wr.messages, msg)
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		signature string
+		valid     bool
+	}{
+		{"signed", now, SignWebhook("secret", now, body), true},
+		{"wrong secret", now, SignWebhook("guess", now, body), false},
+		{"timestamp changed", now + 1, SignWebhook("secret", now, body), false},
+		{"too old", now - 3600, SignWebhook("secret", now-3600, body), false},
+	}
+
+	for _, e := range tests {
+		header := http.Header{}
+		header.Set(WebhookTimestampHeader, strconv.FormatInt(e.timestamp, 10))
+		header.Set(WebhookSignatureHeader, e.signature)
+
+		err := VerifyWebhookSignature("secret", header, body, time.Minute)
+		if e.valid != (err == nil) {
+			t.Errorf("%s: expected valid %t, got %v", e.name, e.valid, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrWebhookSignature) {
+			t.Errorf("%s: expected ErrWebhookSignature, got %v", e.name, err)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://cms.example.com/hooks/videos", true},
+		{"http://cms.example.com:8080/hooks", true},
+		{"https://93.184.216.34/hooks", true},
+		{"https://[2606:2800:220:1:248:1893:25c7:1946]/hooks", true},
+		{"ftp://cms.example.com/hooks", false},
+		{"/hooks/videos", false},
+		{"https://", false},
+		{"http://localhost:8080/hooks", false},
+		{"http://api.localhost/hooks", false},
+		{"http://127.0.0.1/hooks", false},
+		{"http://10.0.0.5/hooks", false},
+		{"http://172.16.3.4/hooks", false},
+		{"http://192.168.1.1/hooks", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://0.0.0.0/hooks", false},
+		{"http://[::1]:8080/hooks", false},
+		{"http://[fe80::1]/hooks", false},
+		{"http://[fd00::1]/hooks", false},
+		{"http://[::ffff:127.0.0.1]/hooks", false},
+		{"http://100.100.100.200/hooks", false},
+		{"http://[64:ff9b::a9fe:a9fe]/hooks", false},
+	}
+
+	for _, e := range tests {
+		err := ValidateWebhookURL(e.url)
+		if e.valid != (err == nil) {
+			t.Errorf("%s: expected valid %t, got %v", e.url, e.valid, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("%s: expected ErrInvalidWebhook, got %v", e.url, err)
+		}
+	}
+}
+
+func TestWebhookDialControl(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c7:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"127.8.9.10:80", false},
+		{"10.1.2.3:443", false},
+		{"172.31.255.255:443", false},
+		{"192.168.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"0.0.0.0:80", false},
+		{"224.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"[::]:80", false},
+		{"[fe80::1]:80", false},
+		{"[fc00::1]:80", false},
+		{"[::ffff:10.0.0.1]:80", false},
+		{"100.64.0.1:443", false},
+		{"100.127.255.255:443", false},
+		{"100.128.0.1:443", true},
+		{"192.0.0.8:443", false},
+		{"198.18.0.1:443", false},
+		{"198.19.255.255:443", false},
+		{"198.20.0.1:443", true},
+		{"[64:ff9b::a00:1]:443", false},
+		{"[64:ff9b::5db8:d822]:443", false},
+		{"[::ffff:100.64.0.1]:443", false},
+	}
+
+	for _, e := range tests {
+		err := webhookDialControl("tcp", e.address, nil)
+		if e.allowed != (err == nil) {
+			t.Errorf("%s: expected allowed %t, got %v", e.address, e.allowed, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrWebhookAddress) {
+			t.Errorf("%s: expected ErrWebhookAddress, got %v", e.address, err)
+		}
+	}
+}
+
+func TestWebhooks_deliverRefusesPrivateAddresses(t *testing.T) {
+	receiver := &webhookReceiver{secret: "secret"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// the test server is on loopback, like a name that resolves there would be. It gets past ValidateWebhookURL, but
+	// the default client still won't connect to it
+	w := NewWebhooks("secret")
+	w.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	w.deliver(server.URL, ProcessingMessage{ID: 7, Status: StatusSucceeded})
+	_ = w.Shutdown(context.Background())
+
+	d := w.Deliveries()[0]
+	if d.Status != WebhookFailed || d.Attempts != 1 || !strings.Contains(d.Error, ErrWebhookAddress.Error()) {
+		t.Errorf("expected the delivery to be refused without retrying, got %+v", d)
+	}
+
+	if len(receiver.ids) != 0 {
+		t.Errorf("the webhook was posted to %s", server.URL)
+	}
+}
+
+func TestWebhooks_deliver(t *testing.T) {
+	tests := []struct {
+		name             string
+		statuses         []int
+		expectedStatus   string
+		expectedAttempts int
+		delivered        bool
+	}{
+		{"first time", nil, WebhookDelivered, 1, true},
+		{"after server errors", []int{http.StatusInternalServerError, http.StatusServiceUnavailable}, WebhookDelivered, 3, true},
+		{"turned away", []int{http.StatusGone}, WebhookFailed, 1, false},
+		{"out of attempts", []int{500, 500, 500, 500}, WebhookFailed, 3, false},
+	}
+
+	for _, e := range tests {
+		receiver := &webhookReceiver{secret: "secret", statuses: e.statuses}
+		server := httptest.NewServer(receiver)
+
+		w := NewWebhooks("secret")
+		w.Client = server.Client() // the test server is on loopback, which the default client won't connect to
+		w.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+		w.deliver(server.URL, ProcessingMessage{ID: 7, Status: StatusSucceeded, Successful: true, OutputFile: "dog.mp4"})
+		_ = w.Shutdown(context.Background())
+		server.Close()
+
+		deliveries := w.Deliveries()
+		if len(deliveries) != 1 {
+			t.Fatalf("%s: expected one delivery, got %d", e.name, len(deliveries))
+		}
+
+		d := deliveries[0]
+		if d.Status != e.expectedStatus || d.Attempts != e.expectedAttempts {
+			t.Errorf("%s: expected %s after %d attempts, got %s after %d: %s", e.name, e.expectedStatus, e.expectedAttempts, d.Status, d.Attempts, d.Error)
+		}
+
+		if d.Event != "video.succeeded" || d.VideoID != 7 {
+			t.Errorf("%s: wrong delivery %+v", e.name, d)
+		}
+
+		if e.delivered && (len(receiver.messages) != 1 || receiver.messages[0].OutputFile != "dog.mp4") {
+			t.Errorf("%s: the message was not received, got %+v", e.name, receiver.messages)
+		}
+
+		for _, id := range receiver.ids {
+			if id != d.ID {
+				t.Errorf("%s: attempt sent with id %s, expected %s", e.name, id, d.ID)
+			}
+		}
+	}
+}
+
+func TestWebhooks_Shutdown(t *testing.T) {
+	receiver := &webhookReceiver{secret: "secret", statuses: []int{500}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := NewWebhooks("secret")
+	w.Client = server.Client()
+	w.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute}
+	w.deliver(server.URL, ProcessingMessage{ID: 7, Status: StatusFailed})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := w.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to pass waiting for the retry, got %v", err)
+	}
+
+	if d := w.Deliveries()[0]; d.Status != WebhookFailed {
+		t.Errorf("expected the delivery to be given up on, got %s", d.Status)
+	}
+}
+
+func TestVideoDispatcher_webhooks(t *testing.T) {
+	receiver := &webhookReceiver{secret: "secret"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := NewWebhooks("secret")
+	w.Client = server.Client()
+	fake := &FakeEncoder{Errors: map[int]error{2: errors.New("ffmpeg crashed")}}
+	vd := New(make(chan VideoProcessingJob), 2, WithEncoder(fake), WithWebhooks(w))
+	notify := make(chan ProcessingMessage, 100)
+
+	// only the videos that ask for a webhook get one
+	submit(t, vd, notify, 1, PriorityNormal, &VideoOptions{WebhookURL: server.URL})
+	submit(t, vd, notify, 2, PriorityNormal, &VideoOptions{WebhookURL: server.URL})
+	submit(t, vd, notify, 3, PriorityNormal, nil)
+
+	vd.Run()
+	results(t, notify, 3)
+	vd.Stop()
+	_ = w.Shutdown(context.Background())
+
+	received := make(map[int]ProcessingMessage)
+	for _, msg := range receiver.messages {
+		received[msg.ID] = msg
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("expected webhooks for videos 1 and 2, got %+v", receiver.messages)
+	}
+
+	if msg := received[1]; msg.Status != StatusSucceeded || msg.OutputFile != "1.mp4" {
+		t.Errorf("wrong message for video 1: %+v", msg)
+	}
+
+	if msg := received[2]; msg.Status != StatusFailed || msg.ErrorKind != ErrorKindEncoderFailed {
+		t.Errorf("wrong message for video 2: %+v", msg)
+	}
+}